@@ -0,0 +1,72 @@
+package vsock_sdk
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/auth"
+	"github.com/brodyxchen/vsock-sdk/client"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/server"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientAccessControl(t *testing.T) {
+	denyAddr := &models.HttpAddr{IP: "127.0.0.1", Port: 7083}
+	denySrv := NewServer(denyAddr)
+	denySrv.ACL = &server.ACL{CIDRs: []string{"10.0.0.0/8"}}
+
+	addr := &models.HttpAddr{IP: "127.0.0.1", Port: 7084}
+	srv := NewServer(addr)
+	srv.ACL = &server.ACL{CIDRs: []string{"127.0.0.0/8"}}
+	srv.Authenticator = auth.NewTokenAuthenticator(map[string]string{"ops-token": "ops", "dev-token": "dev"})
+	srv.Rules = []server.Rule{
+		{Path: "admin/*", Principals: []string{"ops"}},
+		{Path: "echo"},
+	}
+	srv.DefaultDeny = true
+
+	for _, s := range []*server.Server{denySrv, srv} {
+		s.HandleContextFunc("echo", func(ctx context.Context, req []byte) ([]byte, error) {
+			return req, nil
+		})
+		s.HandleContextFunc("admin/reload", func(ctx context.Context, req []byte) ([]byte, error) {
+			return []byte("reloaded"), nil
+		})
+		s.HandleContextFunc("hidden", func(ctx context.Context, req []byte) ([]byte, error) {
+			return []byte("hidden"), nil
+		})
+		go func(s *server.Server) {
+			_ = s.ListenAndServe()
+		}(s)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	cli := NewClient(&client.Config{Timeout: time.Second, Credentials: auth.Bearer("dev-token")})
+
+	// 连接在 Accept 后被关闭
+	if _, err := cli.Do(denyAddr, "echo", []byte("hi")); err == nil {
+		t.Fatal("acl denied peer served")
+	}
+	if n := denySrv.Denials()["127.0.0.1"]; n == 0 {
+		t.Fatalf("acl denials: %v", denySrv.Denials())
+	}
+
+	if rsp, err := cli.Do(addr, "echo", []byte("hi")); err != nil || string(rsp) != "hi" {
+		t.Fatalf("echo: %q %v", rsp, err)
+	}
+	if _, err := cli.Do(addr, "admin/reload", nil); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatalf("dev admin: %v", err)
+	}
+	if _, err := cli.Do(addr, "hidden", nil); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatalf("default deny: %v", err)
+	}
+	ops := client.WithCredentials(context.Background(), auth.Bearer("ops-token"))
+	if rsp, err := cli.DoContext(ops, addr, "admin/reload", nil); err != nil || string(rsp) != "reloaded" {
+		t.Fatalf("ops admin: %q %v", rsp, err)
+	}
+	// 复用的连接上失败的调用会重试一次, 拒绝次数可能多于调用次数
+	if n := srv.Denials()["127.0.0.1"]; n < 2 {
+		t.Fatalf("route denials: %v", srv.Denials())
+	}
+}
@@ -0,0 +1,44 @@
+package vsock_sdk
+
+import (
+	"bytes"
+	"github.com/brodyxchen/vsock-sdk/client"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/secure"
+	"testing"
+	"time"
+)
+
+func TestClientAttestation(t *testing.T) {
+	pcr0 := bytes.Repeat([]byte{7}, 48)
+	attester := secure.NewFakeAttester("enclave", map[uint32][]byte{0: pcr0})
+
+	addr := &models.HttpAddr{IP: "127.0.0.1", Port: 7081}
+	srv := NewServer(addr)
+	srv.Secure = &secure.Config{Attester: attester}
+	srv.HandleFunc("echo", func(req []byte) ([]byte, error) {
+		return req, nil
+	})
+	go func() {
+		_ = srv.ListenAndServe()
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	cli := NewClient(&client.Config{
+		Timeout: time.Second,
+		Secure:  &secure.Config{Verifier: attester.Verifier(), PCRs: map[uint32][]byte{0: pcr0}},
+	})
+	rsp, err := cli.Do(addr, "echo", []byte("secret"))
+	if err != nil || string(rsp) != "secret" {
+		t.Fatalf("attested echo: %q %v", rsp, err)
+	}
+
+	// 度量值不符时不发送任何请求
+	wrong := NewClient(&client.Config{
+		Timeout: time.Second,
+		Secure:  &secure.Config{Verifier: attester.Verifier(), PCRs: map[uint32][]byte{0: make([]byte, 48)}},
+	})
+	if _, err = wrong.Do(addr, "echo", []byte("secret")); err == nil {
+		t.Fatal("measurement mismatch accepted")
+	}
+}
@@ -0,0 +1,168 @@
+// Package audit 内置的 server.AuditSink 实现
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/log"
+	"github.com/brodyxchen/vsock-sdk/server"
+	"io"
+	"os"
+	"sync"
+)
+
+// entry 文件中的一行. Hash = SHA-256(Prev 的字节 || 去掉 hash 字段的这一行),
+// 每行依赖上一行的 Hash, 修改、删除或插入任何一行都会使之后的校验失败
+type entry struct {
+	Seq uint64 `json:"seq"`
+	*server.AuditRecord
+	Prev string `json:"prev"`
+	Hash string `json:"hash,omitempty"`
+}
+
+// hashSuffix 行尾 `,"hash":"<64位hex>"}`
+const hashSuffix = len(`,"hash":""}`) + sha256.Size*2
+
+// FileSink 以 JSON lines 追加写入文件, 实现 server.AuditSink.
+// 打开已有的文件时从最后一行继续哈希链. 截断文件末尾的若干行无法从文件本身发现,
+// 需要另外保存 Head 返回的最新哈希并在校验时比对
+type FileSink struct {
+	mutex sync.Mutex
+	file  *os.File
+	seq   uint64
+	head  []byte // 最后一行的 Hash
+	err   error  // 第一次写入失败的错误, 之后的记录被丢弃
+}
+
+// OpenFileSink 文件不存在时创建; 已有的内容先被校验, 链断开时返回错误.
+// 崩溃留下的不完整的最后一行(没有换行)被截掉, 从最后一条完整的记录继续
+func OpenFileSink(path string) (*FileSink, error) {
+	seq, head, size, err := verify(path)
+	if err == errors.ErrAuditTornTail {
+		log.Errorf("audit %v: truncate incomplete line after record %d\n", path, seq)
+		err = os.Truncate(path, size)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{file: file, seq: seq, head: head}, nil
+}
+
+func (fs *FileSink) Audit(rec *server.AuditRecord) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if fs.err != nil {
+		return
+	}
+
+	line, err := json.Marshal(&entry{Seq: fs.seq + 1, AuditRecord: rec, Prev: hex.EncodeToString(fs.head)})
+	if err != nil {
+		log.Errorf("audit marshal: %v\n", err)
+		return
+	}
+	hash := chainHash(fs.head, line)
+	line = append(line[:len(line)-1], `,"hash":"`...)
+	line = append(append(line, hex.EncodeToString(hash)...), "\"}\n"...)
+
+	// 一次 Write 写出整行, O_APPEND 保证不与其它写入交错
+	if _, err = fs.file.Write(line); err != nil {
+		fs.err = err
+		log.Errorf("audit write, following records dropped: %v\n", err)
+		return
+	}
+	fs.seq++
+	fs.head = hash
+}
+
+// Head 最后一条记录的序号和哈希
+func (fs *FileSink) Head() (uint64, string) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	return fs.seq, hex.EncodeToString(fs.head)
+}
+
+// Err 写入失败的错误
+func (fs *FileSink) Err() error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	return fs.err
+}
+
+// Close 落盘后关闭文件
+func (fs *FileSink) Close() error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if err := fs.file.Sync(); err != nil {
+		_ = fs.file.Close()
+		return err
+	}
+	return fs.file.Close()
+}
+
+// Verify 校验整个文件的哈希链, 返回记录数和最后一条记录的哈希.
+// 最后一行不完整时返回 errors.ErrAuditTornTail 和之前完整的记录
+func Verify(path string) (uint64, string, error) {
+	seq, head, _, err := verify(path)
+	return seq, hex.EncodeToString(head), err
+}
+
+// verify size 为校验通过的记录占用的字节数
+func verify(path string) (uint64, []byte, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	defer file.Close()
+
+	var (
+		seq    uint64
+		head   []byte
+		size   int64
+		reader = bufio.NewReader(file)
+	)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return seq, head, size, nil
+		}
+		if err == io.EOF {
+			// 每行以一次 Write 写出, 没有换行的最后一行是写到一半时崩溃留下的
+			return seq, head, size, errors.ErrAuditTornTail
+		}
+		if err != nil {
+			return seq, head, size, err
+		}
+		n := int64(len(line))
+		line = bytes.TrimSuffix(line, []byte("\n"))
+
+		var e entry
+		if len(line) <= hashSuffix || json.Unmarshal(line, &e) != nil {
+			return seq, head, size, fmt.Errorf("audit line %d: malformed", seq+1)
+		}
+		if e.Seq != seq+1 || e.Prev != hex.EncodeToString(head) {
+			return seq, head, size, fmt.Errorf("audit line %d: chain broken", seq+1)
+		}
+		unsigned := append(line[:len(line)-hashSuffix:len(line)-hashSuffix], '}')
+		hash := chainHash(head, unsigned)
+		if e.Hash != hex.EncodeToString(hash) {
+			return seq, head, size, fmt.Errorf("audit line %d: hash mismatch", seq+1)
+		}
+		seq, head, size = e.Seq, hash, size+n
+	}
+}
+
+func chainHash(prev, line []byte) []byte {
+	h := sha256.New()
+	h.Write(prev)
+	h.Write(line)
+	return h.Sum(nil)
+}
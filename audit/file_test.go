@@ -0,0 +1,92 @@
+package audit
+
+import (
+	"bytes"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/server"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func record(path string) *server.AuditRecord {
+	return &server.AuditRecord{Time: time.Now(), Kind: "call", Peer: "127.0.0.1:1", Path: path, Code: 200}
+}
+
+func TestFileSinkChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := OpenFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Audit(record("a"))
+	sink.Audit(record("b"))
+	if err = sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新打开后继续同一条链
+	sink, err = OpenFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Audit(record("c"))
+	seq, head := sink.Head()
+	_ = sink.Close()
+
+	if n, h, err := Verify(path); err != nil || n != 3 || n != seq || h != head {
+		t.Fatalf("verify: %d %s %v, head %d %s", n, h, err, seq, head)
+	}
+
+	data, _ := os.ReadFile(path)
+	lines := bytes.SplitAfter(data, []byte("\n"))
+
+	// 修改一条记录的内容
+	tampered := bytes.Replace(data, []byte(`"path":"b"`), []byte(`"path":"x"`), 1)
+	_ = os.WriteFile(path, tampered, 0o600)
+	if n, _, err := Verify(path); err == nil || n != 1 {
+		t.Fatalf("tampered: %d %v", n, err)
+	}
+	if _, err = OpenFileSink(path); err == nil {
+		t.Fatal("open tampered file")
+	}
+
+	// 删除中间的一行
+	_ = os.WriteFile(path, append(append([]byte(nil), lines[0]...), lines[2]...), 0o600)
+	if n, _, err := Verify(path); err == nil || n != 1 {
+		t.Fatalf("deleted: %d %v", n, err)
+	}
+}
+
+func TestFileSinkTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := OpenFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Audit(record("a"))
+	sink.Audit(record("b"))
+	_ = sink.Close()
+
+	// 写最后一行时崩溃
+	data, _ := os.ReadFile(path)
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	torn := append(append([]byte(nil), lines[0]...), lines[1][:len(lines[1])/2]...)
+	_ = os.WriteFile(path, torn, 0o600)
+	if n, _, err := Verify(path); err != errors.ErrAuditTornTail || n != 1 {
+		t.Fatalf("torn: %d %v", n, err)
+	}
+
+	// 打开时截掉不完整的一行, 从第一条记录继续
+	sink, err = OpenFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Audit(record("c"))
+	seq, head := sink.Head()
+	_ = sink.Close()
+	if n, h, err := Verify(path); err != nil || n != 2 || seq != 2 || h != head {
+		t.Fatalf("verify: %d %s %v, head %d %s", n, h, err, seq, head)
+	}
+}
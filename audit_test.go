@@ -0,0 +1,73 @@
+package vsock_sdk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/brodyxchen/vsock-sdk/auth"
+	"github.com/brodyxchen/vsock-sdk/client"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/server"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memoryAuditSink struct {
+	mutex   sync.Mutex
+	records []server.AuditRecord
+}
+
+func (m *memoryAuditSink) Audit(rec *server.AuditRecord) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.records = append(m.records, *rec)
+}
+
+func (m *memoryAuditSink) snapshot() []server.AuditRecord {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]server.AuditRecord(nil), m.records...)
+}
+
+func TestClientAudit(t *testing.T) {
+	sink := &memoryAuditSink{}
+	addr := &models.HttpAddr{IP: "127.0.0.1", Port: 7085}
+	srv := NewServer(addr)
+	srv.Authenticator = auth.NewTokenAuthenticator(map[string]string{"token": "alice"})
+	srv.AuditSink = sink
+	srv.HandleFunc("echo", func(req []byte) ([]byte, error) {
+		return req, nil
+	})
+	srv.HandleFunc("fail", func(req []byte) ([]byte, error) {
+		return nil, errors.New("boom")
+	})
+	go func() {
+		_ = srv.ListenAndServe()
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	cli := NewClient(&client.Config{Timeout: time.Second, Credentials: auth.Bearer("token")})
+	if _, err := cli.Do(addr, "echo", []byte("payload")); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = cli.Do(addr, "fail", nil)
+	_, _ = NewClient(&client.Config{Timeout: time.Second}).Do(addr, "echo", nil)
+
+	records := sink.snapshot()
+	if len(records) < 3 {
+		t.Fatalf("records: %+v", records)
+	}
+	digest := sha256.Sum256([]byte("payload"))
+	echo := records[0]
+	if echo.Kind != "call" || echo.Path != "echo" || echo.Principal != "alice" || echo.Code != 200 ||
+		echo.RequestSize != 7 || echo.ResponseSize != 7 || echo.RequestDigest != hex.EncodeToString(digest[:]) || echo.Latency <= 0 {
+		t.Fatalf("echo record: %+v", echo)
+	}
+	if fail := records[1]; fail.Path != "fail" || fail.Code != 301 || fail.Error != "boom" {
+		t.Fatalf("fail record: %+v", fail)
+	}
+	if denied := records[len(records)-1]; denied.Path != "echo" || denied.Principal != "" || denied.Code != 407 {
+		t.Fatalf("denied record: %+v", denied)
+	}
+}
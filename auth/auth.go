@@ -0,0 +1,26 @@
+// Package auth 内置的请求认证方式: hmac 签名和 bearer token.
+// 认证数据编码为 protocols.Credential, 由 client.Credentials 生成, server.Authenticator 校验
+package auth
+
+import (
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/protocols"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	SchemeHMAC   = "hmac-sha256"
+	SchemeBearer = "bearer"
+)
+
+// decode 解析认证数据并检查 scheme
+func decode(credential []byte, scheme string) (*protocols.Credential, error) {
+	if len(credential) == 0 {
+		return nil, errors.ErrInvalidCredential
+	}
+	var cred protocols.Credential
+	if err := proto.Unmarshal(credential, &cred); err != nil || cred.Scheme != scheme {
+		return nil, errors.ErrInvalidCredential
+	}
+	return &cred, nil
+}
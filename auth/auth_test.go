@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/protocols"
+	"google.golang.org/protobuf/proto"
+	"testing"
+	"time"
+)
+
+func TestHMAC(t *testing.T) {
+	ctx := context.Background()
+	creds := &HMAC{KeyID: "svc", Key: []byte("secret")}
+	a := NewHMACAuthenticator(map[string][]byte{"svc": []byte("secret")})
+
+	cred, _ := creds.Credential("echo", []byte("body"))
+	if principal, err := a.Authenticate(ctx, "echo", []byte("body"), cred); err != nil || principal != "svc" {
+		t.Fatalf("authenticate: %q %v", principal, err)
+	}
+	if _, err := a.Authenticate(ctx, "echo", []byte("body"), cred); err != errors.ErrCredentialReplayed {
+		t.Fatalf("replayed: %v", err)
+	}
+
+	cred, _ = creds.Credential("echo", []byte("body"))
+	if _, err := a.Authenticate(ctx, "echo", []byte("other"), cred); err != errors.ErrInvalidCredential {
+		t.Fatalf("tampered body: %v", err)
+	}
+	if _, err := a.Authenticate(ctx, "admin", []byte("body"), cred); err != errors.ErrInvalidCredential {
+		t.Fatalf("tampered path: %v", err)
+	}
+
+	wrong, _ := (&HMAC{KeyID: "svc", Key: []byte("guess")}).Credential("echo", nil)
+	if _, err := a.Authenticate(ctx, "echo", nil, wrong); err != errors.ErrInvalidCredential {
+		t.Fatalf("wrong key: %v", err)
+	}
+	unknown, _ := (&HMAC{KeyID: "other", Key: []byte("secret")}).Credential("echo", nil)
+	if _, err := a.Authenticate(ctx, "echo", nil, unknown); err != errors.ErrUnknownCredential {
+		t.Fatalf("unknown key: %v", err)
+	}
+	if _, err := a.Authenticate(ctx, "echo", nil, nil); err != errors.ErrInvalidCredential {
+		t.Fatalf("missing: %v", err)
+	}
+
+	// 签名正确但时间戳超出范围
+	old := time.Now().Add(-time.Minute).UnixMilli()
+	nonce := make([]byte, nonceSize)
+	expired, _ := proto.Marshal(&protocols.Credential{
+		Scheme:    SchemeHMAC,
+		KeyId:     "svc",
+		Timestamp: old,
+		Nonce:     nonce,
+		Signature: hmacSign([]byte("secret"), "svc", old, nonce, "echo", nil),
+	})
+	if _, err := a.Authenticate(ctx, "echo", nil, expired); err != errors.ErrCredentialExpired {
+		t.Fatalf("expired: %v", err)
+	}
+}
+
+func TestHMACNonceLimit(t *testing.T) {
+	ctx := context.Background()
+	creds := &HMAC{KeyID: "svc", Key: []byte("secret")}
+	a := NewHMACAuthenticator(map[string][]byte{"svc": []byte("secret")})
+	a.MaxNonces = 2
+	a.MaxSkew = 50 * time.Millisecond
+
+	for i := 0; i < 2; i++ {
+		cred, _ := creds.Credential("echo", nil)
+		if _, err := a.Authenticate(ctx, "echo", nil, cred); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cred, _ := creds.Credential("echo", nil)
+	if _, err := a.Authenticate(ctx, "echo", nil, cred); err != errors.ErrTooManyNonces {
+		t.Fatalf("full: %v", err)
+	}
+
+	// 过期的 nonce 被清理后恢复
+	time.Sleep(120 * time.Millisecond)
+	cred, _ = creds.Credential("echo", nil)
+	if _, err := a.Authenticate(ctx, "echo", nil, cred); err != nil {
+		t.Fatalf("after expiry: %v", err)
+	}
+}
+
+func TestBearer(t *testing.T) {
+	ctx := context.Background()
+	a := NewTokenAuthenticator(map[string]string{"t0ken": "alice"})
+
+	cred, _ := Bearer("t0ken").Credential("echo", nil)
+	if principal, err := a.Authenticate(ctx, "echo", nil, cred); err != nil || principal != "alice" {
+		t.Fatalf("authenticate: %q %v", principal, err)
+	}
+	cred, _ = Bearer("guess").Credential("echo", nil)
+	if _, err := a.Authenticate(ctx, "echo", nil, cred); err != errors.ErrUnknownCredential {
+		t.Fatalf("unknown token: %v", err)
+	}
+
+	// 不同 scheme 的认证数据不被接受
+	cred, _ = (&HMAC{KeyID: "alice", Key: []byte("k")}).Credential("echo", nil)
+	if _, err := a.Authenticate(ctx, "echo", nil, cred); err != errors.ErrInvalidCredential {
+		t.Fatalf("scheme: %v", err)
+	}
+}
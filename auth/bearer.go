@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/protocols"
+	"google.golang.org/protobuf/proto"
+)
+
+// Bearer 每个请求附带同一个 token, 实现 client.Credentials.
+// token 以明文传输且可以被重放, 应在 TLS 或安全通道上使用
+type Bearer string
+
+func (b Bearer) Credential(path string, body []byte) ([]byte, error) {
+	return proto.Marshal(&protocols.Credential{Scheme: SchemeBearer, Token: string(b)})
+}
+
+// TokenAuthenticator 校验 Bearer, 实现 server.Authenticator
+type TokenAuthenticator struct {
+	principals map[[sha256.Size]byte]string // token 的摘要 -> principal, 查找时间与 token 内容无关
+}
+
+// NewTokenAuthenticator tokens: token -> principal
+func NewTokenAuthenticator(tokens map[string]string) *TokenAuthenticator {
+	a := &TokenAuthenticator{principals: make(map[[sha256.Size]byte]string, len(tokens))}
+	for token, principal := range tokens {
+		a.principals[sha256.Sum256([]byte(token))] = principal
+	}
+	return a
+}
+
+func (a *TokenAuthenticator) Authenticate(ctx context.Context, path string, body, credential []byte) (string, error) {
+	cred, err := decode(credential, SchemeBearer)
+	if err != nil {
+		return "", err
+	}
+	principal, ok := a.principals[sha256.Sum256([]byte(cred.Token))]
+	if !ok || cred.Token == "" {
+		return "", errors.ErrUnknownCredential
+	}
+	return principal, nil
+}
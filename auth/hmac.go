@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/brodyxchen/vsock-sdk/constant"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/protocols"
+	"google.golang.org/protobuf/proto"
+	"hash"
+	"sync"
+	"time"
+)
+
+const (
+	labelHMAC = "vsock-sdk hmac v1"
+	nonceSize = 16
+)
+
+// HMAC 用共享密钥对每个请求的 path 和 body 签名, 实现 client.Credentials.
+// 签名覆盖时间戳和随机 nonce, server 拒绝过期或重复的请求
+type HMAC struct {
+	KeyID string
+	Key   []byte
+}
+
+func (h *HMAC) Credential(path string, body []byte) ([]byte, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	timestamp := time.Now().UnixMilli()
+	return proto.Marshal(&protocols.Credential{
+		Scheme:    SchemeHMAC,
+		KeyId:     h.KeyID,
+		Timestamp: timestamp,
+		Nonce:     nonce,
+		Signature: hmacSign(h.Key, h.KeyID, timestamp, nonce, path, body),
+	})
+}
+
+// HMACAuthenticator 校验 HMAC, 实现 server.Authenticator, principal 为 KeyID.
+// 有效期内见过的 nonce 被记录下来, 同一个请求不能被重放
+type HMACAuthenticator struct {
+	MaxSkew   time.Duration // 默认 constant.AuthMaxSkew
+	MaxNonces int           // 默认 constant.AuthMaxNonces
+
+	keys map[string][]byte
+
+	mutex  sync.Mutex
+	nonces map[string]int64 // KeyID+nonce -> 过期时间(unix 毫秒)
+}
+
+// NewHMACAuthenticator keys: KeyID -> 密钥
+func NewHMACAuthenticator(keys map[string][]byte) *HMACAuthenticator {
+	return &HMACAuthenticator{
+		keys:   keys,
+		nonces: make(map[string]int64),
+	}
+}
+
+func (a *HMACAuthenticator) Authenticate(ctx context.Context, path string, body, credential []byte) (string, error) {
+	cred, err := decode(credential, SchemeHMAC)
+	if err != nil {
+		return "", err
+	}
+	key, ok := a.keys[cred.KeyId]
+	if !ok {
+		return "", errors.ErrUnknownCredential
+	}
+	if len(cred.Nonce) != nonceSize {
+		return "", errors.ErrInvalidCredential
+	}
+	if !hmac.Equal(cred.Signature, hmacSign(key, cred.KeyId, cred.Timestamp, cred.Nonce, path, body)) {
+		return "", errors.ErrInvalidCredential
+	}
+
+	// 签名通过后才记录 nonce, 伪造的请求不能占满记录
+	now := time.Now().UnixMilli()
+	skew := a.maxSkew().Milliseconds()
+	if cred.Timestamp < now-skew || cred.Timestamp > now+skew {
+		return "", errors.ErrCredentialExpired
+	}
+	if err = a.useNonce(cred.KeyId+"\x00"+string(cred.Nonce), now, cred.Timestamp+skew); err != nil {
+		return "", err
+	}
+	return cred.KeyId, nil
+}
+
+func (a *HMACAuthenticator) useNonce(nonce string, now, expireAt int64) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if expire, ok := a.nonces[nonce]; ok && expire >= now {
+		return errors.ErrCredentialReplayed
+	}
+	if len(a.nonces) >= a.maxNonces() {
+		for k, expire := range a.nonces {
+			if expire < now {
+				delete(a.nonces, k)
+			}
+		}
+		if len(a.nonces) >= a.maxNonces() {
+			return errors.ErrTooManyNonces
+		}
+	}
+	a.nonces[nonce] = expireAt
+	return nil
+}
+
+func (a *HMACAuthenticator) maxSkew() time.Duration {
+	if a.MaxSkew > 0 {
+		return a.MaxSkew
+	}
+	return constant.AuthMaxSkew
+}
+
+func (a *HMACAuthenticator) maxNonces() int {
+	if a.MaxNonces > 0 {
+		return a.MaxNonces
+	}
+	return constant.AuthMaxNonces
+}
+
+// hmacSign 各字段带长度前缀, body 以摘要参与签名
+func hmacSign(key []byte, keyID string, timestamp int64, nonce []byte, path string, body []byte) []byte {
+	bodySum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(labelHMAC))
+	writeField(mac, []byte(keyID))
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(timestamp))
+	mac.Write(ts[:])
+	writeField(mac, nonce)
+	writeField(mac, []byte(path))
+	mac.Write(bodySum[:])
+	return mac.Sum(nil)
+}
+
+func writeField(h hash.Hash, b []byte) {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(b)))
+	h.Write(size[:])
+	h.Write(b)
+}
@@ -0,0 +1,72 @@
+package vsock_sdk
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/auth"
+	"github.com/brodyxchen/vsock-sdk/client"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/server"
+	"github.com/brodyxchen/vsock-sdk/stream"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientAuthentication(t *testing.T) {
+	addr := &models.HttpAddr{IP: "127.0.0.1", Port: 7082}
+	srv := NewServer(addr)
+	srv.Authenticator = auth.NewHMACAuthenticator(map[string][]byte{
+		"alice": []byte("alice-key"),
+		"bob":   []byte("bob-key"),
+	})
+	srv.HandleContextFunc("whoami", func(ctx context.Context, req []byte) ([]byte, error) {
+		return []byte(server.PrincipalFromContext(ctx)), nil
+	})
+	srv.HandleClientStream("whoami", func(s *stream.Stream) ([]byte, error) {
+		return []byte(server.PrincipalFromContext(s.Context())), nil
+	})
+	go func() {
+		_ = srv.ListenAndServe()
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	cli := NewClient(&client.Config{Timeout: time.Second, Credentials: &auth.HMAC{KeyID: "alice", Key: []byte("alice-key")}})
+	for i := 0; i < 2; i++ {
+		rsp, err := cli.Do(addr, "whoami", []byte("hi"))
+		if err != nil || string(rsp) != "alice" {
+			t.Fatalf("call: %q %v", rsp, err)
+		}
+	}
+
+	bob := client.WithCredentials(context.Background(), &auth.HMAC{KeyID: "bob", Key: []byte("bob-key")})
+	results, err := cli.DoBatch(bob, addr, []client.BatchItem{{Path: "whoami"}, {Path: "whoami"}})
+	if err != nil || string(results[0].Reply) != "bob" || string(results[1].Reply) != "bob" {
+		t.Fatalf("batch: %+v %v", results, err)
+	}
+
+	cs, err := cli.NewClientStream(context.Background(), addr, "whoami")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp, err := cs.CloseAndRecv(); err != nil || string(rsp) != "alice" {
+		t.Fatalf("stream: %q %v", rsp, err)
+	}
+
+	// 没有认证数据或密钥错误
+	anonymous := NewClient(&client.Config{Timeout: time.Second})
+	if _, err = anonymous.Do(addr, "whoami", nil); err == nil || !strings.Contains(err.Error(), "unauthenticated") {
+		t.Fatalf("anonymous: %v", err)
+	}
+	forged := client.WithCredentials(context.Background(), &auth.HMAC{KeyID: "alice", Key: []byte("guess")})
+	results, err = cli.DoBatch(forged, addr, []client.BatchItem{{Path: "whoami"}})
+	if err != nil || results[0].Error == nil {
+		t.Fatalf("forged batch: %+v %v", results, err)
+	}
+	cs, err = anonymous.NewClientStream(context.Background(), addr, "whoami")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cs.CloseAndRecv(); err == nil {
+		t.Fatal("anonymous stream accepted")
+	}
+}
@@ -0,0 +1,53 @@
+package vsock_sdk
+
+import (
+	"context"
+	"errors"
+	"github.com/brodyxchen/vsock-sdk/client"
+	sdkerrors "github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"testing"
+	"time"
+)
+
+func TestClientDoBatch(t *testing.T) {
+	addr := &models.HttpAddr{IP: "127.0.0.1", Port: 7074}
+	srv := NewServer(addr)
+	srv.BatchConcurrency = 4
+	srv.HandleFunc("echo", func(bytes []byte) ([]byte, error) {
+		return append([]byte("rsp:"), bytes...), nil
+	})
+	srv.HandleFunc("fail", func(bytes []byte) ([]byte, error) {
+		return nil, errors.New("fail:" + string(bytes))
+	})
+	go func() {
+		_ = srv.ListenAndServe()
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	cli := NewClient(&client.Config{Timeout: time.Second})
+	results, err := cli.DoBatch(context.Background(), addr, []client.BatchItem{
+		{Path: "echo", Body: []byte("a")},
+		{Path: "fail", Body: []byte("b")},
+		{Path: "missing", Body: []byte("c")},
+		{Path: "echo", Body: []byte("d")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("results %v", len(results))
+	}
+	if string(results[0].Reply) != "rsp:a" || results[0].Error != nil {
+		t.Fatalf("item 0: %+v", results[0])
+	}
+	if results[1].Error == nil || results[1].Error.Error() != "fail:b" {
+		t.Fatalf("item 1: %+v", results[1])
+	}
+	if st, ok := results[2].Error.(*sdkerrors.Status); !ok || st.Code() != sdkerrors.StatusInvalidPath.Code() {
+		t.Fatalf("item 2: %+v", results[2])
+	}
+	if string(results[3].Reply) != "rsp:d" || results[3].Error != nil {
+		t.Fatalf("item 3: %+v", results[3])
+	}
+}
@@ -0,0 +1,76 @@
+package vsock_sdk
+
+import (
+	"github.com/brodyxchen/vsock-sdk/client"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientCircuitBreaker(t *testing.T) {
+	addr := &models.HttpAddr{IP: "127.0.0.1", Port: 7089}
+
+	var (
+		mutex       sync.Mutex
+		transitions []client.BreakerState
+	)
+	cli := NewClient(&client.Config{
+		Timeout: time.Second,
+		Breaker: &client.BreakerConfig{
+			MinRequests: 2,
+			FailureRate: 0.5,
+			CoolDown:    200 * time.Millisecond,
+			OnStateChange: func(target string, from, to client.BreakerState) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				transitions = append(transitions, to)
+			},
+		},
+	})
+
+	// 没有 server 监听, 拨号失败
+	for i := 0; i < 2; i++ {
+		if _, err := cli.Do(addr, "echo", nil); err == nil || errors.IsBreakerOpen(err) {
+			t.Fatalf("dial %d: %v", i, err)
+		}
+	}
+	_, err := cli.Do(addr, "echo", nil)
+	open, ok := err.(*errors.BreakerOpenError)
+	if !ok || open.RetryAfter <= 0 || cli.BreakerState(addr) != client.BreakerOpen {
+		t.Fatalf("open: %v %v", err, cli.BreakerState(addr))
+	}
+
+	srv := NewServer(addr)
+	srv.HandleFunc("echo", func(req []byte) ([]byte, error) {
+		return req, nil
+	})
+	go func() {
+		_ = srv.ListenAndServe()
+	}()
+	time.Sleep(250 * time.Millisecond)
+
+	// 半开时的探测成功后关闭
+	if cli.BreakerState(addr) != client.BreakerHalfOpen {
+		t.Fatalf("state: %v", cli.BreakerState(addr))
+	}
+	if rsp, err := cli.Do(addr, "echo", []byte("ok")); err != nil || string(rsp) != "ok" {
+		t.Fatalf("probe: %q %v", rsp, err)
+	}
+	if cli.BreakerState(addr) != client.BreakerClosed {
+		t.Fatalf("state: %v", cli.BreakerState(addr))
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	want := []client.BreakerState{client.BreakerOpen, client.BreakerHalfOpen, client.BreakerClosed}
+	if len(transitions) != len(want) {
+		t.Fatalf("transitions: %v", transitions)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Fatalf("transitions: %v", transitions)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package buffer
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// 按容量分级的池, 最大一级能容纳一个完整的帧(64k body + 帧头)
+var classes = [...]int{512, 4 << 10, 16 << 10, 80 << 10}
+
+var pools [len(classes)]sync.Pool
+
+// Buffer 引用计数的池化缓冲, 引用归零时回到池中.
+// Get 得到的 Buffer 持有1个引用; 交给其他goroutine继续使用前 Retain, 用完 Release.
+type Buffer struct {
+	B []byte
+
+	refs  int32
+	class int8 // -1 代表不属于池
+}
+
+// Get 返回 len(B)==0, cap(B)>=size 的缓冲; 超过最大一级时直接分配, 不进池
+func Get(size int) *Buffer {
+	for i, c := range classes {
+		if size > c {
+			continue
+		}
+		if v := pools[i].Get(); v != nil {
+			buf := v.(*Buffer)
+			buf.B = buf.B[:0]
+			buf.refs = 1
+			return buf
+		}
+		return &Buffer{B: make([]byte, 0, c), refs: 1, class: int8(i)}
+	}
+	return &Buffer{B: make([]byte, 0, size), refs: 1, class: -1}
+}
+
+// Wrap 包装一个不属于池的切片, Retain/Release 对其没有影响
+func Wrap(b []byte) *Buffer {
+	return &Buffer{B: b, refs: 1, class: -1}
+}
+
+func (buf *Buffer) Retain() {
+	if buf == nil || buf.class < 0 {
+		return
+	}
+	atomic.AddInt32(&buf.refs, 1)
+}
+
+// Release 引用归零后 B 不能再被使用
+func (buf *Buffer) Release() {
+	if buf == nil || buf.class < 0 {
+		return
+	}
+	refs := atomic.AddInt32(&buf.refs, -1)
+	if refs > 0 {
+		return
+	}
+	if refs < 0 {
+		panic("buffer: release of a free buffer")
+	}
+
+	// append 扩容后不再属于原来的级别
+	if cap(buf.B) != classes[buf.class] {
+		return
+	}
+	pools[buf.class].Put(buf)
+}
@@ -0,0 +1,80 @@
+package vsock_sdk
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/client"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientGoAndDoAll(t *testing.T) {
+	var running, peak int32
+	LaunchCustomExampleServer(7072, time.Second, time.Second, time.Second*10, true, func(bytes []byte) ([]byte, error) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return []byte("rsp:" + string(bytes)), nil
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	cli := NewClient(&client.Config{Timeout: time.Second})
+	addr := &models.HttpAddr{IP: "127.0.0.1", Port: 7072}
+
+	call := cli.Go(context.Background(), addr, "test", []byte("go"))
+	done := <-call.Done
+	if done != call || call.Error != nil || string(call.Reply) != "rsp:go" {
+		t.Fatalf("go call: %q %v", call.Reply, call.Error)
+	}
+	if rsp, err := call.Wait(); err != nil || string(rsp) != "rsp:go" {
+		t.Fatalf("wait: %q %v", rsp, err)
+	}
+
+	calls := make([]*client.Call, 20)
+	for i := range calls {
+		calls[i] = &client.Call{Addr: addr, Path: "test", Body: []byte(strconv.Itoa(i))}
+	}
+	calls[5].Path = "missing"
+
+	results := cli.DoAll(context.Background(), calls, 4)
+	for i, c := range results {
+		if i == 5 {
+			if c.Error == nil {
+				t.Fatal("missing path should fail")
+			}
+			continue
+		}
+		if c.Error != nil || string(c.Reply) != "rsp:"+strconv.Itoa(i) {
+			t.Fatalf("call %v: %q %v", i, c.Reply, c.Error)
+		}
+	}
+	if p := atomic.LoadInt32(&peak); p > 4 {
+		t.Fatalf("concurrency %v exceeds limit", p)
+	}
+
+	// 调用方提供的 Done 不被替换, 共用一个 channel 收到全部调用
+	shared := make(chan *client.Call, 3)
+	calls = make([]*client.Call, 3)
+	for i := range calls {
+		calls[i] = &client.Call{Addr: addr, Path: "test", Body: []byte(strconv.Itoa(i)), Done: shared}
+	}
+	cli.DoAll(context.Background(), calls, 2)
+	for i := range calls {
+		select {
+		case c := <-shared:
+			if c.Done != shared || c.Error != nil {
+				t.Fatalf("shared done %d: %v", i, c.Error)
+			}
+		default:
+			t.Fatalf("shared done: %d of %d calls", i, len(calls))
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package vsock_sdk
+
+import (
+	"bytes"
+	"context"
+	"github.com/brodyxchen/vsock-sdk/client"
+	"github.com/brodyxchen/vsock-sdk/compress"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/stream"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestClientChecksum(t *testing.T) {
+	addr := &models.HttpAddr{IP: "127.0.0.1", Port: 7078}
+	srv := NewServer(addr)
+	srv.HandleFunc("echo", func(req []byte) ([]byte, error) {
+		return req, nil
+	})
+	srv.HandleBidiStream("echo", func(s *stream.Stream) error {
+		for {
+			msg, err := s.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err = s.Send(msg); err != nil {
+				return err
+			}
+		}
+	})
+	go func() {
+		_ = srv.ListenAndServe()
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	cli := NewClient(&client.Config{Timeout: time.Second, EnableChecksum: true, Compressors: []uint8{compress.Gzip}})
+
+	// 校验和覆盖压缩后的 body
+	for _, body := range [][]byte{[]byte("small"), bytes.Repeat([]byte("large"), 1000)} {
+		rsp, err := cli.Do(addr, "echo", body)
+		if err != nil || !bytes.Equal(rsp, body) {
+			t.Fatalf("echo %d: %d %v", len(body), len(rsp), err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s, err := cli.NewBidiStream(ctx, addr, "echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Send([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := s.Recv(); err != nil || string(msg) != "ping" {
+		t.Fatalf("stream echo: %q %v", msg, err)
+	}
+	_ = s.CloseSend()
+	if _, err = s.Recv(); err != io.EOF {
+		t.Fatalf("stream end: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+)
+
+// Balancer 从 Target 的可用地址中选择一个. endpoints 非空, 且在本次调用中不会被修改;
+// 实现需要并发安全
+type Balancer interface {
+	Pick(ctx context.Context, endpoints []*Endpoint) *Endpoint
+}
+
+// RoundRobin 依次轮流选择
+type RoundRobin struct {
+	next uint64 // atomic
+}
+
+func (rr *RoundRobin) Pick(_ context.Context, endpoints []*Endpoint) *Endpoint {
+	n := atomic.AddUint64(&rr.next, 1) - 1
+	return endpoints[n%uint64(len(endpoints))]
+}
+
+// LeastOutstanding 选择正在进行的调用最少的地址, 相同时从随机位置开始取第一个, 避免都集中到列表头部
+type LeastOutstanding struct{}
+
+func (LeastOutstanding) Pick(_ context.Context, endpoints []*Endpoint) *Endpoint {
+	start := rand.Intn(len(endpoints))
+	best := endpoints[start]
+	for i := 1; i < len(endpoints); i++ {
+		ep := endpoints[(start+i)%len(endpoints)]
+		if ep.Outstanding() < best.Outstanding() {
+			best = ep
+		}
+	}
+	return best
+}
+
+// P2C power of two choices: 随机取两个地址, 选择正在进行的调用较少的一个.
+// 效果接近 LeastOutstanding, 但不需要遍历, 也不会让所有调用方同时涌向同一个最空闲的地址
+type P2C struct{}
+
+func (P2C) Pick(_ context.Context, endpoints []*Endpoint) *Endpoint {
+	if len(endpoints) == 1 {
+		return endpoints[0]
+	}
+	i := rand.Intn(len(endpoints))
+	j := rand.Intn(len(endpoints) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := endpoints[i], endpoints[j]
+	if b.Outstanding() < a.Outstanding() {
+		return b
+	}
+	return a
+}
+
+type routingKeyContextKey struct{}
+
+// WithRoutingKey 该 ctx 发起的调用(DoContext, Go)按 key 选择地址, 只对 ConsistentHash 有效
+func WithRoutingKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, routingKeyContextKey{}, key)
+}
+
+// RoutingKeyFromContext 没有设置时返回 false
+func RoutingKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(routingKeyContextKey{}).(string)
+	return key, ok
+}
+
+// ConsistentHash 按 WithRoutingKey 设置的 key 做 rendezvous hashing: 每个地址与 key 计算一个分数, 选分数最高的.
+// 同一个 key 在地址列表不变时总是落到同一个地址; 地址被移除(或不可用)时只有原来落在它上面的 key 改变,
+// 新增地址时只有改为落在新地址上的 key 改变. 没有 routing key 的调用交给 Fallback, 默认 RoundRobin
+type ConsistentHash struct {
+	Fallback Balancer
+}
+
+func (ch *ConsistentHash) Pick(ctx context.Context, endpoints []*Endpoint) *Endpoint {
+	key, ok := RoutingKeyFromContext(ctx)
+	if !ok {
+		if ch.Fallback != nil {
+			return ch.Fallback.Pick(ctx, endpoints)
+		}
+		return defaultBalancer.Pick(ctx, endpoints)
+	}
+
+	keyHash := hashString(key)
+	var (
+		best      *Endpoint
+		bestScore uint64
+	)
+	for _, ep := range endpoints {
+		// 分数相同的概率可以忽略, 相同时取地址哈希较小的, 保证与顺序无关
+		score := mix64(keyHash ^ ep.hash)
+		if best == nil || score > bestScore || (score == bestScore && ep.hash < best.hash) {
+			best, bestScore = ep, score
+		}
+	}
+	return best
+}
+
+// hashString FNV-1a 64
+func hashString(s string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// mix64 splitmix64 的终结函数, 使相近的输入得到分布均匀的输出
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
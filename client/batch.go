@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/buffer"
+	"github.com/brodyxchen/vsock-sdk/constant"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/protocols"
+	"github.com/brodyxchen/vsock-sdk/socket"
+	"google.golang.org/protobuf/proto"
+	"time"
+)
+
+type BatchItem struct {
+	Path string
+	Body []byte
+}
+
+type BatchResult struct {
+	Reply []byte
+	Error error // 子请求的错误
+}
+
+// DoBatch 多个子请求打包成一帧发送, 结果与 items 一一对应;
+// 返回的 error 是整帧的错误(网络/超时/server拒绝), 此时没有任何子请求的结果
+func (cli *Client) DoBatch(ctx context.Context, addr models.Addr, items []BatchItem) ([]BatchResult, error) {
+	// 每个子请求的认证信息可能是一次性的(auth.HMAC), 对冲时每次发送都重新编码
+	encode := func() (*buffer.Buffer, error) {
+		pbBatch := &protocols.BatchRequest{
+			Items: make([]*protocols.Request, len(items)),
+		}
+		for i, item := range items {
+			auth, err := cli.transport.credential(ctx, item.Path, item.Body)
+			if err != nil {
+				return nil, err
+			}
+			pbBatch.Items[i] = &protocols.Request{
+				Path: item.Path,
+				Req:  item.Body,
+				Auth: auth,
+			}
+		}
+		frame := socket.NewFrame(proto.Size(pbBatch))
+		frame.B, _ = proto.MarshalOptions{}.MarshalAppend(frame.B, pbBatch)
+		return frame, nil
+	}
+	frame, err := encode()
+	if err != nil {
+		return nil, err
+	}
+	defer frame.Release()
+
+	rsp, err := cli.roundTrip(ctx, constant.ActionBatch, addr, frame, encode)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.Err != nil {
+		return nil, rsp.Err
+	}
+
+	var pbRsp protocols.BatchResponse
+	if err = proto.Unmarshal(rsp.Body, &pbRsp); err != nil {
+		return nil, errors.Wrap(errors.ErrInvalidBatchResponse, err)
+	}
+	if len(pbRsp.Items) != len(items) {
+		return nil, errors.ErrInvalidBatchResponse
+	}
+
+	results := make([]BatchResult, len(items))
+	for i, item := range pbRsp.Items {
+		switch item.Code {
+		case protocols.StatusOK:
+			results[i].Reply = item.Rsp
+		case protocols.StatusErr: // 业务错误
+			results[i].Error = errors.New(item.Err)
+		default:
+			results[i].Error = errors.NewStatus(uint16(item.Code), item.Err).WithRetryAfter(time.Duration(item.RetryAfter) * time.Millisecond)
+		}
+	}
+	return results, nil
+}
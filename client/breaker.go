@@ -0,0 +1,243 @@
+package client
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"sync"
+	"time"
+)
+
+type BreakerState int32
+
+const (
+	BreakerClosed   BreakerState = iota // 正常放行
+	BreakerOpen                         // 直接拒绝, CoolDown 后进入半开
+	BreakerHalfOpen                     // 放行少量探测请求, 全部成功后关闭, 任一失败重新打开
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerConfig 每个目标(地址和 TLS 身份)一个熔断器.
+// 只有连接层面的失败(拨号, 握手, 读写, 超时)计入失败; server 回复的状态和业务错误说明目标可用, 计为成功
+type BreakerConfig struct {
+	Window      time.Duration // 统计失败率的窗口, 默认 10s
+	MinRequests int           // 窗口内请求数达到后才判断失败率, 默认 5
+	FailureRate float64       // 窗口内失败率达到后打开, 默认 0.5
+	CoolDown    time.Duration // 打开后多久进入半开, 默认 5s
+	Probes      int           // 半开时同时放行的探测请求数, 默认 1
+
+	// OnStateChange 状态变化时调用, 调用时持有该熔断器的锁, 不能阻塞
+	OnStateChange func(target string, from, to BreakerState)
+}
+
+func (cfg *BreakerConfig) window() time.Duration {
+	if cfg.Window > 0 {
+		return cfg.Window
+	}
+	return 10 * time.Second
+}
+
+func (cfg *BreakerConfig) minRequests() int {
+	if cfg.MinRequests > 0 {
+		return cfg.MinRequests
+	}
+	return 5
+}
+
+func (cfg *BreakerConfig) failureRate() float64 {
+	if cfg.FailureRate > 0 {
+		return cfg.FailureRate
+	}
+	return 0.5
+}
+
+func (cfg *BreakerConfig) coolDown() time.Duration {
+	if cfg.CoolDown > 0 {
+		return cfg.CoolDown
+	}
+	return 5 * time.Second
+}
+
+func (cfg *BreakerConfig) probes() int {
+	if cfg.Probes > 0 {
+		return cfg.Probes
+	}
+	return 1
+}
+
+// breakerResult 一次放行的请求的结果
+type breakerResult int
+
+const (
+	breakerSuccess breakerResult = iota
+	breakerFailure
+	breakerIgnore // 调用方取消, 只释放半开的探测名额, 不计入统计
+)
+
+type breaker struct {
+	target    string
+	cfg       *BreakerConfig
+	transport *Transport
+
+	mutex       sync.Mutex
+	state       BreakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probing     int    // 半开时正在进行的探测数
+	succeeded   int    // 半开时成功的探测数
+	generation  uint64 // 每次状态变化加一
+}
+
+// allow 放行时返回当前的 generation, 调用方必须随后以它调用 done
+func (br *breaker) allow(now time.Time) (uint64, error) {
+	br.mutex.Lock()
+	defer br.mutex.Unlock()
+
+	switch br.state {
+	case BreakerOpen:
+		if wait := br.cfg.coolDown() - now.Sub(br.openedAt); wait > 0 {
+			br.transport.breakerRejectCounter.Inc(1)
+			return 0, &errors.BreakerOpenError{Addr: br.target, RetryAfter: wait}
+		}
+		br.setState(BreakerHalfOpen, now)
+		fallthrough
+	case BreakerHalfOpen:
+		if br.probing >= br.cfg.probes() {
+			br.transport.breakerRejectCounter.Inc(1)
+			return 0, &errors.BreakerOpenError{Addr: br.target}
+		}
+		br.probing++
+	}
+	return br.generation, nil
+}
+
+// done 放行之后状态已经变化的请求不影响状态: 关闭时放行的请求不是半开的探测
+func (br *breaker) done(generation uint64, result breakerResult, now time.Time) {
+	br.mutex.Lock()
+	defer br.mutex.Unlock()
+
+	if generation != br.generation {
+		return
+	}
+	switch br.state {
+	case BreakerHalfOpen:
+		br.probing--
+		switch result {
+		case breakerIgnore:
+			return
+		case breakerFailure:
+			br.setState(BreakerOpen, now)
+			return
+		}
+		if br.succeeded++; br.succeeded >= br.cfg.probes() {
+			br.setState(BreakerClosed, now)
+		}
+	case BreakerClosed:
+		if result == breakerIgnore {
+			return
+		}
+		if now.Sub(br.windowStart) > br.cfg.window() {
+			br.windowStart, br.requests, br.failures = now, 0, 0
+		}
+		br.requests++
+		if result == breakerFailure {
+			br.failures++
+		}
+		if br.requests >= br.cfg.minRequests() && float64(br.failures) >= br.cfg.failureRate()*float64(br.requests) {
+			br.setState(BreakerOpen, now)
+		}
+	}
+}
+
+func (br *breaker) setState(state BreakerState, now time.Time) {
+	from := br.state
+	br.state = state
+	br.generation++
+	switch state {
+	case BreakerOpen:
+		br.openedAt = now
+		br.transport.breakerOpenCounter.Inc(1)
+	case BreakerHalfOpen:
+		br.probing, br.succeeded = 0, 0
+	case BreakerClosed:
+		br.windowStart, br.requests, br.failures = now, 0, 0
+	}
+	if br.cfg.OnStateChange != nil {
+		br.cfg.OnStateChange(br.target, from, state)
+	}
+}
+
+// breaker 没有配置 Breaker 时返回 nil
+func (tp *Transport) breaker(key connectKey) *breaker {
+	if tp.Breaker == nil {
+		return nil
+	}
+	tp.breakersMutex.Lock()
+	defer tp.breakersMutex.Unlock()
+	if tp.breakers == nil {
+		tp.breakers = make(map[connectKey]*breaker)
+	}
+	br, ok := tp.breakers[key]
+	if !ok {
+		br = &breaker{target: key.String(), cfg: tp.Breaker, transport: tp, windowStart: time.Now()}
+		tp.breakers[key] = br
+	}
+	return br
+}
+
+// dropBreakers 地址被移除后丢弃它的熔断器, 不区分 TLS 身份
+func (tp *Transport) dropBreakers(key connectKey) {
+	tp.breakersMutex.Lock()
+	defer tp.breakersMutex.Unlock()
+	for k := range tp.breakers {
+		if k.addr() == key.addr() {
+			delete(tp.breakers, k)
+		}
+	}
+}
+
+// breakerOutcome 连接层面的失败才计入熔断; 调用方主动取消的请求不说明目标是否可用
+func breakerOutcome(ctx context.Context, err error) breakerResult {
+	switch err.(type) {
+	case nil, *errors.Status:
+		return breakerSuccess
+	}
+	if ctx.Err() == context.Canceled {
+		return breakerIgnore
+	}
+	// 请求本身超出对端上限, 或对端不支持
+	if err == errors.ErrExceedBody || err == errors.ErrNotifyUnsupported {
+		return breakerSuccess
+	}
+	return breakerFailure
+}
+
+// BreakerState addr 在默认 TLS 身份下的熔断状态
+func (cli *Client) BreakerState(addr models.Addr) BreakerState {
+	key := connectKey{}
+	key.From(addr)
+	key.Identity = cli.transport.tlsIdentity(cli.transport.TLSConfig)
+	br := cli.transport.breaker(key)
+	if br == nil {
+		return BreakerClosed
+	}
+	br.mutex.Lock()
+	defer br.mutex.Unlock()
+	if br.state == BreakerOpen && time.Since(br.openedAt) >= br.cfg.coolDown() {
+		return BreakerHalfOpen
+	}
+	return br.state
+}
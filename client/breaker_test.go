@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/statistics/metrics"
+	"testing"
+	"time"
+)
+
+func TestBreakerLateCompletion(t *testing.T) {
+	now := time.Now()
+	tp := &Transport{breakerOpenCounter: metrics.NewCounter(), breakerRejectCounter: metrics.NewCounter()}
+	br := &breaker{
+		target:      "127.0.0.1:1",
+		cfg:         &BreakerConfig{MinRequests: 1, CoolDown: time.Second},
+		transport:   tp,
+		windowStart: now,
+	}
+
+	// 关闭时放行, 半开之后才完成
+	lateOK, _ := br.allow(now)
+	lateFail, _ := br.allow(now)
+	failed, _ := br.allow(now)
+	br.done(failed, breakerFailure, now)
+	if br.state != BreakerOpen {
+		t.Fatalf("state: %v", br.state)
+	}
+
+	now = now.Add(time.Second)
+	probe, err := br.allow(now)
+	if err != nil || br.state != BreakerHalfOpen {
+		t.Fatalf("probe: %v %v", br.state, err)
+	}
+	br.done(lateOK, breakerSuccess, now)
+	br.done(lateFail, breakerFailure, now)
+	if br.state != BreakerHalfOpen || br.probing != 1 || br.succeeded != 0 {
+		t.Fatalf("late results counted: %v probing %d succeeded %d", br.state, br.probing, br.succeeded)
+	}
+	// 探测名额仍被占用
+	if _, err = br.allow(now); !errors.IsBreakerOpen(err) {
+		t.Fatalf("second probe admitted: %v", err)
+	}
+
+	br.done(probe, breakerSuccess, now)
+	if br.state != BreakerClosed || tp.breakerOpenCounter.Count() != 1 {
+		t.Fatalf("after probe: %v opened %d", br.state, tp.breakerOpenCounter.Count())
+	}
+}
+
+func TestBreakerCanceledProbe(t *testing.T) {
+	now := time.Now()
+	tp := &Transport{breakerOpenCounter: metrics.NewCounter(), breakerRejectCounter: metrics.NewCounter()}
+	br := &breaker{
+		target:      "127.0.0.1:1",
+		cfg:         &BreakerConfig{MinRequests: 1, CoolDown: time.Second},
+		transport:   tp,
+		windowStart: now,
+	}
+	failed, _ := br.allow(now)
+	br.done(failed, breakerFailure, now)
+
+	now = now.Add(time.Second)
+	probe, err := br.allow(now)
+	if err != nil || br.state != BreakerHalfOpen {
+		t.Fatalf("probe: %v %v", br.state, err)
+	}
+	// 调用方取消的探测只释放名额, 不关闭熔断器
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	br.done(probe, breakerOutcome(ctx, context.Canceled), now)
+	if br.state != BreakerHalfOpen || br.probing != 0 || br.succeeded != 0 {
+		t.Fatalf("canceled probe counted: %v probing %d succeeded %d", br.state, br.probing, br.succeeded)
+	}
+	if probe, err = br.allow(now); err != nil {
+		t.Fatalf("probe slot not released: %v", err)
+	}
+	br.done(probe, breakerFailure, now)
+	if br.state != BreakerOpen {
+		t.Fatalf("state: %v", br.state)
+	}
+}
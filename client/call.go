@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"sync"
+)
+
+// Call 一次异步调用, 参考 net/rpc.Call
+type Call struct {
+	Addr models.Addr
+	Path string
+	Body []byte
+
+	Reply []byte // 完成后有效
+	Error error  // 完成后有效
+
+	Done chan *Call // 完成时写入自身; 为 nil 时分配容量为1的, 调用方提供的需要有足够的缓冲, 满了不阻塞
+
+	finished chan struct{}
+}
+
+// Wait 阻塞直到调用完成, 不消费 Done
+func (call *Call) Wait() ([]byte, error) {
+	<-call.finished
+	return call.Reply, call.Error
+}
+
+// done 先写入 Done 再结束 Wait, DoAll 返回时 Done 中已经有全部调用
+func (call *Call) done() {
+	select {
+	case call.Done <- call:
+	default: // Done 满了不阻塞
+	}
+	close(call.finished)
+}
+
+// Go 异步调用, ctx没有deadline时使用 Client.Timeout
+func (cli *Client) Go(ctx context.Context, addr models.Addr, path string, body []byte) *Call {
+	call := &Call{
+		Addr: addr,
+		Path: path,
+		Body: body,
+	}
+	cli.goCall(ctx, call)
+	return call
+}
+
+func (cli *Client) goCall(ctx context.Context, call *Call) {
+	if call.Done == nil {
+		call.Done = make(chan *Call, 1)
+	}
+	call.finished = make(chan struct{})
+
+	go func() {
+		call.Reply, call.Error = cli.send(ctx, call.Addr, call.Path, call.Body)
+		call.done()
+	}()
+}
+
+// DoAll 并发执行 calls, 同时进行的调用不超过 limit(<=0不限制), 全部完成后按原顺序返回
+func (cli *Client) DoAll(ctx context.Context, calls []*Call, limit int) []*Call {
+	if limit <= 0 || limit > len(calls) {
+		limit = len(calls)
+	}
+
+	sem := make(chan struct{}, limit)
+	wg := sync.WaitGroup{}
+	wg.Add(len(calls))
+	for _, call := range calls {
+		sem <- struct{}{}
+
+		cli.goCall(ctx, call)
+		go func(call *Call) {
+			<-call.finished
+			<-sem
+			wg.Done()
+		}(call)
+	}
+	wg.Wait()
+
+	return calls
+}
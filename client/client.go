@@ -2,8 +2,11 @@ package client
 
 import (
 	"context"
+	"github.com/brodyxchen/vsock-sdk/buffer"
+	"github.com/brodyxchen/vsock-sdk/constant"
 	"github.com/brodyxchen/vsock-sdk/models"
 	"github.com/brodyxchen/vsock-sdk/protocols"
+	"github.com/brodyxchen/vsock-sdk/socket"
 	"github.com/brodyxchen/vsock-sdk/statistics"
 	"github.com/brodyxchen/vsock-sdk/statistics/metrics"
 	"google.golang.org/protobuf/proto"
@@ -27,9 +30,22 @@ func (cli *Client) Init(cfg *Config) {
 				idleTimeout:       cfg.GetPoolIdleTimeout(),
 				maxCapacityPerKey: cfg.GetPoolMaxCapacity(),
 			},
-			WriteBufferSize: cfg.GetWriteBufferSize(),
-			ReadBufferSize:  cfg.GetReadBufferSize(),
-			connIndex:       0,
+			WriteBufferSize:    cfg.GetWriteBufferSize(),
+			ReadBufferSize:     cfg.GetReadBufferSize(),
+			StreamWindowSize:   cfg.GetStreamWindowSize(),
+			WriteCoalesceDelay: cfg.WriteCoalesceDelay,
+			DisableHandshake:   cfg.DisableHandshake,
+			MinVersion:         cfg.MinVersion,
+			MaxFrameSize:       cfg.MaxFrameSize,
+			Compressors:        cfg.Compressors,
+			CompressThreshold:  cfg.GetCompressThreshold(),
+			EnableChecksum:     cfg.EnableChecksum,
+			Secure:             cfg.Secure,
+			TLSConfig:          cfg.TLSConfig,
+			Credentials:        cfg.Credentials,
+			Breaker:            cfg.Breaker,
+			Hedge:              cfg.Hedge,
+			connIndex:          0,
 		}
 	}
 
@@ -56,33 +72,84 @@ func (cli *Client) Init(cfg *Config) {
 	cli.transport.sendDoneHist = sendDoneHist
 	cli.transport.receiveHist = receiveHist
 	cli.transport.receiveTimeoutHist = receiveTimeoutHist
+
+	notifyCounter := metrics.NewCounter()
+	notifyFailCounter := metrics.NewCounter()
+	_ = statistics.ClientReg.Register("tp.notify", notifyCounter)
+	_ = statistics.ClientReg.Register("tp.notify.fail", notifyFailCounter)
+	cli.transport.notifyCounter = notifyCounter
+	cli.transport.notifyFailCounter = notifyFailCounter
+
+	breakerOpenCounter := metrics.NewCounter()
+	breakerRejectCounter := metrics.NewCounter()
+	_ = statistics.ClientReg.Register("tp.breaker.open", breakerOpenCounter)
+	_ = statistics.ClientReg.Register("tp.breaker.rejected", breakerRejectCounter)
+	cli.transport.breakerOpenCounter = breakerOpenCounter
+	cli.transport.breakerRejectCounter = breakerRejectCounter
+
+	noEndpointCounter := metrics.NewCounter()
+	_ = statistics.ClientReg.Register("tp.target.noEndpoint", noEndpointCounter)
+	cli.transport.noEndpointCounter = noEndpointCounter
+
+	healthCheckCounter := metrics.NewCounter()
+	healthFailCounter := metrics.NewCounter()
+	unhealthyCounter := metrics.NewCounter()
+	_ = statistics.ClientReg.Register("tp.health.check", healthCheckCounter)
+	_ = statistics.ClientReg.Register("tp.health.fail", healthFailCounter)
+	_ = statistics.ClientReg.Register("tp.health.unhealthy", unhealthyCounter)
+	cli.transport.healthCheckCounter = healthCheckCounter
+	cli.transport.healthFailCounter = healthFailCounter
+	cli.transport.unhealthyCounter = unhealthyCounter
+
+	hedgeCounter := metrics.NewCounter()
+	hedgeWinCounter := metrics.NewCounter()
+	hedgeThrottledCounter := metrics.NewCounter()
+	_ = statistics.ClientReg.Register("tp.hedge", hedgeCounter)
+	_ = statistics.ClientReg.Register("tp.hedge.win", hedgeWinCounter)
+	_ = statistics.ClientReg.Register("tp.hedge.throttled", hedgeThrottledCounter)
+	cli.transport.hedgeCounter = hedgeCounter
+	cli.transport.hedgeWinCounter = hedgeWinCounter
+	cli.transport.hedgeThrottledCounter = hedgeThrottledCounter
+
+	compressRatioHist := metrics.NewHistogram(metrics.NewUniformSample(1028))
+	compressCostHist := metrics.NewHistogram(metrics.NewUniformSample(1028))
+	decompressCostHist := metrics.NewHistogram(metrics.NewUniformSample(1028))
+	_ = statistics.ClientReg.Register("tp.compress.ratio", compressRatioHist)
+	_ = statistics.ClientReg.Register("tp.compress.costUs", compressCostHist)
+	_ = statistics.ClientReg.Register("tp.decompress.costUs", decompressCostHist)
+	cli.transport.compressRatioHist = compressRatioHist
+	cli.transport.compressCostHist = compressCostHist
+	cli.transport.decompressCostHist = decompressCostHist
 }
 
 func (cli *Client) Do(addr models.Addr, path string, req []byte) ([]byte, error) {
-	return cli.send(addr, path, req, cli.deadline())
+	return cli.send(context.Background(), addr, path, req)
 }
 
-func (cli *Client) send(addr models.Addr, path string, body []byte, deadline time.Time) ([]byte, error) {
-	ctx := context.Background()
-	if !deadline.IsZero() {
-		ctxDeadline, cancel := context.WithDeadline(ctx, deadline)
-		defer cancel()
-		ctx = ctxDeadline
-	}
+// DoContext 同 Do, ctx 携带 WithRoutingKey, WithTLSConfig, WithCredentials, WithHedging 等调用选项;
+// ctx没有deadline时使用 Client.Timeout
+func (cli *Client) DoContext(ctx context.Context, addr models.Addr, path string, req []byte) ([]byte, error) {
+	return cli.send(ctx, addr, path, req)
+}
 
-	pbReq := &protocols.Request{
-		Path: path,
-		Req:  body,
+func (cli *Client) send(ctx context.Context, addr models.Addr, path string, body []byte) ([]byte, error) {
+	// 认证信息可能是一次性的(auth.HMAC), 对冲时每次发送都重新编码
+	encode := func() (*buffer.Buffer, error) {
+		auth, err := cli.transport.credential(ctx, path, body)
+		if err != nil {
+			return nil, err
+		}
+		frame := socket.NewFrame(len(path) + len(body) + len(auth) + 16)
+		frame.B = protocols.AppendAuth(protocols.AppendRequest(frame.B, path, body), auth)
+		return frame, nil
 	}
-	bodyBytes, _ := proto.Marshal(pbReq)
-
-	req := &models.Request{
-		Ctx:  ctx,
-		Addr: addr,
-		Body: bodyBytes,
+	frame, err := encode()
+	if err != nil {
+		return nil, err
 	}
+	defer frame.Release()
 
-	rsp, err := cli.transport.roundTrip(req)
+	rsp, err := cli.roundTrip(ctx, constant.ActionCall, addr, frame, encode)
 
 	// 系统错误
 	if err != nil {
@@ -97,6 +164,48 @@ func (cli *Client) send(addr models.Addr, path string, body []byte, deadline tim
 	return rsp.Body, nil
 }
 
+// Notify 单向通知, 请求帧flush后即返回, server 不回复, 所以无法得知 handler 的执行结果.
+// 握手没有协商 constant.FeatureNotify 时(DisableHandshake 或旧版本 server)返回 ErrNotifyUnsupported
+func (cli *Client) Notify(ctx context.Context, addr models.Addr, path string, body []byte) error {
+	cli.transport.notifyCounter.Inc(1)
+	auth, err := cli.transport.credential(ctx, path, body)
+	if err != nil {
+		cli.transport.notifyFailCounter.Inc(1)
+		return err
+	}
+	frame := socket.NewFrame(len(path) + len(body) + len(auth) + 16)
+	frame.B = protocols.AppendAuth(protocols.AppendRequest(frame.B, path, body), auth)
+	defer frame.Release()
+
+	_, err = cli.roundTrip(ctx, constant.ActionNotify, addr, frame, nil)
+	if err != nil {
+		cli.transport.notifyFailCounter.Inc(1)
+		return err
+	}
+	return nil
+}
+
+// roundTrip ctx没有deadline时使用 Client.Timeout, frame 由调用方释放
+func (cli *Client) roundTrip(ctx context.Context, action uint16, addr models.Addr, frame *buffer.Buffer, encode func() (*buffer.Buffer, error)) (*models.Response, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		if deadline := cli.deadline(); !deadline.IsZero() {
+			ctxDeadline, cancel := context.WithDeadline(ctx, deadline)
+			defer cancel()
+			ctx = ctxDeadline
+		}
+	}
+
+	req := &models.Request{
+		Header: models.Header{Code: action},
+		Ctx:    ctx,
+		Addr:   addr,
+		Body:   socket.FrameBody(frame),
+		Frame:  frame,
+		Encode: encode,
+	}
+	return cli.transport.roundTrip(req)
+}
+
 func (cli *Client) DialTest(addr models.Addr) (*PersistConn, error) {
 	conn, err := cli.transport.DialTest(addr)
 	return conn, err
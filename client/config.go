@@ -1,7 +1,9 @@
 package client
 
 import (
+	"crypto/tls"
 	"github.com/brodyxchen/vsock-sdk/constant"
+	"github.com/brodyxchen/vsock-sdk/secure"
 	"time"
 )
 
@@ -11,6 +13,36 @@ type Config struct {
 	PoolMaxCapacity int
 	WriteBufferSize int
 	ReadBufferSize  int
+
+	StreamWindowSize int // 流式调用的接收窗口
+
+	// 写合并: 同一连接上还有请求排队时暂不flush, 最多延迟这么久; 0 每帧都flush.
+	// 连接池每次只把连接交给一个调用, 只有多个帧同时排队在一条连接上时才会合并, 收益主要在 server 端
+	WriteCoalesceDelay time.Duration
+
+	DisableHandshake bool   // 不握手, 始终使用 v1, 兼容不能处理未知帧的对端
+	MinVersion       uint16 // 协商结果低于该版本时连接失败, 0 接受旧版本 server
+	MaxFrameSize     int    // 能接收的最大 body, 默认 constant.MaxFrameSize
+
+	Compressors       []uint8 // 按偏好排序的压缩算法(见 compress 包), 为空时不压缩
+	CompressThreshold int     // 请求的 body 小于该大小时不压缩, 默认 constant.CompressThreshold
+
+	EnableChecksum bool // 协商每帧带 CRC32C 校验和, 校验失败时连接被重置
+
+	Secure *secure.Config // 非空时在连接建立后先握手建立加密通道, server 需要同样开启
+
+	// TLSConfig 非空时使用 TLS, 设置 Certificates 即为 mTLS; 同时开启 Secure 时加密通道建立在 TLS 之内.
+	// vsock 地址需要设置 ServerName 或自定义证书校验
+	TLSConfig *tls.Config
+
+	// Credentials 为每个请求附带认证数据, 可以用 WithCredentials 按调用覆盖
+	Credentials Credentials
+
+	// Breaker 非空时开启熔断: 目标连续失败后调用直接返回 *errors.BreakerOpenError, 不再等待拨号或超时
+	Breaker *BreakerConfig
+
+	// Hedge 非空时 WithHedging 标记的调用在延迟之后向另一个地址或连接再发送一次, 取最先成功的回复
+	Hedge *HedgeConfig
 }
 
 func (cfg *Config) GetTimeout() time.Duration {
@@ -43,3 +75,15 @@ func (cfg *Config) GetReadBufferSize() int {
 	}
 	return constant.MaxReadBufferSize
 }
+func (cfg *Config) GetStreamWindowSize() int {
+	if cfg.StreamWindowSize > 0 {
+		return cfg.StreamWindowSize
+	}
+	return constant.StreamWindowSize
+}
+func (cfg *Config) GetCompressThreshold() int {
+	if cfg.CompressThreshold > 0 {
+		return cfg.CompressThreshold
+	}
+	return constant.CompressThreshold
+}
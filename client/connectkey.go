@@ -8,6 +8,8 @@ import (
 type connectKey struct {
 	Uri  string
 	Port uint32
+
+	Identity string // TLS 身份, 不同身份的连接不共用
 }
 
 func (ck *connectKey) From(addr models.Addr) {
@@ -22,5 +24,18 @@ func (ck *connectKey) From(addr models.Addr) {
 }
 
 func (ck *connectKey) Equal(target *connectKey) bool {
-	return ck.Uri == target.Uri && ck.Port == target.Port
+	return ck.Uri == target.Uri && ck.Port == target.Port && ck.Identity == target.Identity
+}
+
+// addr 不含 TLS 身份的地址
+func (ck *connectKey) addr() string {
+	return ck.Uri + ":" + strconv.FormatUint(uint64(ck.Port), 10)
+}
+
+func (ck *connectKey) String() string {
+	s := ck.addr()
+	if ck.Identity != "" {
+		s += "#" + ck.Identity
+	}
+	return s
 }
@@ -13,12 +13,20 @@ type ConnPool struct {
 	idleTimeout time.Duration
 
 	maxCapacityPerKey int
+
+	// 被 Drain 的地址(不含 TLS 身份)及被移除的 Endpoint, 之后归还的连接直接关闭;
+	// 这些 Endpoint 上没有进行中的调用后记录被清理
+	draining map[string][]*Endpoint
 }
 
 func (cp *ConnPool) Get(key connectKey) *PersistConn {
 	cp.mutex.Lock()
 	defer cp.mutex.Unlock()
 
+	if len(cp.draining) > 0 {
+		cp.sweepDrainingLocked()
+	}
+
 	var idleBegin time.Time
 	if cp.idleTimeout > 0 {
 		idleBegin = time.Now().Add(-cp.idleTimeout)
@@ -69,6 +77,11 @@ func (cp *ConnPool) Put(conn *PersistConn) {
 	cp.mutex.Lock()
 	defer cp.mutex.Unlock()
 
+	if _, ok := cp.draining[conn.key.addr()]; ok {
+		conn.close(errors.ErrConnDrained)
+		return
+	}
+
 	idleTimeout := cp.idleTimeout
 
 	conn.reused = true
@@ -145,3 +158,50 @@ func (cp *ConnPool) Exist(pConn *PersistConn) bool {
 
 	return false
 }
+
+// Drain 关闭 key 地址上所有空闲的连接, 不区分 TLS 身份; 正在使用的连接在调用结束归还时关闭,
+// 直到 inflight 上都没有进行中的调用
+func (cp *ConnPool) Drain(key connectKey, inflight []*Endpoint) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	if cp.draining == nil {
+		cp.draining = make(map[string][]*Endpoint)
+	}
+	cp.sweepDrainingLocked()
+	cp.draining[key.addr()] = append(cp.draining[key.addr()], inflight...)
+
+	for k, list := range cp.pool {
+		if k.addr() != key.addr() {
+			continue
+		}
+		for _, pConn := range list {
+			pConn.close(errors.ErrConnDrained)
+		}
+		delete(cp.pool, k)
+	}
+}
+
+// sweepDrainingLocked 清理没有进行中的调用的记录. 调用结束时先归还连接再释放 Endpoint,
+// 所以记录在之后的 Get 或 Drain 中才被清理
+func (cp *ConnPool) sweepDrainingLocked() {
+	for addr, eps := range cp.draining {
+		busy := false
+		for _, ep := range eps {
+			if ep.Outstanding() > 0 {
+				busy = true
+				break
+			}
+		}
+		if !busy {
+			delete(cp.draining, addr)
+		}
+	}
+}
+
+// Undrain 地址重新加入后恢复复用
+func (cp *ConnPool) Undrain(key connectKey) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	delete(cp.draining, key.addr())
+}
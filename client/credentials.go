@@ -0,0 +1,35 @@
+package client
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/errors"
+)
+
+// Credentials 为每个请求生成认证数据, 由 server 的 Authenticator 校验; 流只在打开时认证, body 为 nil.
+// 内置实现见 auth 包
+type Credentials interface {
+	Credential(path string, body []byte) ([]byte, error)
+}
+
+type credentialsContextKey struct{}
+
+// WithCredentials 该 ctx 发起的调用(DoContext, Go)使用 creds 代替 Config.Credentials
+func WithCredentials(ctx context.Context, creds Credentials) context.Context {
+	return context.WithValue(ctx, credentialsContextKey{}, creds)
+}
+
+// credential 没有配置 Credentials 时返回 nil
+func (tp *Transport) credential(ctx context.Context, path string, body []byte) ([]byte, error) {
+	creds, ok := ctx.Value(credentialsContextKey{}).(Credentials)
+	if !ok {
+		creds = tp.Credentials
+	}
+	if creds == nil {
+		return nil, nil
+	}
+	auth, err := creds.Credential(path, body)
+	if err != nil {
+		return nil, errors.Wrap(errors.ErrCredential, err)
+	}
+	return auth, nil
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"github.com/brodyxchen/vsock-sdk/compress"
+	"github.com/brodyxchen/vsock-sdk/constant"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/protocols"
+	"github.com/brodyxchen/vsock-sdk/socket"
+	"google.golang.org/protobuf/proto"
+	"net"
+	"time"
+)
+
+// handshake 新连接上的第一次往返, 在读写循环启动前同步完成, 协商之后所有帧使用的版本和特性.
+// 旧版本 server 不认识握手帧, 会把它当作空 path 的请求回复 invalid path, 此时按 v1 通信
+func (tp *Transport) handshake(ctx context.Context, conn net.Conn, reader *bufio.Reader, writer *bufio.Writer) (models.Protocol, error) {
+	if tp.DisableHandshake {
+		return models.LegacyProtocol, nil
+	}
+
+	_ = conn.SetDeadline(handshakeDeadline(ctx))
+	defer func() {
+		_ = conn.SetDeadline(time.Time{})
+	}()
+
+	minVersion := tp.minVersion()
+	versions := make([]uint32, 0, constant.MaxVersion)
+	for v := minVersion; v <= constant.MaxVersion; v++ {
+		versions = append(versions, uint32(v))
+	}
+	features := constant.SupportedFeatures
+	compressors := make([]uint32, 0, len(tp.Compressors))
+	for _, id := range tp.Compressors {
+		if compress.Get(id) != nil {
+			compressors = append(compressors, uint32(id))
+		}
+	}
+	if len(compressors) == 0 {
+		features &^= constant.FeatureCompression
+	}
+	if !tp.EnableChecksum {
+		features &^= constant.FeatureChecksum
+	}
+
+	hello, _ := proto.Marshal(&protocols.Hello{
+		Versions:     versions,
+		Features:     features,
+		MaxFrameSize: uint32(tp.maxFrameSize()),
+		Compressors:  compressors,
+	})
+
+	header := &models.Header{
+		Magic:   constant.DefaultMagic,
+		Version: constant.DefaultVersion,
+		Code:    constant.ActionHandshake,
+	}
+	if _, err := socket.WriteSocket(ctx, writer, header, hello); err != nil {
+		return models.Protocol{}, errors.Wrap(errors.ErrWriteSocketErr, err)
+	}
+
+	buf, _, err := socket.ReadFrame(ctx, reader, header, constant.MaxFrameSizeV1)
+	if err != nil {
+		return models.Protocol{}, errors.Wrap(errors.ErrReadSocketErr, err)
+	}
+	defer buf.Release()
+
+	var body []byte
+	if buf != nil {
+		body = buf.B
+	}
+	if header.Version != constant.DefaultVersion {
+		return models.Protocol{}, errors.ErrInvalidHandshake
+	}
+
+	switch header.Code {
+	case 0:
+	case errors.StatusInvalidRequest.Code(), errors.StatusInvalidPath.Code(): // 旧版本 server
+		if minVersion > constant.DefaultVersion {
+			return models.Protocol{}, errors.ErrIncompatibleVersion
+		}
+		return models.LegacyProtocol, nil
+	case errors.StatusIncompatibleVersion.Code():
+		return models.Protocol{}, errors.ErrIncompatibleVersion
+	default:
+		return models.Protocol{}, errors.NewStatus(header.Code, string(body))
+	}
+
+	var rsp protocols.Hello
+	if err = proto.Unmarshal(body, &rsp); err != nil {
+		return models.Protocol{}, errors.ErrInvalidHandshake
+	}
+
+	// server 只能从 client 提供的选项中选择
+	if rsp.Version < uint32(minVersion) || rsp.Version > uint32(constant.MaxVersion) {
+		return models.Protocol{}, errors.ErrIncompatibleVersion
+	}
+	if rsp.Features&^features != 0 || rsp.MaxFrameSize == 0 || int(rsp.MaxFrameSize) > tp.maxFrameSize() {
+		return models.Protocol{}, errors.ErrInvalidHandshake
+	}
+
+	protocol := models.Protocol{
+		Version:      uint16(rsp.Version),
+		Features:     rsp.Features,
+		MaxFrameSize: int(rsp.MaxFrameSize),
+	}
+	if protocol.Has(constant.FeatureCompression) {
+		if !offered(compressors, rsp.Compressor) {
+			return models.Protocol{}, errors.ErrInvalidHandshake
+		}
+		protocol.Compressor = uint8(rsp.Compressor)
+	}
+	return protocol, nil
+}
+
+func offered(ids []uint32, id uint32) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// handshakeDeadline ctx 没有 deadline 时使用 constant.HandshakeTimeout
+func handshakeDeadline(ctx context.Context) time.Time {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline
+	}
+	return time.Now().Add(constant.HandshakeTimeout)
+}
+
+func (tp *Transport) minVersion() uint16 {
+	if tp.MinVersion > constant.DefaultVersion {
+		return tp.MinVersion
+	}
+	return constant.DefaultVersion
+}
+
+func (tp *Transport) maxFrameSize() int {
+	if tp.MaxFrameSize > 0 {
+		return tp.MaxFrameSize
+	}
+	return constant.MaxFrameSize
+}
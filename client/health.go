@@ -0,0 +1,214 @@
+package client
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/constant"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/log"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HealthCheckConfig 主动健康检查, 见 Client.WatchHealth
+type HealthCheckConfig struct {
+	Service  string        // 检查的服务, 空为整个 server
+	Interval time.Duration // 默认 constant.HealthCheckInterval
+	Timeout  time.Duration // 每次检查的超时, 默认 constant.HealthCheckTimeout
+
+	UnhealthyThreshold int // 连续失败多少次后标记为不可用, 默认 1
+	HealthyThreshold   int // 连续成功多少次后恢复, 默认 1
+
+	// OnChange 地址的可用状态变化时调用, status 为最近一次检查的结果, 检查失败时为 HealthUnknown
+	OnChange func(addr models.Addr, healthy bool, status models.HealthStatus)
+}
+
+func (cfg *HealthCheckConfig) interval() time.Duration {
+	if cfg.Interval > 0 {
+		return cfg.Interval
+	}
+	return constant.HealthCheckInterval
+}
+
+func (cfg *HealthCheckConfig) timeout() time.Duration {
+	if cfg.Timeout > 0 {
+		return cfg.Timeout
+	}
+	return constant.HealthCheckTimeout
+}
+
+func (cfg *HealthCheckConfig) unhealthyThreshold() int {
+	if cfg.UnhealthyThreshold > 0 {
+		return cfg.UnhealthyThreshold
+	}
+	return 1
+}
+
+func (cfg *HealthCheckConfig) healthyThreshold() int {
+	if cfg.HealthyThreshold > 0 {
+		return cfg.HealthyThreshold
+	}
+	return 1
+}
+
+// HealthStatus 最近一次健康检查的结果, 没有检查过或检查失败时为 HealthUnknown
+func (ep *Endpoint) HealthStatus() models.HealthStatus {
+	return models.HealthStatus(atomic.LoadInt32(&ep.status))
+}
+
+// CheckHealth 向 addr 发送一次健康检查. 检查经过熔断器, 结果同样计入熔断
+func (cli *Client) CheckHealth(ctx context.Context, addr models.Addr, service string) (models.HealthStatus, error) {
+	cli.transport.healthCheckCounter.Inc(1)
+	rsp, err := cli.send(ctx, addr, constant.HealthPath, []byte(service))
+	if err != nil {
+		cli.transport.healthFailCounter.Inc(1)
+		return models.HealthUnknown, err
+	}
+	status := models.ParseHealthStatus(string(rsp))
+	if status != models.HealthServing {
+		cli.transport.healthFailCounter.Inc(1)
+	}
+	return status, nil
+}
+
+// WatchHealth 在后台每隔 Interval 检查 target 的所有地址, 直到 ctx 结束. 地址连续 UnhealthyThreshold 次
+// 检查失败或不是 HealthServing 时被标记为不可用, 不再参与选择; 配置了熔断时同时打开该地址的熔断器,
+// 直接使用该地址的调用也快速失败. 熔断器打开期间的检查被拒绝, 不计入结果, 冷却结束后检查作为半开的探测.
+// ctx 结束后被标记为不可用的地址恢复可用
+func (cli *Client) WatchHealth(ctx context.Context, target *Target, cfg *HealthCheckConfig) {
+	if cfg == nil {
+		cfg = &HealthCheckConfig{}
+	}
+	go func() {
+		ticker := time.NewTicker(cfg.interval())
+		defer ticker.Stop()
+		states := make(map[string]*healthState)
+		defer func() {
+			for _, state := range states {
+				if state.unhealthy {
+					cli.transport.setEndpointHealth(state, true, nil)
+				}
+			}
+		}()
+		for {
+			cli.checkTarget(ctx, target, cfg, states)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// healthState WatchHealth 对一个地址的记录
+type healthState struct {
+	ep        *Endpoint
+	streak    int  // >0 连续成功次数, <0 连续失败次数
+	unhealthy bool // 被标记为不可用
+}
+
+// checkTarget 并发检查所有地址, 等全部完成后更新状态
+func (cli *Client) checkTarget(ctx context.Context, target *Target, cfg *HealthCheckConfig, states map[string]*healthState) {
+	endpoints := target.Endpoints()
+	results := make([]int, len(endpoints)) // 1 成功, -1 失败, 0 没有结果
+	var wg sync.WaitGroup
+	for i, ep := range endpoints {
+		wg.Add(1)
+		go func(i int, ep *Endpoint) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, cfg.timeout())
+			defer cancel()
+			status, err := cli.CheckHealth(checkCtx, ep.Addr, cfg.Service)
+			if errors.IsBreakerOpen(err) || ctx.Err() != nil {
+				return
+			}
+			atomic.StoreInt32(&ep.status, int32(status))
+			if status == models.HealthServing {
+				results[i] = 1
+				return
+			}
+			results[i] = -1
+			if err != nil {
+				log.Debugf("health check %v: %v\n", ep.Addr.GetAddr(), err)
+			}
+		}(i, ep)
+	}
+	wg.Wait()
+
+	current := make(map[*Endpoint]bool, len(endpoints))
+	for i, ep := range endpoints {
+		current[ep] = true
+		state, ok := states[ep.Addr.GetAddr()]
+		if !ok || state.ep != ep {
+			// 新的地址, 或被移除后重新加入
+			if ok && state.unhealthy {
+				cli.transport.unhealthyCounter.Dec(1)
+			}
+			state = &healthState{ep: ep}
+			states[ep.Addr.GetAddr()] = state
+		}
+		switch {
+		case results[i] > 0 && state.streak >= 0:
+			state.streak++
+		case results[i] > 0:
+			state.streak = 1
+		case results[i] < 0 && state.streak <= 0:
+			state.streak--
+		case results[i] < 0:
+			state.streak = -1
+		}
+
+		switch {
+		case state.unhealthy && state.streak >= cfg.healthyThreshold():
+			cli.transport.setEndpointHealth(state, true, cfg)
+		case !state.unhealthy && -state.streak >= cfg.unhealthyThreshold():
+			cli.transport.setEndpointHealth(state, false, cfg)
+		}
+		// 不可用期间每次失败都重新打开, 回复 HealthNotServing 的半开探测不会使熔断器关闭
+		if state.unhealthy && results[i] < 0 {
+			cli.transport.tripBreaker(ctx, ep.Addr)
+		}
+	}
+	// 已经移除的地址
+	for key, state := range states {
+		if !current[state.ep] {
+			if state.unhealthy {
+				cli.transport.unhealthyCounter.Dec(1)
+			}
+			delete(states, key)
+		}
+	}
+}
+
+// setEndpointHealth cfg 为 nil 时不回调
+func (tp *Transport) setEndpointHealth(state *healthState, healthy bool, cfg *HealthCheckConfig) {
+	state.unhealthy = !healthy
+	state.ep.SetHealthy(healthy)
+	if healthy {
+		tp.unhealthyCounter.Dec(1)
+	} else {
+		tp.unhealthyCounter.Inc(1)
+	}
+	log.Infof("endpoint %v healthy: %v\n", state.ep.Addr.GetAddr(), healthy)
+	if cfg != nil && cfg.OnChange != nil {
+		cfg.OnChange(state.ep.Addr, healthy, state.ep.HealthStatus())
+	}
+}
+
+// tripBreaker 健康检查失败时直接打开熔断器, 已经打开的不重新计时
+func (tp *Transport) tripBreaker(ctx context.Context, addr models.Addr) {
+	key := connectKey{}
+	key.From(addr)
+	key.Identity = tp.tlsIdentity(tp.tlsConfig(ctx))
+	br := tp.breaker(key)
+	if br == nil {
+		return
+	}
+	br.mutex.Lock()
+	defer br.mutex.Unlock()
+	if br.state != BreakerOpen {
+		br.setState(BreakerOpen, time.Now())
+	}
+}
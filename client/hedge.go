@@ -0,0 +1,240 @@
+package client
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/constant"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/socket"
+	"math"
+	"sync"
+	"time"
+)
+
+// HedgeConfig 对冲请求: 调用超过对冲延迟还没有回复时, 再向另一个地址(Target)或另一条连接发送一次,
+// 取最先成功的回复并取消其余的. 只对 WithHedging 标记的调用和批量调用生效, 调用方需要保证它们是幂等的
+type HedgeConfig struct {
+	Delay      time.Duration // 固定的对冲延迟; 为 0 时使用 tp.trip 的 Percentile 分位数, 样本不足时不对冲
+	Percentile float64       // 默认 0.95
+	MinDelay   time.Duration // 按分位数计算时的下限, 默认 constant.HedgeMinDelay
+
+	MaxHedges int // 每个调用最多额外发送的次数, 默认 1
+
+	// BudgetRatio 对冲请求与调用数之比的上限, 默认 0.1: 每个调用积累 BudgetRatio 个名额,
+	// 最多积累 constant.HedgeBudgetBurst 个, 每次对冲消耗一个
+	BudgetRatio float64
+}
+
+func (cfg *HedgeConfig) percentile() float64 {
+	if cfg.Percentile > 0 {
+		return cfg.Percentile
+	}
+	return 0.95
+}
+
+func (cfg *HedgeConfig) minDelay() time.Duration {
+	if cfg.MinDelay > 0 {
+		return cfg.MinDelay
+	}
+	return constant.HedgeMinDelay
+}
+
+func (cfg *HedgeConfig) maxHedges() int {
+	if cfg.MaxHedges > 0 {
+		return cfg.MaxHedges
+	}
+	return 1
+}
+
+func (cfg *HedgeConfig) budgetRatio() float64 {
+	if cfg.BudgetRatio > 0 {
+		return cfg.BudgetRatio
+	}
+	return 0.1
+}
+
+// hedgeState Transport 上对冲的预算和缓存的延迟
+type hedgeState struct {
+	mutex   sync.Mutex
+	tokens  float64
+	delay   time.Duration
+	delayAt time.Time // 上次计算分位数的时间
+}
+
+type hedgingContextKey struct{}
+
+// WithHedging 该 ctx 发起的调用(DoContext, Go)可以对冲(见 HedgeConfig), 调用方保证调用是幂等的
+func WithHedging(ctx context.Context) context.Context {
+	return context.WithValue(ctx, hedgingContextKey{}, true)
+}
+
+func (tp *Transport) hedgeable(req *models.Request) bool {
+	if tp.Hedge == nil || (req.Code != constant.ActionCall && req.Code != constant.ActionBatch) {
+		return false
+	}
+	hedging, _ := req.Context().Value(hedgingContextKey{}).(bool)
+	return hedging
+}
+
+// hedgeDelay 分位数每 constant.HedgeDelayRefresh 重新计算一次
+func (tp *Transport) hedgeDelay() (time.Duration, bool) {
+	if tp.Hedge.Delay > 0 {
+		return tp.Hedge.Delay, true
+	}
+	hs := &tp.hedging
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	if now := time.Now(); now.Sub(hs.delayAt) >= constant.HedgeDelayRefresh {
+		hs.delayAt = now
+		hs.delay = 0
+		if tp.tripHist.Count() >= constant.HedgeMinSamples {
+			// tp.trip 以毫秒记录
+			hs.delay = time.Duration(tp.tripHist.Percentile(tp.Hedge.percentile()) * float64(time.Millisecond))
+			if min := tp.Hedge.minDelay(); hs.delay < min {
+				hs.delay = min
+			}
+		}
+	}
+	return hs.delay, hs.delay > 0
+}
+
+// depositHedge 每个可以对冲的调用积累预算
+func (tp *Transport) depositHedge() {
+	hs := &tp.hedging
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	hs.tokens = math.Min(hs.tokens+tp.Hedge.budgetRatio(), constant.HedgeBudgetBurst)
+}
+
+func (tp *Transport) withdrawHedge() bool {
+	hs := &tp.hedging
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	if hs.tokens < 1 {
+		tp.hedgeThrottledCounter.Inc(1)
+		return false
+	}
+	hs.tokens--
+	return true
+}
+
+// hedgeFatal 第一次发送收到 server 的回复(包括状态)和调用方的 ctx 结束不再对冲;
+// 对冲的发送返回的状态只算这次发送失败, 其余发送继续
+func hedgeFatal(ctx context.Context, r hedgeResult) bool {
+	if _, ok := r.err.(*errors.Status); ok && !r.hedge {
+		return true
+	}
+	return ctx.Err() != nil || r.err == errors.ErrExceedBody
+}
+
+type hedgeResult struct {
+	rsp   *models.Response
+	err   error
+	hedge bool
+}
+
+// hedgedRoundTrip 第一次发送后每隔对冲延迟再发送一次, 直到 MaxHedges 或预算用完.
+// 所有发送都失败时, 还有名额就立即再发送, 否则返回最后一个错误
+func (tp *Transport) hedgedRoundTrip(req *models.Request) (*models.Response, error) {
+	tp.depositHedge()
+	delay, ok := tp.hedgeDelay()
+	if !ok {
+		return tp.singleRoundTrip(req)
+	}
+
+	parent := req.Context()
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel() // 取消还没有回复的发送, 它们不计入熔断(见 breakerOutcome)
+
+	maxHedges := tp.Hedge.maxHedges()
+	results := make(chan hedgeResult, maxHedges+1)
+	var used []*Endpoint
+
+	// launch 选择地址后在新的 goroutine 中发送; 每次发送各自持有 frame 的引用, 调用方返回后仍然有效.
+	// 对冲的发送有 req.Encode 时重新编码, 一次性的认证信息不会被 server 当作重放拒绝
+	launch := func(hedge bool) error {
+		attempt := *req
+		attempt.Ctx = ctx
+		var ep *Endpoint
+		if target, ok := req.Addr.(*Target); ok {
+			var err error
+			if ep, err = tp.pick(ctx, target, used); err != nil {
+				return err
+			}
+			used = append(used, ep)
+			attempt.Addr = ep.Addr
+		}
+		if hedge && req.Encode != nil {
+			frame, err := req.Encode()
+			if err != nil {
+				if ep != nil {
+					ep.release()
+				}
+				return err
+			}
+			attempt.Frame = frame
+			attempt.Body = socket.FrameBody(frame)
+		} else {
+			req.Frame.Retain()
+		}
+		go func() {
+			rsp, err := tp.breakerRoundTrip(&attempt)
+			if ep != nil {
+				ep.release()
+			}
+			attempt.Frame.Release()
+			results <- hedgeResult{rsp: rsp, err: err, hedge: hedge}
+		}()
+		return nil
+	}
+	hedge := func() bool {
+		if !tp.withdrawHedge() || launch(true) != nil {
+			return false
+		}
+		tp.hedgeCounter.Inc(1)
+		return true
+	}
+
+	if err := launch(false); err != nil {
+		return nil, err
+	}
+	var (
+		inflight = 1
+		hedges   = 0
+		timer    = time.NewTimer(delay)
+	)
+	defer timer.Stop()
+	for {
+		select {
+		case r := <-results:
+			inflight--
+			if r.err == nil {
+				if r.hedge {
+					tp.hedgeWinCounter.Inc(1)
+				}
+				return r.rsp, nil
+			}
+			if hedgeFatal(parent, r) {
+				return nil, r.err
+			}
+			if inflight > 0 {
+				continue
+			}
+			if hedges < maxHedges && hedge() {
+				hedges++
+				inflight++
+				continue
+			}
+			return nil, r.err
+
+		case <-timer.C:
+			if hedges < maxHedges && hedge() {
+				hedges++
+				inflight++
+				if hedges < maxHedges {
+					timer.Reset(delay)
+				}
+			}
+		}
+	}
+}
@@ -2,6 +2,10 @@ package client
 
 import (
 	"bufio"
+	"encoding/binary"
+	"github.com/brodyxchen/vsock-sdk/buffer"
+	"github.com/brodyxchen/vsock-sdk/compress"
+	"github.com/brodyxchen/vsock-sdk/constant"
 	"github.com/brodyxchen/vsock-sdk/errors"
 	"github.com/brodyxchen/vsock-sdk/log"
 	"github.com/brodyxchen/vsock-sdk/models"
@@ -23,6 +27,8 @@ type PersistConn struct {
 	conn      net.Conn
 	bufReader *bufio.Reader // from conn
 	bufWriter *bufio.Writer // to conn
+	coalescer *socket.Coalescer
+	protocol  models.Protocol // 握手协商的结果
 
 	receiveCh chan *models.NotifyReceive
 	sendCh    chan *models.SendRequest
@@ -44,8 +50,9 @@ func (pc *PersistConn) roundTrip(req *models.Request) (*models.Response, error)
 
 	sendNow := time.Now()
 
-	// 发送数据
+	// 发送数据, writeLoop 持有一个 Frame 引用, 调用方可能先于写出返回
 	sendReply := make(chan error, 1)
+	req.Frame.Retain()
 	pc.sendCh <- &models.SendRequest{Req: req, Reply: sendReply}
 
 	// 通知接收
@@ -56,6 +63,17 @@ func (pc *PersistConn) roundTrip(req *models.Request) (*models.Response, error)
 		CallerGone: gone,
 	}
 
+	reply := func(rpy *models.ReceiveResponse) (*models.Response, error) {
+		pc.transport.receiveHist.Update(time.Since(sendNow).Milliseconds())
+		if st, ok := rpy.Err.(*errors.Status); ok { // server 回复的状态, 连接正常
+			return nil, st
+		}
+		if rpy.Err != nil {
+			return nil, errors.Wrap(errors.ErrReceiveErr, rpy.Err)
+		}
+		return rpy.Rsp, nil
+	}
+
 	for {
 		select {
 		case err := <-sendReply:
@@ -64,14 +82,16 @@ func (pc *PersistConn) roundTrip(req *models.Request) (*models.Response, error)
 				return nil, errors.Wrap(errors.ErrSendErr, err)
 			}
 		case rpy := <-receiveReply:
-			pc.transport.receiveHist.Update(time.Since(sendNow).Milliseconds())
-			if rpy.Err != nil {
-				return nil, errors.Wrap(errors.ErrReceiveErr, rpy.Err)
-			}
-			return rpy.Rsp, nil
+			return reply(rpy)
 
 		// 异常处理
 		case <-pc.closedCh: // 外部关闭
+			// server 回复后立即关闭连接时两者可能同时就绪, 已经收到的回复优先
+			select {
+			case rpy := <-receiveReply:
+				return reply(rpy)
+			default:
+			}
 			pc.transport.receiveTimeoutHist.Update(time.Since(sendNow).Milliseconds())
 			if pc.closed != nil {
 				return nil, pc.closed
@@ -84,6 +104,31 @@ func (pc *PersistConn) roundTrip(req *models.Request) (*models.Response, error)
 	}
 }
 
+// notify 只发送, 数据flush后返回, 不等待回复
+func (pc *PersistConn) notify(req *models.Request) error {
+	sendNow := time.Now()
+
+	sendReply := make(chan error, 1)
+	req.Frame.Retain()
+	pc.sendCh <- &models.SendRequest{Req: req, Reply: sendReply}
+
+	select {
+	case err := <-sendReply:
+		pc.transport.sendDoneHist.Update(time.Since(sendNow).Milliseconds())
+		if err != nil {
+			return errors.Wrap(errors.ErrSendErr, err)
+		}
+		return nil
+	case <-pc.closedCh: // 外部关闭
+		if pc.closed != nil {
+			return pc.closed
+		}
+		return errors.ErrClosed
+	case <-req.Ctx.Done(): // ctx结束
+		return errors.ErrCtxDone
+	}
+}
+
 func (pc *PersistConn) Read(p []byte) (n int, err error) {
 	n, err = pc.conn.Read(p)
 	return
@@ -108,7 +153,16 @@ func (pc *PersistConn) writeLoop() {
 			return
 		case writeReq := <-pc.sendCh:
 			req := writeReq.Req
-			broken, err := socket.WriteSocket(req.Ctx, pc.bufWriter, &req.Header, req.Body)
+			frame := req.Frame
+			if frame == nil {
+				frame = socket.NewFrame(len(req.Body))
+				frame.B = append(frame.B, req.Body...)
+			}
+			// 还有请求排队时先不flush; notify 在 flush 后才返回, 写出失败要能计入 notify.fail.
+			// 经过 ConnPool 的连接同时只有一个调用, 这里很少有排队的帧
+			more := len(pc.sendCh) > 0 && req.Code != constant.ActionNotify
+			broken, err := pc.coalescer.WriteFrame(req.Ctx, &req.Header, frame, more)
+			frame.Release()
 			if err != nil {
 				writeReq.Reply <- err
 
@@ -137,10 +191,17 @@ func (pc *PersistConn) readLoop() {
 			return nil, errors.ErrUnknownServerErr
 		}
 
-		// 服务器 错误
+		// 服务器 错误, 保留状态码
 		if header.Code != 0 {
-			errMsg := string(body)
-			return nil, errors.New(errMsg)
+			var retryAfter time.Duration
+			if header.Flags&constant.FlagRetryAfter != 0 {
+				if len(body) < 4 {
+					return nil, errors.ErrUnknownServerErr
+				}
+				retryAfter = time.Duration(binary.BigEndian.Uint32(body)) * time.Millisecond
+				body = body[4:]
+			}
+			return nil, errors.NewStatus(header.Code, string(body)).WithRetryAfter(retryAfter)
 		}
 
 		var pbBody protocols.Response
@@ -185,9 +246,24 @@ func (pc *PersistConn) readLoop() {
 
 		notifyReq = <-pc.receiveCh
 
-		header, body, broken, err := socket.ReadSocket(notifyReq.Req.Ctx, pc.bufReader)
+		header := &models.Header{}
+		buf, broken, err := socket.ReadFrame(notifyReq.Req.Ctx, pc.bufReader, header, pc.protocol.MaxFrameSize)
 		if err == nil {
-			rsp, err = wrap(header, body)
+			if err = pc.protocol.Check(header); err != nil {
+				buf.Release()
+				broken = true
+			}
+		}
+		if err == nil && header.Flags&constant.FlagCompressed != 0 {
+			buf, err = pc.decompress(buf)
+		}
+		if err == nil {
+			var body []byte
+			if buf != nil {
+				body = buf.B
+			}
+			rsp, err = wrap(header, body) // body 被拷贝
+			buf.Release()
 			if rsp != nil {
 				rsp.Req = notifyReq.Req
 			}
@@ -200,7 +276,8 @@ func (pc *PersistConn) readLoop() {
 		case <-notifyReq.CallerGone:
 		}
 
-		if err != nil && broken {
+		// 帧格式错误后字节流已经错位, 同样关闭
+		if err != nil && (broken || errors.IsProtocolError(err)) {
 			closeErr = errors.Wrap(errors.ErrReadSocketErr, err)
 			return
 		}
@@ -209,6 +286,21 @@ func (pc *PersistConn) readLoop() {
 	closeErr = errors.ErrClosed
 }
 
+// decompress 释放 buf, 返回解压后的缓冲
+func (pc *PersistConn) decompress(buf *buffer.Buffer) (*buffer.Buffer, error) {
+	defer buf.Release()
+
+	compressor := compress.Get(pc.protocol.Compressor)
+	if pc.protocol.Compressor == 0 || compressor == nil {
+		return nil, errors.ErrUnknownCompressor
+	}
+
+	now := time.Now()
+	body, err := socket.DecompressBody(compressor, buf, pc.transport.maxFrameSize())
+	pc.transport.decompressCostHist.Update(time.Since(now).Microseconds())
+	return body, err
+}
+
 func (pc *PersistConn) isClosed() bool {
 	pc.closedMutex.RLock()
 	defer pc.closedMutex.RUnlock()
@@ -235,6 +327,7 @@ func (pc *PersistConn) closeLocked(err error) {
 	if pc.idleTimer != nil {
 		pc.idleTimer.Stop()
 	}
+	pc.coalescer.Stop()
 
 	_ = pc.conn.Close()
 }
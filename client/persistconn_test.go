@@ -0,0 +1,68 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"github.com/brodyxchen/vsock-sdk/constant"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/socket"
+	"net"
+	"testing"
+	"time"
+)
+
+// countConn 记录 Write 的次数
+type countConn struct {
+	net.Conn
+	writes chan []byte
+}
+
+func (c *countConn) Write(p []byte) (int, error) {
+	c.writes <- append([]byte(nil), p...)
+	return len(p), nil
+}
+
+func TestPersistConnCoalesce(t *testing.T) {
+	conn := &countConn{writes: make(chan []byte, 10)}
+	pc := &PersistConn{
+		conn:     conn,
+		sendCh:   make(chan *models.SendRequest, 3),
+		closedCh: make(chan struct{}, 1),
+	}
+	pc.bufWriter = bufio.NewWriterSize(pc, 4096)
+	pc.coalescer = socket.NewCoalescer(pc.bufWriter, time.Second)
+
+	// writeLoop 启动前排队的三帧在最后一帧写入时一起 flush
+	replies := make(chan error, 3)
+	for _, body := range []string{"a", "b", "c"} {
+		frame := socket.NewFrame(1)
+		frame.B = append(frame.B, body...)
+		req := &models.Request{
+			Header: models.Header{Magic: constant.DefaultMagic, Version: constant.DefaultVersion, Code: constant.ActionCall},
+			Ctx:    context.Background(),
+			Frame:  frame,
+		}
+		pc.sendCh <- &models.SendRequest{Req: req, Reply: replies}
+	}
+	go pc.writeLoop()
+	defer close(pc.closedCh)
+
+	for i := 0; i < 3; i++ {
+		if err := <-replies; err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case p := <-conn.writes:
+		if len(p) != 3*(models.HeaderSize+1) {
+			t.Fatalf("write %d bytes", len(p))
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("queued frames not flushed")
+	}
+	select {
+	case p := <-conn.writes:
+		t.Fatalf("extra write %d bytes", len(p))
+	default:
+	}
+}
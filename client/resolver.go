@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/log"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"sync"
+)
+
+// Resolver 产生 Target 的地址列表, 内置实现见 resolver 包
+type Resolver interface {
+	// Watch 先以当前的地址列表调用一次 update, 之后每次变化时再调用, 直到 ctx 结束;
+	// update 不会被并发调用. 地址列表不再变化时可以提前返回 nil
+	Watch(ctx context.Context, update func(addrs []models.Addr)) error
+}
+
+// WatchTarget 在后台用 resolver 更新 target 的地址列表, 等到第一次更新后返回, ctx 结束时停止.
+// 被移除的地址上空闲的连接立即关闭, 进行中的调用结束后关闭; 连接不属于某个 Target,
+// 所以直接使用该地址的调用同样受影响
+func (cli *Client) WatchTarget(ctx context.Context, target *Target, resolver Resolver) error {
+	var (
+		once  sync.Once
+		first = make(chan struct{})
+		done  = make(chan error, 1)
+	)
+	go func() {
+		err := resolver.Watch(ctx, func(addrs []models.Addr) {
+			cli.transport.updateTarget(target, addrs)
+			once.Do(func() { close(first) })
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Errorf("resolver for %v stopped: %v\n", target.Name, err)
+		}
+		done <- err
+	}()
+
+	select {
+	case <-first:
+		return nil
+	case err := <-done:
+		select {
+		case <-first:
+			return nil
+		default:
+		}
+		if err == nil {
+			err = errors.ErrResolverStopped
+		}
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// updateTarget 替换地址列表并 drain 被移除的地址
+func (tp *Transport) updateTarget(target *Target, addrs []models.Addr) {
+	removed := make(map[string]*Endpoint)
+	for _, ep := range target.Endpoints() {
+		removed[ep.Addr.GetAddr()] = ep
+	}
+	for _, addr := range addrs {
+		key := connectKey{}
+		key.From(addr)
+		if _, ok := removed[addr.GetAddr()]; ok {
+			delete(removed, addr.GetAddr())
+		} else {
+			tp.connPool.Undrain(key)
+		}
+	}
+
+	target.SetEndpoints(addrs)
+
+	for _, ep := range removed {
+		key := connectKey{}
+		key.From(ep.Addr)
+		tp.connPool.Drain(key, []*Endpoint{ep})
+		tp.dropBreakers(key)
+		log.Infof("target %v removed %v\n", target.Name, ep.Addr.GetAddr())
+	}
+}
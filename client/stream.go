@@ -0,0 +1,112 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"github.com/brodyxchen/vsock-sdk/constant"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/stream"
+	"io"
+	"time"
+)
+
+// ClientStream client 多次发送, server 回复一次
+type ClientStream struct {
+	*stream.Stream
+}
+
+// CloseAndRecv 半关闭并等待 server 的回复
+func (cs *ClientStream) CloseAndRecv() ([]byte, error) {
+	if err := cs.CloseSend(); err != nil {
+		return nil, err
+	}
+	rsp, err := cs.Recv()
+	if err != nil {
+		if err == io.EOF {
+			return nil, errors.ErrStreamClosed
+		}
+		return nil, err
+	}
+	return rsp, nil
+}
+
+// NewClientStream 打开 client-streaming 调用, 流的生命周期只由 ctx 控制, 不受 Client.Timeout 影响
+func (cli *Client) NewClientStream(ctx context.Context, addr models.Addr, path string) (*ClientStream, error) {
+	s, err := cli.transport.newStream(ctx, addr, path)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientStream{Stream: s}, nil
+}
+
+// NewBidiStream 打开双向流调用, 流的生命周期只由 ctx 控制, 不受 Client.Timeout 影响
+func (cli *Client) NewBidiStream(ctx context.Context, addr models.Addr, path string) (*stream.Stream, error) {
+	return cli.transport.newStream(ctx, addr, path)
+}
+
+// newStream 流独占一条新连接, 不进入连接池, 流结束时关闭; 只有建立流的过程计入熔断
+func (tp *Transport) newStream(ctx context.Context, addr models.Addr, path string) (*stream.Stream, error) {
+	if target, ok := addr.(*Target); ok {
+		ep, err := tp.pick(ctx, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer ep.release()
+		addr = ep.Addr
+	}
+
+	key := connectKey{}
+	key.From(addr)
+	key.Identity = tp.tlsIdentity(tp.tlsConfig(ctx))
+	br := tp.breaker(key)
+	if br == nil {
+		return tp.openStream(ctx, addr, path)
+	}
+
+	generation, err := br.allow(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	s, err := tp.openStream(ctx, addr, path)
+	br.done(generation, breakerOutcome(ctx, err), time.Now())
+	return s, err
+}
+
+func (tp *Transport) openStream(ctx context.Context, addr models.Addr, path string) (*stream.Stream, error) {
+	rwConn, err := tp.dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReaderSize(rwConn, tp.readBufferSize())
+	writer := bufio.NewWriterSize(rwConn, tp.writeBufferSize())
+
+	protocol, err := tp.handshake(ctx, rwConn, reader, writer)
+	if err != nil {
+		_ = rwConn.Close()
+		return nil, err
+	}
+	// 旧版本 server 不握手, 无法得知是否支持流, 直接尝试
+	if protocol.Version > constant.DefaultVersion && !protocol.Has(constant.FeatureStreaming) {
+		_ = rwConn.Close()
+		return nil, errors.ErrFeatureNotSupported
+	}
+
+	auth, err := tp.credential(ctx, path, nil)
+	if err != nil {
+		_ = rwConn.Close()
+		return nil, err
+	}
+	opts := stream.Options{
+		Window:   tp.StreamWindowSize,
+		Protocol: protocol,
+		Auth:     auth,
+	}
+	s, err := stream.NewClient(ctx, rwConn, reader, writer, path, opts)
+	if err != nil {
+		_ = rwConn.Close()
+		return nil, err
+	}
+	return s, nil
+}
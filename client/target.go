@@ -0,0 +1,182 @@
+package client
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Endpoint Target 中的一个地址, 连接仍然按地址进入 ConnPool
+type Endpoint struct {
+	Addr models.Addr
+
+	outstanding int64 // atomic, 正在进行的调用数
+	unhealthy   int32 // atomic, 非 0 时不参与选择
+	status      int32 // atomic, models.HealthStatus, 由 WatchHealth 更新
+
+	hash uint64 // 地址的哈希, 用于 ConsistentHash
+}
+
+// Outstanding 正在进行的调用数, 流只计入建立的过程
+func (ep *Endpoint) Outstanding() int64 {
+	return atomic.LoadInt64(&ep.outstanding)
+}
+
+func (ep *Endpoint) Healthy() bool {
+	return atomic.LoadInt32(&ep.unhealthy) == 0
+}
+
+// SetHealthy 标记地址是否可用, 新建的 Endpoint 默认可用
+func (ep *Endpoint) SetHealthy(healthy bool) {
+	var v int32
+	if !healthy {
+		v = 1
+	}
+	atomic.StoreInt32(&ep.unhealthy, v)
+}
+
+// Target 逻辑上的目标, 由一组地址组成, 实现 models.Addr, 可以代替单个地址传给 Client 的所有调用.
+// 每次调用(或建立流)时由 Balancer 从可用的地址中选择一个; 被 SetHealthy(false) 标记,
+// 或熔断器处于打开状态的地址不参与选择
+type Target struct {
+	Name     string
+	Balancer Balancer // 默认 RoundRobin
+
+	mutex     sync.RWMutex
+	endpoints []*Endpoint
+}
+
+// NewTarget balancer 为 nil 时使用 RoundRobin
+func NewTarget(name string, balancer Balancer, addrs ...models.Addr) *Target {
+	if balancer == nil {
+		balancer = &RoundRobin{}
+	}
+	t := &Target{Name: name, Balancer: balancer}
+	t.SetEndpoints(addrs)
+	return t
+}
+
+func (t *Target) GetAddr() string {
+	return t.Name
+}
+
+// SetEndpoints 替换地址列表, 已有地址的 Endpoint 保留(包括健康状态), 重复的地址只保留一个
+func (t *Target) SetEndpoints(addrs []models.Addr) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	old := make(map[string]*Endpoint, len(t.endpoints))
+	for _, ep := range t.endpoints {
+		old[ep.Addr.GetAddr()] = ep
+	}
+	endpoints := make([]*Endpoint, 0, len(addrs))
+	seen := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		key := addr.GetAddr()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		ep, ok := old[key]
+		if !ok {
+			ep = &Endpoint{Addr: addr, hash: hashString(key)}
+		}
+		endpoints = append(endpoints, ep)
+	}
+	t.endpoints = endpoints
+}
+
+// Endpoints 当前的地址列表, 返回的切片不会被修改
+func (t *Target) Endpoints() []*Endpoint {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.endpoints
+}
+
+// Endpoint addr 对应的 Endpoint, 不存在时返回 nil
+func (t *Target) Endpoint(addr models.Addr) *Endpoint {
+	key := addr.GetAddr()
+	for _, ep := range t.Endpoints() {
+		if ep.Addr.GetAddr() == key {
+			return ep
+		}
+	}
+	return nil
+}
+
+func (t *Target) balancer() Balancer {
+	if t.Balancer != nil {
+		return t.Balancer
+	}
+	return defaultBalancer
+}
+
+var defaultBalancer = &RoundRobin{}
+
+// pick 选择一个可用的地址并计入 outstanding, 调用方结束后调用 release.
+// 还有其它可用的地址时不选择 exclude 中的地址
+func (tp *Transport) pick(ctx context.Context, target *Target, exclude []*Endpoint) (*Endpoint, error) {
+	endpoints := target.Endpoints()
+	if len(endpoints) == 0 {
+		tp.noEndpointCounter.Inc(1)
+		return nil, errors.ErrNoEndpoints
+	}
+
+	identity := tp.tlsIdentity(tp.tlsConfig(ctx))
+	now := time.Now()
+	healthy := make([]*Endpoint, 0, len(endpoints))
+	for _, ep := range endpoints {
+		if ep.Healthy() && tp.available(ep.Addr, identity, now) {
+			healthy = append(healthy, ep)
+		}
+	}
+	if len(healthy) == 0 {
+		tp.noEndpointCounter.Inc(1)
+		return nil, errors.ErrNoHealthyEndpoints
+	}
+	if len(exclude) > 0 {
+		others := make([]*Endpoint, 0, len(healthy))
+		for _, ep := range healthy {
+			if !containsEndpoint(exclude, ep) {
+				others = append(others, ep)
+			}
+		}
+		if len(others) > 0 {
+			healthy = others
+		}
+	}
+
+	ep := target.balancer().Pick(ctx, healthy)
+	atomic.AddInt64(&ep.outstanding, 1)
+	return ep, nil
+}
+
+func containsEndpoint(endpoints []*Endpoint, ep *Endpoint) bool {
+	for _, e := range endpoints {
+		if e == ep {
+			return true
+		}
+	}
+	return false
+}
+
+func (ep *Endpoint) release() {
+	atomic.AddInt64(&ep.outstanding, -1)
+}
+
+// available 熔断器打开的地址不可用, 冷却结束(即将半开)的可用
+func (tp *Transport) available(addr models.Addr, identity string, now time.Time) bool {
+	if tp.Breaker == nil {
+		return true
+	}
+	key := connectKey{}
+	key.From(addr)
+	key.Identity = identity
+	br := tp.breaker(key)
+	br.mutex.Lock()
+	defer br.mutex.Unlock()
+	return br.state != BreakerOpen || now.Sub(br.openedAt) >= br.cfg.coolDown()
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"github.com/brodyxchen/vsock-sdk/constant"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"net"
+)
+
+type tlsContextKey struct{}
+
+// WithTLSConfig 该 ctx 发起的调用(DoContext, Go)使用 cfg 代替 Config.TLSConfig, 用于同一个 Client 以不同身份(客户端证书)访问.
+// 连接池按身份区分, 不同身份的调用不会共用连接
+func WithTLSConfig(ctx context.Context, cfg *tls.Config) context.Context {
+	return context.WithValue(ctx, tlsContextKey{}, cfg)
+}
+
+func (tp *Transport) tlsConfig(ctx context.Context) *tls.Config {
+	if cfg, ok := ctx.Value(tlsContextKey{}).(*tls.Config); ok {
+		return cfg
+	}
+	return tp.TLSConfig
+}
+
+// tlsIdentityEntry 缓存的身份和计算时的输入, 输入变化(如原地替换证书)后重新计算
+type tlsIdentityEntry struct {
+	leaves     [][]byte
+	serverName string
+	insecure   bool
+	rootCAs    *x509.CertPool
+	identity   string
+}
+
+func (e *tlsIdentityEntry) matches(cfg *tls.Config) bool {
+	if e.serverName != cfg.ServerName || e.insecure != cfg.InsecureSkipVerify || e.rootCAs != cfg.RootCAs {
+		return false
+	}
+	leaves := tlsLeaves(cfg)
+	if len(leaves) != len(e.leaves) {
+		return false
+	}
+	for i, leaf := range leaves {
+		if len(leaf) != len(e.leaves[i]) || (len(leaf) > 0 && &leaf[0] != &e.leaves[i][0]) {
+			return false
+		}
+	}
+	return true
+}
+
+func tlsLeaves(cfg *tls.Config) [][]byte {
+	leaves := make([][]byte, 0, len(cfg.Certificates))
+	for _, cert := range cfg.Certificates {
+		if len(cert.Certificate) > 0 {
+			leaves = append(leaves, cert.Certificate[0])
+		}
+	}
+	return leaves
+}
+
+// tlsIdentity 连接池和熔断器中区分身份的标识: 客户端叶子证书, ServerName 和证书校验设置
+// (InsecureSkipVerify, RootCAs) 的摘要, 校验要求不同的调用不会共用连接.
+// 证书或校验由回调(GetClientCertificate, VerifyPeerCertificate, VerifyConnection)决定时, 每个 tls.Config 视为不同的身份.
+// 结果按 *tls.Config 缓存, 最多 constant.TLSIdentityCacheSize 个, 满了之后清空
+func (tp *Transport) tlsIdentity(cfg *tls.Config) string {
+	if cfg == nil {
+		return ""
+	}
+	tp.tlsMutex.Lock()
+	defer tp.tlsMutex.Unlock()
+	if e, ok := tp.tlsIdentities[cfg]; ok && e.matches(cfg) {
+		return e.identity
+	}
+
+	e := &tlsIdentityEntry{
+		leaves:     tlsLeaves(cfg),
+		serverName: cfg.ServerName,
+		insecure:   cfg.InsecureSkipVerify,
+		rootCAs:    cfg.RootCAs,
+	}
+	h := sha256.New()
+	for _, leaf := range e.leaves {
+		h.Write(leaf)
+	}
+	h.Write([]byte(cfg.ServerName))
+	_, _ = fmt.Fprintf(h, "|%v|%p", cfg.InsecureSkipVerify, cfg.RootCAs)
+	if cfg.GetClientCertificate != nil || cfg.VerifyPeerCertificate != nil || cfg.VerifyConnection != nil {
+		_, _ = fmt.Fprintf(h, "|%p", cfg)
+	}
+	e.identity = "tls:" + hex.EncodeToString(h.Sum(nil)[:16])
+
+	if tp.tlsIdentities == nil || len(tp.tlsIdentities) >= constant.TLSIdentityCacheSize {
+		tp.tlsIdentities = make(map[*tls.Config]*tlsIdentityEntry)
+	}
+	tp.tlsIdentities[cfg] = e
+	return e.identity
+}
+
+// tlsClient 没有设置 ServerName 时使用 tcp 地址的 IP 校验证书; vsock 地址没有主机名, 需要显式设置
+func tlsClient(conn net.Conn, cfg *tls.Config, addr models.Addr) *tls.Conn {
+	if cfg.ServerName == "" {
+		if ad, ok := addr.(*models.HttpAddr); ok {
+			cfg = cfg.Clone()
+			cfg.ServerName = ad.IP
+		}
+	}
+	return tls.Client(conn, cfg)
+}
@@ -2,10 +2,16 @@ package client
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
+	"github.com/brodyxchen/vsock-sdk/buffer"
+	"github.com/brodyxchen/vsock-sdk/compress"
 	"github.com/brodyxchen/vsock-sdk/constant"
 	"github.com/brodyxchen/vsock-sdk/errors"
 	"github.com/brodyxchen/vsock-sdk/log"
 	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/secure"
+	"github.com/brodyxchen/vsock-sdk/socket"
 	"github.com/brodyxchen/vsock-sdk/statistics/metrics"
 	"github.com/mdlayher/vsock"
 	"net"
@@ -22,8 +28,31 @@ type Transport struct {
 	Name     string
 	connPool ConnPool
 
-	WriteBufferSize int
-	ReadBufferSize  int
+	WriteBufferSize  int
+	ReadBufferSize   int
+	StreamWindowSize int
+
+	WriteCoalesceDelay time.Duration
+
+	DisableHandshake bool
+	MinVersion       uint16
+	MaxFrameSize     int
+
+	Compressors       []uint8
+	CompressThreshold int
+	EnableChecksum    bool
+
+	Secure    *secure.Config
+	TLSConfig *tls.Config
+
+	tlsIdentities map[*tls.Config]*tlsIdentityEntry
+	tlsMutex      sync.Mutex
+
+	Credentials Credentials
+
+	Breaker       *BreakerConfig // 非空时每个目标一个熔断器
+	breakers      map[connectKey]*breaker
+	breakersMutex sync.Mutex
 
 	connIndex int64 // atomic visit
 
@@ -35,6 +64,28 @@ type Transport struct {
 	sendDoneHist       metrics.Histogram
 	receiveHist        metrics.Histogram
 	receiveTimeoutHist metrics.Histogram
+
+	notifyCounter     metrics.Counter
+	notifyFailCounter metrics.Counter
+
+	breakerOpenCounter   metrics.Counter // 熔断器打开的次数
+	breakerRejectCounter metrics.Counter // 被熔断器拒绝的调用
+	noEndpointCounter    metrics.Counter // Target 没有可用地址的调用
+
+	Hedge   *HedgeConfig // 非空时 WithHedging 标记的调用可以对冲
+	hedging hedgeState
+
+	hedgeCounter          metrics.Counter // 发出的对冲请求
+	hedgeWinCounter       metrics.Counter // 对冲请求先于原请求成功的调用
+	hedgeThrottledCounter metrics.Counter // 预算不足没有发出的对冲
+
+	healthCheckCounter metrics.Counter // 健康检查次数
+	healthFailCounter  metrics.Counter // 失败或不是 HealthServing 的检查
+	unhealthyCounter   metrics.Counter // 当前被 WatchHealth 标记为不可用的地址数
+
+	compressRatioHist  metrics.Histogram // 压缩后/压缩前, 百分比
+	compressCostHist   metrics.Histogram // 微秒
+	decompressCostHist metrics.Histogram // 微秒
 }
 
 func (tp *Transport) getConnIndex() int64 {
@@ -46,29 +97,14 @@ func (tp *Transport) DialTest(addr models.Addr) (*PersistConn, error) {
 
 	key := connectKey{}
 	key.From(addr)
+	key.Identity = tp.tlsIdentity(tp.TLSConfig)
 
 	// 创建
-	var (
-		rwConn net.Conn
-		err    error
-	)
-
 	now := time.Now()
-	switch ad := addr.(type) {
-	case *models.VSockAddr:
-		rwConn, err = vsock.Dial(ad.ContextId, ad.Port, nil)
-		if err != nil {
-			return nil, err
-		}
-	case *models.HttpAddr:
-		rwConn, err = net.Dial("tcp", ad.GetAddr())
-		if err != nil {
-			return nil, err
-		}
-	default:
-		panic("invalid models addr")
+	rwConn, err := tp.dial(context.Background(), addr)
+	if err != nil {
+		return nil, err
 	}
-	tp.connNewHist.Update(time.Since(now).Milliseconds())
 
 	pConn := &PersistConn{
 		Name:        tp.getConnIndex(),
@@ -86,6 +122,14 @@ func (tp *Transport) DialTest(addr models.Addr) (*PersistConn, error) {
 	}
 	pConn.bufReader = bufio.NewReaderSize(pConn, tp.readBufferSize())
 	pConn.bufWriter = bufio.NewWriterSize(pConn, tp.writeBufferSize())
+	pConn.coalescer = socket.NewCoalescer(pConn.bufWriter, tp.WriteCoalesceDelay)
+
+	pConn.protocol, err = tp.handshake(context.Background(), rwConn, pConn.bufReader, pConn.bufWriter)
+	if err != nil {
+		_ = rwConn.Close()
+		return nil, err
+	}
+	tp.connNewHist.Update(time.Since(now).Milliseconds())
 
 	go pConn.readLoop()
 	go pConn.writeLoop()
@@ -95,11 +139,12 @@ func (tp *Transport) DialTest(addr models.Addr) (*PersistConn, error) {
 	return pConn, nil
 }
 
-func (tp *Transport) getConn(addr models.Addr, retryCount int) (*PersistConn, error) {
+func (tp *Transport) getConn(ctx context.Context, addr models.Addr, retryCount int) (*PersistConn, error) {
 	now := time.Now()
 
 	key := connectKey{}
 	key.From(addr)
+	key.Identity = tp.tlsIdentity(tp.tlsConfig(ctx))
 
 	if retryCount <= 0 {
 		// 查找缓存
@@ -111,23 +156,9 @@ func (tp *Transport) getConn(addr models.Addr, retryCount int) (*PersistConn, er
 	}
 
 	// 创建
-	var (
-		rwConn net.Conn
-		err    error
-	)
-	switch ad := addr.(type) {
-	case *models.VSockAddr:
-		rwConn, err = vsock.Dial(ad.ContextId, ad.Port, nil)
-		if err != nil {
-			return nil, err
-		}
-	case *models.HttpAddr:
-		rwConn, err = net.Dial("tcp", ad.GetAddr())
-		if err != nil {
-			return nil, err
-		}
-	default:
-		panic("invalid models addr")
+	rwConn, err := tp.dial(ctx, addr)
+	if err != nil {
+		return nil, err
 	}
 
 	pConn := &PersistConn{
@@ -146,6 +177,13 @@ func (tp *Transport) getConn(addr models.Addr, retryCount int) (*PersistConn, er
 	}
 	pConn.bufReader = bufio.NewReaderSize(pConn, tp.readBufferSize())
 	pConn.bufWriter = bufio.NewWriterSize(pConn, tp.writeBufferSize())
+	pConn.coalescer = socket.NewCoalescer(pConn.bufWriter, tp.WriteCoalesceDelay)
+
+	pConn.protocol, err = tp.handshake(ctx, rwConn, pConn.bufReader, pConn.bufWriter)
+	if err != nil {
+		_ = rwConn.Close()
+		return nil, err
+	}
 
 	go pConn.readLoop()
 	go pConn.writeLoop()
@@ -156,6 +194,50 @@ func (tp *Transport) getConn(addr models.Addr, retryCount int) (*PersistConn, er
 	return pConn, nil
 }
 
+func (tp *Transport) dial(ctx context.Context, addr models.Addr) (net.Conn, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+	switch ad := addr.(type) {
+	case *models.VSockAddr:
+		conn, err = vsock.Dial(ad.ContextId, ad.Port, nil)
+	case *models.HttpAddr:
+		conn, err = net.Dial("tcp", ad.GetAddr())
+	default:
+		// *Target 需要先选择地址, 只有调用和流的路径会选择
+		return nil, errors.ErrUnsupportedAddr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg := tp.tlsConfig(ctx); cfg != nil {
+		hctx, cancel := context.WithDeadline(ctx, handshakeDeadline(ctx))
+		tlsConn := tlsClient(conn, cfg, addr)
+		err = tlsConn.HandshakeContext(hctx)
+		cancel()
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	if tp.Secure == nil {
+		return conn, nil
+	}
+
+	// 安全通道在版本握手之前建立, 之后的所有数据都被加密
+	_ = conn.SetDeadline(handshakeDeadline(ctx))
+	sc, err := secure.Client(conn, tp.Secure)
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(errors.ErrSecureHandshake, err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return sc, nil
+}
+
 func (tp *Transport) writeBufferSize() int {
 	if tp.WriteBufferSize > 0 {
 		return tp.WriteBufferSize
@@ -174,7 +256,48 @@ func (tp *Transport) putConn(pConn *PersistConn) {
 	tp.connPool.Put(pConn)
 }
 
+// roundTrip 熔断器打开时直接返回 *errors.BreakerOpenError; req.Addr 为 *Target 时先选择地址.
+// 开启了对冲且调用可以对冲时见 hedgedRoundTrip
 func (tp *Transport) roundTrip(req *models.Request) (*models.Response, error) {
+	if tp.hedgeable(req) {
+		return tp.hedgedRoundTrip(req)
+	}
+	return tp.singleRoundTrip(req)
+}
+
+func (tp *Transport) singleRoundTrip(req *models.Request) (*models.Response, error) {
+	if target, ok := req.Addr.(*Target); ok {
+		ep, err := tp.pick(req.Context(), target, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer ep.release()
+		req.Addr = ep.Addr
+	}
+	return tp.breakerRoundTrip(req)
+}
+
+// breakerRoundTrip req.Addr 为具体的地址
+func (tp *Transport) breakerRoundTrip(req *models.Request) (*models.Response, error) {
+	ctx := req.Context()
+	key := connectKey{}
+	key.From(req.Addr)
+	key.Identity = tp.tlsIdentity(tp.tlsConfig(ctx))
+	br := tp.breaker(key)
+	if br == nil {
+		return tp.tryRoundTrip(req)
+	}
+
+	generation, err := br.allow(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := tp.tryRoundTrip(req)
+	br.done(generation, breakerOutcome(ctx, err), time.Now())
+	return rsp, err
+}
+
+func (tp *Transport) tryRoundTrip(req *models.Request) (*models.Response, error) {
 	var (
 		ctx        = req.Context()
 		retryCount = 0
@@ -191,7 +314,11 @@ func (tp *Transport) roundTrip(req *models.Request) (*models.Response, error) {
 	}
 
 	defer func() {
-		if err == nil && conn != nil && !conn.isClosed() {
+		// 重试前已经关闭, 或还没有取得连接时 ctx 结束(如对冲取消了其余的发送)
+		if conn == nil {
+			return
+		}
+		if err == nil && !conn.isClosed() {
 			tp.putConn(conn)
 			return
 		}
@@ -214,7 +341,7 @@ func (tp *Transport) roundTrip(req *models.Request) (*models.Response, error) {
 		default:
 		}
 
-		conn, err = tp.getConn(req.Addr, retryCount)
+		conn, err = tp.getConn(ctx, req.Addr, retryCount)
 
 		if err != nil {
 			return nil, err
@@ -224,16 +351,47 @@ func (tp *Transport) roundTrip(req *models.Request) (*models.Response, error) {
 			Ctx: ctx,
 			Header: models.Header{
 				Magic:   constant.DefaultMagic,
-				Version: constant.DefaultVersion,
-				Code:    0, // 一些特殊设置: 比如keepAlive
-				Length:  uint16(len(req.Body)),
+				Version: conn.protocol.Version,
+				Code:    req.Code, // action
+				Flags:   conn.protocol.FrameFlags(),
+				Length:  uint32(len(req.Body)),
 			},
-			Body: req.Body,
+			Body:  req.Body,
+			Frame: req.Frame,
+		}
+		if compressed := tp.compress(conn, req); compressed != nil {
+			sReq.Flags |= constant.FlagCompressed
+			sReq.Body = socket.FrameBody(compressed)
+			sReq.Frame = compressed
+		}
+
+		// 没有协商 notify 的对端(不握手或旧版本 server)会回复, 连接上的回复会错位
+		if req.Code == constant.ActionNotify && !conn.protocol.Has(constant.FeatureNotify) {
+			if sReq.Frame != req.Frame {
+				sReq.Frame.Release()
+			}
+			return nil, errors.ErrNotifyUnsupported
+		}
+
+		// 超过对端上限的帧不发送, 连接仍然可用
+		if len(sReq.Body) > conn.protocol.MaxFrameSize {
+			if sReq.Frame != req.Frame {
+				sReq.Frame.Release()
+			}
+			return nil, errors.ErrExceedBody
 		}
 
-		tripNow := time.Now()
-		sRsp, err = conn.roundTrip(sReq)
-		tp.tripHist.Update(time.Since(tripNow).Milliseconds())
+		// notify 不等待回复, 不计入 tp.trip (对冲的延迟由它计算)
+		if req.Code == constant.ActionNotify {
+			err = conn.notify(sReq)
+		} else {
+			tripNow := time.Now()
+			sRsp, err = conn.roundTrip(sReq)
+			tp.tripHist.Update(time.Since(tripNow).Milliseconds())
+		}
+		if sReq.Frame != req.Frame {
+			sReq.Frame.Release()
+		}
 
 		if err == nil {
 			return sRsp, nil
@@ -251,6 +409,31 @@ func (tp *Transport) roundTrip(req *models.Request) (*models.Response, error) {
 	}
 }
 
+// compress 连接协商了压缩且 body 达到阈值时返回压缩后的帧, 由调用方释放
+func (tp *Transport) compress(conn *PersistConn, req *models.Request) *buffer.Buffer {
+	if conn.protocol.Compressor == 0 || len(req.Body) < tp.CompressThreshold {
+		return nil
+	}
+	frame := req.Frame
+	if frame == nil {
+		frame = buffer.Wrap(append(make([]byte, models.MaxHeaderSize, models.MaxHeaderSize+len(req.Body)), req.Body...))
+	}
+
+	now := time.Now()
+	compressed, err := socket.CompressFrame(compress.Get(conn.protocol.Compressor), frame)
+	tp.compressCostHist.Update(time.Since(now).Microseconds())
+	if err != nil {
+		log.Errorf("compress request: %v\n", err)
+		return nil
+	}
+	if compressed == nil {
+		tp.compressRatioHist.Update(100)
+		return nil
+	}
+	tp.compressRatioHist.Update(int64(len(socket.FrameBody(compressed)) * 100 / len(socket.FrameBody(frame))))
+	return compressed
+}
+
 func (tp *Transport) removeConn(target *PersistConn) bool {
 	return tp.connPool.Remove(target)
 }
@@ -0,0 +1,113 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"sync"
+)
+
+// DefaultLevel 内置算法默认的压缩级别, 偏向速度
+const DefaultLevel = flate.BestSpeed
+
+type gzipCompressor struct {
+	level   int
+	writers sync.Pool
+	readers sync.Pool
+}
+
+// NewGzip level 同 compress/gzip
+func NewGzip(level int) Compressor {
+	return &gzipCompressor{level: level}
+}
+
+func (c *gzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (c *gzipCompressor) Compress(dst, src []byte) ([]byte, error) {
+	out := &appendWriter{b: dst}
+	w, _ := c.writers.Get().(*gzip.Writer)
+	if w == nil {
+		var err error
+		if w, err = gzip.NewWriterLevel(out, c.level); err != nil {
+			return dst, err
+		}
+	} else {
+		w.Reset(out)
+	}
+	defer c.writers.Put(w)
+
+	if _, err := w.Write(src); err != nil {
+		return dst, err
+	}
+	if err := w.Close(); err != nil {
+		return dst, err
+	}
+	return out.b, nil
+}
+
+func (c *gzipCompressor) Decompress(dst, src []byte, limit int) ([]byte, error) {
+	r, _ := c.readers.Get().(*gzip.Reader)
+	if r == nil {
+		var err error
+		if r, err = gzip.NewReader(bytes.NewReader(src)); err != nil {
+			return dst, err
+		}
+	} else if err := r.Reset(bytes.NewReader(src)); err != nil {
+		return dst, err
+	}
+	defer c.readers.Put(r)
+
+	return readAppend(dst, r, limit)
+}
+
+type deflateCompressor struct {
+	level   int
+	writers sync.Pool
+	readers sync.Pool
+}
+
+// NewDeflate level 同 compress/flate
+func NewDeflate(level int) Compressor {
+	return &deflateCompressor{level: level}
+}
+
+func (c *deflateCompressor) Name() string {
+	return "deflate"
+}
+
+func (c *deflateCompressor) Compress(dst, src []byte) ([]byte, error) {
+	out := &appendWriter{b: dst}
+	w, _ := c.writers.Get().(*flate.Writer)
+	if w == nil {
+		var err error
+		if w, err = flate.NewWriter(out, c.level); err != nil {
+			return dst, err
+		}
+	} else {
+		w.Reset(out)
+	}
+	defer c.writers.Put(w)
+
+	if _, err := w.Write(src); err != nil {
+		return dst, err
+	}
+	if err := w.Close(); err != nil {
+		return dst, err
+	}
+	return out.b, nil
+}
+
+func (c *deflateCompressor) Decompress(dst, src []byte, limit int) ([]byte, error) {
+	r, _ := c.readers.Get().(flate.Resetter)
+	if r == nil {
+		r = flate.NewReader(bytes.NewReader(src)).(flate.Resetter)
+	} else if err := r.Reset(bytes.NewReader(src), nil); err != nil {
+		return dst, err
+	}
+	defer c.readers.Put(r)
+
+	return readAppend(dst, r.(io.Reader), limit)
+}
@@ -0,0 +1,77 @@
+package compress
+
+import (
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"io"
+	"sync"
+)
+
+// 内置算法的编号, 握手时交换, 0 代表不压缩
+const (
+	Gzip    = uint8(1)
+	Deflate = uint8(2)
+)
+
+// Compressor 压缩算法, 需要并发安全
+type Compressor interface {
+	Name() string
+	// Compress 把 src 压缩后 append 到 dst
+	Compress(dst, src []byte) ([]byte, error)
+	// Decompress 把 src 解压后 append 到 dst, 解压后超过 limit 时返回 ErrDecompressExceed
+	Decompress(dst, src []byte, limit int) ([]byte, error)
+}
+
+var (
+	registry = map[uint8]Compressor{
+		Gzip:    NewGzip(DefaultLevel),
+		Deflate: NewDeflate(DefaultLevel),
+	}
+	mutex sync.RWMutex
+)
+
+// Register 注册或替换算法, 两端需要用同一个编号注册同一种算法
+func Register(id uint8, c Compressor) {
+	if id == 0 {
+		panic("compress: id 0 is reserved")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	registry[id] = c
+}
+
+// Get 未注册时返回 nil
+func Get(id uint8) Compressor {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return registry[id]
+}
+
+type appendWriter struct {
+	b []byte
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	w.b = append(w.b, p...)
+	return len(p), nil
+}
+
+// readAppend 把 r 读完 append 到 dst, 超过 limit 时返回错误
+func readAppend(dst []byte, r io.Reader, limit int) ([]byte, error) {
+	start := len(dst)
+	for {
+		if len(dst) == cap(dst) {
+			dst = append(dst, 0)[:len(dst)]
+		}
+		n, err := r.Read(dst[len(dst):cap(dst)])
+		dst = dst[:len(dst)+n]
+		if len(dst)-start > limit {
+			return dst, errors.ErrDecompressExceed
+		}
+		if err == io.EOF {
+			return dst, nil
+		}
+		if err != nil {
+			return dst, err
+		}
+	}
+}
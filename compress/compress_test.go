@@ -0,0 +1,61 @@
+package compress
+
+import (
+	"bytes"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"testing"
+)
+
+func TestBuiltinRoundTrip(t *testing.T) {
+	src := bytes.Repeat([]byte(`{"level":"info","msg":"hello"}`), 200)
+	for _, id := range []uint8{Gzip, Deflate} {
+		c := Get(id)
+		prefix := []byte("hdr")
+
+		compressed, err := c.Compress(prefix, src)
+		if err != nil {
+			t.Fatal(c.Name(), err)
+		}
+		if !bytes.HasPrefix(compressed, prefix) || len(compressed) >= len(src)/4 {
+			t.Fatalf("%s: compressed to %d bytes", c.Name(), len(compressed))
+		}
+
+		// 复用池中的 writer/reader
+		for i := 0; i < 2; i++ {
+			plain, err := c.Decompress(nil, compressed[len(prefix):], len(src))
+			if err != nil || !bytes.Equal(plain, src) {
+				t.Fatalf("%s: decompress %d %v", c.Name(), len(plain), err)
+			}
+		}
+
+		if _, err = c.Decompress(nil, compressed[len(prefix):], len(src)-1); err != errors.ErrDecompressExceed {
+			t.Fatalf("%s: limit %v", c.Name(), err)
+		}
+		if _, err = c.Decompress(nil, []byte("not compressed"), len(src)); err == nil {
+			t.Fatalf("%s: invalid input accepted", c.Name())
+		}
+	}
+}
+
+type identity struct{}
+
+func (identity) Name() string { return "identity" }
+
+func (identity) Compress(dst, src []byte) ([]byte, error) { return append(dst, src...), nil }
+
+func (identity) Decompress(dst, src []byte, limit int) ([]byte, error) {
+	if len(src) > limit {
+		return dst, errors.ErrDecompressExceed
+	}
+	return append(dst, src...), nil
+}
+
+func TestRegister(t *testing.T) {
+	if Get(100) != nil {
+		t.Fatal("unregistered id")
+	}
+	Register(100, identity{})
+	if c := Get(100); c == nil || c.Name() != "identity" {
+		t.Fatalf("registered: %v", c)
+	}
+}
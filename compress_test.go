@@ -0,0 +1,47 @@
+package vsock_sdk
+
+import (
+	"bytes"
+	"github.com/brodyxchen/vsock-sdk/client"
+	"github.com/brodyxchen/vsock-sdk/compress"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/statistics"
+	"github.com/brodyxchen/vsock-sdk/statistics/metrics"
+	"testing"
+	"time"
+)
+
+func TestClientCompression(t *testing.T) {
+	addr := &models.HttpAddr{IP: "127.0.0.1", Port: 7077}
+	srv := NewServer(addr)
+	srv.HandleFunc("echo", func(req []byte) ([]byte, error) {
+		return req, nil
+	})
+	go func() {
+		_ = srv.ListenAndServe()
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	cli := NewClient(&client.Config{Timeout: time.Second, Compressors: []uint8{compress.Deflate, compress.Gzip}})
+
+	doc := bytes.Repeat([]byte(`{"level":"info","msg":"compressible"}`), 2000)
+	rsp, err := cli.Do(addr, "echo", doc)
+	if err != nil || !bytes.Equal(rsp, doc) {
+		t.Fatalf("large echo: %d %v", len(rsp), err)
+	}
+
+	// 小于阈值的 body 不压缩
+	rsp, err = cli.Do(addr, "echo", []byte("small"))
+	if err != nil || string(rsp) != "small" {
+		t.Fatalf("small echo: %q %v", rsp, err)
+	}
+
+	clientRatio := statistics.ClientReg.Get("tp.compress.ratio").(metrics.Histogram)
+	serverRatio := statistics.ServerReg.Get("srv.compress.ratio").(metrics.Histogram)
+	if clientRatio.Count() != 1 || serverRatio.Count() != 1 {
+		t.Fatalf("compressed frames: client %d server %d", clientRatio.Count(), serverRatio.Count())
+	}
+	if clientRatio.Max() >= 50 {
+		t.Fatalf("ratio %d%%", clientRatio.Max())
+	}
+}
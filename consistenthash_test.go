@@ -0,0 +1,102 @@
+package vsock_sdk
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/client"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/server"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestClientConsistentHash(t *testing.T) {
+	addrs := []models.Addr{
+		&models.HttpAddr{IP: "127.0.0.1", Port: 7093},
+		&models.HttpAddr{IP: "127.0.0.1", Port: 7094},
+		&models.HttpAddr{IP: "127.0.0.1", Port: 7095},
+	}
+	servers := make([]*server.Server, 0, len(addrs))
+	for _, addr := range addrs {
+		name := addr.GetAddr()
+		srv := NewServer(addr)
+		srv.HandleFunc("whoami", func(req []byte) ([]byte, error) {
+			return []byte(name), nil
+		})
+		servers = append(servers, srv)
+	}
+	for _, srv := range servers {
+		go func(srv *server.Server) {
+			_ = srv.ListenAndServe()
+		}(srv)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	cli := NewClient(&client.Config{Timeout: time.Second})
+	target := client.NewTarget("sessions", &client.ConsistentHash{}, addrs...)
+
+	whoami := func(key string) string {
+		rsp, err := cli.DoContext(client.WithRoutingKey(context.Background(), key), target, "whoami", nil)
+		if err != nil {
+			t.Fatalf("%v: %v", key, err)
+		}
+		return string(rsp)
+	}
+
+	// 同一个 key 总是落到同一个地址, 不同的 key 分散到所有地址
+	const keys = 300
+	owners := make(map[string]string, keys)
+	counts := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		key := "session-" + strconv.Itoa(i)
+		owners[key] = whoami(key)
+		counts[owners[key]]++
+	}
+	for _, addr := range addrs {
+		if counts[addr.GetAddr()] < keys/6 {
+			t.Fatalf("skewed: %v", counts)
+		}
+	}
+	for key, owner := range owners {
+		if got := whoami(key); got != owner {
+			t.Fatalf("%v moved %v -> %v", key, owner, got)
+		}
+	}
+
+	// 移除一个地址: 只有落在它上面的 key 改变
+	removed := addrs[1].GetAddr()
+	target.SetEndpoints([]models.Addr{addrs[0], addrs[2]})
+	for key, owner := range owners {
+		got := whoami(key)
+		if owner != removed && got != owner {
+			t.Fatalf("%v moved %v -> %v", key, owner, got)
+		}
+		if got == removed {
+			t.Fatalf("%v still on removed %v", key, removed)
+		}
+	}
+
+	// 加回后恢复原来的分布; 不可用的地址同样只影响它自己的 key
+	target.SetEndpoints(addrs)
+	target.Endpoint(addrs[2]).SetHealthy(false)
+	for key, owner := range owners {
+		got := whoami(key)
+		if owner != addrs[2].GetAddr() && got != owner {
+			t.Fatalf("%v moved %v -> %v", key, owner, got)
+		}
+	}
+
+	// 没有 routing key 时使用 Fallback
+	target.Endpoint(addrs[2]).SetHealthy(true)
+	seen := make(map[string]bool)
+	for i := 0; i < len(addrs); i++ {
+		rsp, err := cli.Do(target, "whoami", nil)
+		if err != nil {
+			t.Fatalf("fallback: %v", err)
+		}
+		seen[string(rsp)] = true
+	}
+	if len(seen) != len(addrs) {
+		t.Fatalf("fallback: %v", seen)
+	}
+}
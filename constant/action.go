@@ -0,0 +1,11 @@
+package constant
+
+// 请求帧 Header.Code 的取值
+const (
+	ActionCall   = uint16(0) // 普通一问一答
+	ActionStream = uint16(2) // 流式调用, 连接被该流独占
+	ActionNotify = uint16(3) // 单向通知, server 不回复
+	ActionBatch  = uint16(4) // 一帧携带多个子请求
+
+	ActionHandshake = uint16(5) // 连接上的第一帧, 协商版本和特性
+)
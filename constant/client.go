@@ -11,4 +11,18 @@ const (
 	MaxConnPoolCapacity = 1024 * 2
 
 	MaxConnPoolIdleTimeout = time.Minute
+
+	StreamWindowSize = 64 << 10
+
+	TLSIdentityCacheSize = 256 // 缓存的 *tls.Config 身份数
+
+	ResolverPollInterval = time.Second // 文件 resolver 检查变化的间隔
+
+	HealthCheckInterval = 5 * time.Second
+	HealthCheckTimeout  = time.Second
+
+	HedgeMinDelay     = time.Millisecond       // 按分位数计算的对冲延迟的下限
+	HedgeMinSamples   = 20                     // tp.trip 的样本数达到后才按分位数对冲
+	HedgeDelayRefresh = 100 * time.Millisecond // 重新计算分位数的间隔
+	HedgeBudgetBurst  = 10                     // 最多积累的对冲名额
 )
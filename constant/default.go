@@ -1,6 +1,41 @@
 package constant
 
+import "time"
+
 const (
 	DefaultMagic   = uint16(0x1617)
-	DefaultVersion = uint16(1)
+	DefaultVersion = uint16(1) // 握手之前以及旧版本对端使用的协议版本
+	MaxVersion     = uint16(2) // 本端支持的最高协议版本
+
+	MaxFrameSize   = 4 << 20   // v2 默认的最大帧(body)
+	MaxFrameSizeV1 = 1<<16 - 1 // v1 帧头的 Length 只有16位
+
+	HandshakeTimeout = 3 * time.Second // ctx 没有 deadline 时握手的超时
+
+	HealthPath = "_health" // 内置健康检查的路由, 请求 body 为服务名, 空为整个 server
+)
+
+// 握手时交换的特性, 取双方的交集
+const (
+	FeatureStreaming    = uint32(1 << 0)
+	FeatureCompression  = uint32(1 << 1)
+	FeatureMultiplexing = uint32(1 << 2) // 保留, 本实现不支持
+	FeatureChecksum     = uint32(1 << 3)
+	FeatureNotify       = uint32(1 << 4) // 旧版本 server 会回复 ActionNotify, 回复错位到下一个调用
+
+	SupportedFeatures = FeatureStreaming | FeatureCompression | FeatureChecksum | FeatureNotify
+	V2Features        = FeatureCompression | FeatureChecksum // 依赖 v2 帧头的 Flags
+)
+
+const (
+	FlagCompressed = uint16(1 << 0) // v2 Header.Flags: body 使用协商的算法压缩
+	FlagChecksum   = uint16(1 << 1) // v2 Header.Flags: body 之后有 CRC32C 校验和
+	FlagRetryAfter = uint16(1 << 2) // v2 Header.Flags: 状态帧的 body 之前有 4 字节的建议重试间隔(毫秒)
+
+	CompressThreshold = 1 << 10 // 小于该大小的 body 不压缩
+)
+
+const (
+	AuthMaxSkew   = 30 * time.Second // hmac 认证的时间戳与本机时间的最大偏差, 也是 nonce 的记录时长
+	AuthMaxNonces = 1 << 16          // 最多记录的 nonce 数, 超出时拒绝新的请求直到旧的过期
 )
@@ -0,0 +1,11 @@
+package constant
+
+import "time"
+
+const (
+	MaxTrackedPeers = 1024 // 按对端统计时最多记录的对端数, 超出的计入 OtherPeers
+
+	OtherPeers = "other"
+
+	QueueTimeout = 100 * time.Millisecond // 请求等待执行的最长时间, 超过后被丢弃
+)
@@ -0,0 +1,7 @@
+package errors
+
+import "errors"
+
+var (
+	ErrAuditTornTail = errors.New("audit last line incomplete")
+)
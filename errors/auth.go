@@ -0,0 +1,18 @@
+package errors
+
+import "errors"
+
+var (
+	ErrCredential         = errors.New("create request credential failed")
+	ErrInvalidCredential  = errors.New("invalid credential")
+	ErrUnknownCredential  = errors.New("unknown credential key or token")
+	ErrCredentialExpired  = errors.New("credential timestamp out of range")
+	ErrCredentialReplayed = errors.New("credential nonce replayed")
+	ErrTooManyNonces      = errors.New("too many outstanding credential nonces")
+)
+
+var (
+	// StatusUnauthenticated 401 已被 invalid request 占用
+	StatusUnauthenticated  *Status = &Status{code: 407, message: "unauthenticated"}
+	StatusPermissionDenied *Status = &Status{code: 403, message: "permission denied"}
+)
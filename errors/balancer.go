@@ -0,0 +1,11 @@
+package errors
+
+import "errors"
+
+var (
+	ErrNoEndpoints        = errors.New("target has no endpoints")
+	ErrNoHealthyEndpoints = errors.New("target has no healthy endpoints")
+
+	ErrConnDrained     = errors.New("conn drained, endpoint removed")
+	ErrResolverStopped = errors.New("resolver stopped before the first update")
+)
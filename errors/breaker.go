@@ -0,0 +1,22 @@
+package errors
+
+import (
+	"fmt"
+	"time"
+)
+
+// BreakerOpenError 目标的熔断器处于打开(或半开且探测名额已满)状态, 调用没有发出
+type BreakerOpenError struct {
+	Addr       string
+	RetryAfter time.Duration // 距离进入半开状态的时间, 半开时为 0
+}
+
+func (e *BreakerOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker open for %v, retry after %v", e.Addr, e.RetryAfter)
+}
+
+// IsBreakerOpen err 是否为熔断器拒绝的调用
+func IsBreakerOpen(err error) bool {
+	_, ok := err.(*BreakerOpenError)
+	return ok
+}
@@ -39,4 +39,12 @@ var (
 	ErrPeekWritingErr = errors.New("peek waiting data err")
 
 	ErrTransportTripClose = errors.New("transport round trip close")
+	ErrUnsupportedAddr    = errors.New("unsupported address type")
+
+	ErrInvalidBatchResponse = errors.New("invalid batch response")
+
+	ErrIncompatibleVersion = errors.New("no protocol version supported by both peers")
+	ErrInvalidHandshake    = errors.New("invalid handshake")
+	ErrFeatureNotSupported = errors.New("feature not supported by peer")
+	ErrNotifyUnsupported   = errors.New("peer did not negotiate one-way notify")
 )
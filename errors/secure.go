@@ -0,0 +1,18 @@
+package errors
+
+import "errors"
+
+var (
+	ErrSecureHandshake = errors.New("secure channel handshake failed")
+	ErrPeerNotTrusted  = errors.New("peer identity key not trusted")
+	ErrRecordAuth      = errors.New("secure record authentication failed")
+	ErrRecordTooLarge  = errors.New("secure record too large")
+)
+
+var (
+	ErrAttestation         = errors.New("attestation verification failed")
+	ErrAttestationMissing  = errors.New("peer sent no attestation document")
+	ErrAttestationNotBound = errors.New("attestation document not bound to this channel")
+	ErrMeasurementMismatch = errors.New("attestation measurements do not match policy")
+	ErrAttestationPolicy   = errors.New("attestation verifier configured without PCRs or VerifyAttestation")
+)
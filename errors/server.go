@@ -1,10 +1,14 @@
 package errors
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 type Status struct {
-	code    uint16
-	message string
+	code       uint16
+	message    string
+	retryAfter time.Duration
 }
 
 func NewStatus(code uint16, msg string) *Status {
@@ -22,16 +26,88 @@ func (st *Status) Code() uint16 {
 	return st.code
 }
 
+// RetryAfter 对端建议的重试间隔, 没有时为 0
+func (st *Status) RetryAfter() time.Duration {
+	return st.retryAfter
+}
+
+// RetryAfterMillis 传给对端的毫秒数, 不足1ms的按1ms
+func (st *Status) RetryAfterMillis() int64 {
+	if st.retryAfter <= 0 {
+		return 0
+	}
+	return int64((st.retryAfter + time.Millisecond - 1) / time.Millisecond)
+}
+
+// WithRetryAfter 带有重试间隔的副本, 间隔与状态一起传给对端, 不依赖 message 的内容
+func (st *Status) WithRetryAfter(retryAfter time.Duration) *Status {
+	return &Status{code: st.code, message: st.message, retryAfter: retryAfter}
+}
+
 var (
 	ErrExceedBody         = errors.New("exceed body size")
 	ErrInvalidHeader      = errors.New("invalid header")
 	ErrInvalidHeaderMagic = errors.New("invalid header magic number")
 	ErrInvalidBody        = errors.New("invalid body")
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+	ErrUnsupportedFlags   = errors.New("header flags not supported by protocol version")
+	ErrVersionMismatch    = errors.New("frame version differs from negotiated version")
+	ErrUnknownCompressor  = errors.New("compressed frame without negotiated compressor")
+	ErrDecompressExceed   = errors.New("decompressed body exceeds max frame size")
+	ErrChecksumMismatch   = errors.New("frame checksum mismatch")
+	ErrChecksumMissing    = errors.New("frame without negotiated checksum")
 
 	ErrNoKeepAlive = errors.New("no keep alive")
 )
 
+// protocolErrors 帧格式层面的错误, 发生后连接上的字节流不再可信
+var protocolErrors = []error{
+	ErrExceedBody, ErrInvalidHeader, ErrInvalidHeaderMagic, ErrInvalidBody,
+	ErrUnsupportedVersion, ErrVersionMismatch, ErrChecksumMismatch, ErrChecksumMissing,
+	ErrInvalidHandshake,
+}
+
+func IsProtocolError(err error) bool {
+	for _, e := range protocolErrors {
+		if err == e {
+			return true
+		}
+	}
+	return false
+}
+
+// ProtocolError 回复给对端的状态, 说明违反了哪条协议
+func ProtocolError(err error) *Status {
+	return &Status{code: StatusProtocolError.code, message: StatusProtocolError.message + ": " + err.Error()}
+}
+
 var (
-	StatusInvalidRequest *Status = &Status{401, "invalid request"}
-	StatusInvalidPath    *Status = &Status{402, "invalid path"}
+	StatusInvalidRequest *Status = &Status{code: 401, message: "invalid request"}
+	StatusInvalidPath    *Status = &Status{code: 402, message: "invalid path"}
+	StatusProtocolError  *Status = &Status{code: 400, message: "protocol error"}
+
+	StatusFrameTooLarge       *Status = &Status{code: 413, message: "frame too large"}
+	StatusResourceExhausted   *Status = &Status{code: 429, message: "resource exhausted"}
+	StatusIncompatibleVersion *Status = &Status{code: 426, message: "incompatible protocol version"}
 )
+
+// StatusRateLimited 区别于 StatusResourceExhausted: 只是该调用方超出了配额, 稍后重试即可
+var StatusRateLimited = &Status{code: 420, message: "rate limited"}
+
+// RateLimited 回复给对端的状态, 带有建议的重试间隔, 见 RetryAfter
+func RateLimited(retryAfter time.Duration) *Status {
+	if retryAfter < time.Millisecond {
+		retryAfter = time.Millisecond
+	}
+	retryAfter = retryAfter.Round(time.Millisecond)
+	return NewStatus(StatusRateLimited.code, StatusRateLimited.message+", retry after "+retryAfter.String()).WithRetryAfter(retryAfter)
+}
+
+// RetryAfter 对端回复的状态中建议的重试间隔
+func RetryAfter(err error) (time.Duration, bool) {
+	st, ok := err.(*Status)
+	if !ok || st.retryAfter <= 0 {
+		return 0, false
+	}
+	return st.retryAfter, true
+}
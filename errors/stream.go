@@ -0,0 +1,14 @@
+package errors
+
+import "errors"
+
+var (
+	ErrStreamClosed       = errors.New("stream is closed")
+	ErrStreamCanceled     = errors.New("stream canceled")
+	ErrStreamSendClosed   = errors.New("stream send side is closed")
+	ErrStreamFlowControl  = errors.New("stream flow control violation")
+	ErrStreamInvalidFrame = errors.New("invalid stream frame")
+	ErrStreamTooLarge     = errors.New("stream message exceeds peer window or max frame size")
+
+	StatusDeadlineExceeded *Status = &Status{code: 504, message: "deadline exceeded"}
+)
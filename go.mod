@@ -1,8 +1,15 @@
 module github.com/brodyxchen/vsock-sdk
 
-go 1.16
+go 1.20
 
 require (
 	github.com/mdlayher/vsock v1.1.1
 	google.golang.org/protobuf v1.28.0
 )
+
+require (
+	github.com/mdlayher/socket v0.2.0 // indirect
+	golang.org/x/net v0.0.0-20190503192946-f4e77d36d62c // indirect
+	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
+	golang.org/x/sys v0.0.0-20220204135822-1c1b9b1eba6a // indirect
+)
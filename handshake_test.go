@@ -0,0 +1,61 @@
+package vsock_sdk
+
+import (
+	"bytes"
+	"github.com/brodyxchen/vsock-sdk/client"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandshakeNegotiation(t *testing.T) {
+	addr := &models.HttpAddr{IP: "127.0.0.1", Port: 7075}
+	srv := NewServer(addr)
+	srv.HandleFunc("echo", func(req []byte) ([]byte, error) {
+		return req, nil
+	})
+
+	strictAddr := &models.HttpAddr{IP: "127.0.0.1", Port: 7076}
+	strict := NewServer(strictAddr)
+	strict.MinVersion = 2
+	strict.HandleFunc("echo", func(req []byte) ([]byte, error) {
+		return req, nil
+	})
+	// NewServer 会重置全局的统计, 两个 server 都创建后再启动
+	go func() {
+		_ = srv.ListenAndServe()
+	}()
+	go func() {
+		_ = strict.ListenAndServe()
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// 协商到 v2, 可以发送超过16位长度的帧
+	big := bytes.Repeat([]byte("v2"), 50000)
+	cli := NewClient(&client.Config{Timeout: time.Second})
+	rsp, err := cli.Do(addr, "echo", big)
+	if err != nil || !bytes.Equal(rsp, big) {
+		t.Fatalf("v2 echo: %d %v", len(rsp), err)
+	}
+
+	// 不握手的 client 按 v1 通信
+	legacy := NewClient(&client.Config{Timeout: time.Second, DisableHandshake: true})
+	rsp, err = legacy.Do(addr, "echo", []byte("v1"))
+	if err != nil || string(rsp) != "v1" {
+		t.Fatalf("v1 echo: %q %v", rsp, err)
+	}
+	if _, err = legacy.Do(addr, "echo", big); err != errors.ErrExceedBody {
+		t.Fatalf("v1 oversized: %v", err)
+	}
+
+	// server 要求 v2 时拒绝旧版本 client
+	if _, err = legacy.Do(strictAddr, "echo", []byte("v1")); err == nil || !strings.Contains(err.Error(), errors.StatusIncompatibleVersion.Error()) {
+		t.Fatalf("legacy client on strict server: %v", err)
+	}
+	rsp, err = cli.Do(strictAddr, "echo", []byte("v2"))
+	if err != nil || string(rsp) != "v2" {
+		t.Fatalf("strict echo: %q %v", rsp, err)
+	}
+}
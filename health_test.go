@@ -0,0 +1,139 @@
+package vsock_sdk
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/client"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/server"
+	"github.com/brodyxchen/vsock-sdk/statistics"
+	"github.com/brodyxchen/vsock-sdk/statistics/metrics"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientHealthCheck(t *testing.T) {
+	addrs := []models.Addr{
+		&models.HttpAddr{IP: "127.0.0.1", Port: 7100},
+		&models.HttpAddr{IP: "127.0.0.1", Port: 7101},
+	}
+	servers := make([]*server.Server, 0, len(addrs))
+	for _, addr := range addrs {
+		name := addr.GetAddr()
+		srv := NewServer(addr)
+		srv.HandleFunc("whoami", func(req []byte) ([]byte, error) {
+			return []byte(name), nil
+		})
+		srv.SetServingStatus("kv", models.HealthServing)
+		servers = append(servers, srv)
+	}
+	for _, srv := range servers {
+		go func(srv *server.Server) {
+			_ = srv.ListenAndServe()
+		}(srv)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	cli := NewClient(&client.Config{
+		Timeout: time.Second,
+		Breaker: &client.BreakerConfig{CoolDown: 100 * time.Millisecond},
+	})
+	unhealthy := statistics.ClientReg.Get("tp.health.unhealthy").(metrics.Counter)
+	checks := statistics.ClientReg.Get("tp.health.check").(metrics.Counter)
+
+	// 单次检查
+	ctx := context.Background()
+	if status, err := cli.CheckHealth(ctx, addrs[0], ""); err != nil || status != models.HealthServing {
+		t.Fatalf("server: %v %v", status, err)
+	}
+	if status, err := cli.CheckHealth(ctx, addrs[0], "missing"); err != nil || status != models.HealthUnknown {
+		t.Fatalf("unknown service: %v %v", status, err)
+	}
+
+	var (
+		mutex   sync.Mutex
+		changes []bool
+	)
+	target := client.NewTarget("replicas", nil, addrs...)
+	watchCtx, cancel := context.WithCancel(ctx)
+	cli.WatchHealth(watchCtx, target, &client.HealthCheckConfig{
+		Service:  "kv",
+		Interval: 20 * time.Millisecond,
+		OnChange: func(addr models.Addr, healthy bool, status models.HealthStatus) {
+			if addr.GetAddr() != addrs[0].GetAddr() {
+				return
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			if healthy != (status == models.HealthServing) {
+				t.Errorf("change: %v %v", healthy, status)
+			}
+			changes = append(changes, healthy)
+		},
+	})
+	waitFor := func(what string, cond func() bool) {
+		deadline := time.Now().Add(2 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timeout waiting for %v", what)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	ep := target.Endpoint(addrs[0])
+	waitFor("first check", func() bool { return ep.HealthStatus() == models.HealthServing })
+
+	// 服务不可用: 不再参与选择, 熔断器打开
+	servers[0].SetServingStatus("kv", models.HealthNotServing)
+	waitFor("unhealthy", func() bool { return !ep.Healthy() })
+	if ep.HealthStatus() != models.HealthNotServing || unhealthy.Count() != 1 {
+		t.Fatalf("status: %v %d", ep.HealthStatus(), unhealthy.Count())
+	}
+	if state := cli.BreakerState(addrs[0]); state == client.BreakerClosed {
+		t.Fatalf("breaker: %v", state)
+	}
+	for i := 0; i < 4; i++ {
+		if rsp, err := cli.Do(target, "whoami", nil); err != nil || string(rsp) != addrs[1].GetAddr() {
+			t.Fatalf("unhealthy: %q %v", rsp, err)
+		}
+	}
+	// 冷却后的半开探测收到 NOT_SERVING, 熔断器重新打开
+	time.Sleep(300 * time.Millisecond)
+	if state := cli.BreakerState(addrs[0]); state == client.BreakerClosed || ep.Healthy() {
+		t.Fatalf("still down: %v %v", state, ep.Healthy())
+	}
+
+	// 恢复
+	servers[0].SetServingStatus("kv", models.HealthServing)
+	waitFor("healthy", func() bool { return ep.Healthy() })
+	waitFor("breaker closed", func() bool { return cli.BreakerState(addrs[0]) == client.BreakerClosed })
+	if unhealthy.Count() != 0 {
+		t.Fatalf("unhealthy gauge: %d", unhealthy.Count())
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		rsp, err := cli.Do(target, "whoami", nil)
+		if err != nil {
+			t.Fatalf("recovered: %v", err)
+		}
+		seen[string(rsp)] = true
+	}
+	if len(seen) != 2 {
+		t.Fatalf("recovered: %v", seen)
+	}
+
+	// 停止后被标记的地址恢复可用
+	servers[1].SetServingStatus("kv", models.HealthNotServing)
+	waitFor("second unhealthy", func() bool { return !target.Endpoint(addrs[1]).Healthy() })
+	cancel()
+	waitFor("watch stopped", func() bool { return target.Endpoint(addrs[1]).Healthy() })
+	if checks.Count() < 4 || unhealthy.Count() != 0 {
+		t.Fatalf("metrics: %d %d", checks.Count(), unhealthy.Count())
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(changes) != 2 || changes[0] || !changes[1] {
+		t.Fatalf("changes: %v", changes)
+	}
+}
@@ -0,0 +1,221 @@
+package vsock_sdk
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/auth"
+	"github.com/brodyxchen/vsock-sdk/client"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/server"
+	"github.com/brodyxchen/vsock-sdk/statistics"
+	"github.com/brodyxchen/vsock-sdk/statistics/metrics"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientHedging(t *testing.T) {
+	slowAddr := &models.HttpAddr{IP: "127.0.0.1", Port: 7102}
+	fastAddr := &models.HttpAddr{IP: "127.0.0.1", Port: 7103}
+	var slowCalls int64
+	slow := NewServer(slowAddr)
+	slow.HandleFunc("whoami", func(req []byte) ([]byte, error) {
+		atomic.AddInt64(&slowCalls, 1)
+		time.Sleep(200 * time.Millisecond)
+		return []byte("slow"), nil
+	})
+	fast := NewServer(fastAddr)
+	fast.HandleFunc("whoami", func(req []byte) ([]byte, error) {
+		return []byte("fast"), nil
+	})
+	for _, srv := range []*server.Server{slow, fast} {
+		go func(srv *server.Server) {
+			_ = srv.ListenAndServe()
+		}(srv)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	cli := NewClient(&client.Config{
+		Timeout: time.Second,
+		Hedge:   &client.HedgeConfig{Delay: 20 * time.Millisecond, BudgetRatio: 1},
+	})
+	hedges := statistics.ClientReg.Get("tp.hedge").(metrics.Counter)
+	wins := statistics.ClientReg.Get("tp.hedge.win").(metrics.Counter)
+	hedging := client.WithHedging(context.Background())
+
+	// 轮询落到慢的地址时, 对冲到另一个地址并先于原请求返回
+	target := client.NewTarget("replicas", &client.RoundRobin{}, slowAddr, fastAddr)
+	for i := 0; i < 4; i++ {
+		start := time.Now()
+		rsp, err := cli.DoContext(hedging, target, "whoami", nil)
+		if err != nil || string(rsp) != "fast" || time.Since(start) > 150*time.Millisecond {
+			t.Fatalf("hedged %d: %q %v %v", i, rsp, err, time.Since(start))
+		}
+	}
+	// 对冲的选择同样推进轮询, 至少一半的调用先落到慢的地址
+	won := wins.Count()
+	if won < 2 || hedges.Count() != won {
+		t.Fatalf("hedges %d wins %d", hedges.Count(), won)
+	}
+
+	// 没有标记的调用不对冲
+	for i := 0; i < 2; i++ {
+		if _, err := cli.Do(target, "whoami", nil); err != nil || hedges.Count() != won {
+			t.Fatalf("unmarked: %v %d", err, hedges.Count())
+		}
+	}
+
+	// 单个地址时对冲到另一条连接, 原请求先返回不计为对冲胜出
+	before := atomic.LoadInt64(&slowCalls)
+	if rsp, err := cli.DoContext(hedging, slowAddr, "whoami", nil); err != nil || string(rsp) != "slow" {
+		t.Fatalf("single addr: %q %v", rsp, err)
+	}
+	if hedges.Count() != won+1 || wins.Count() != won || atomic.LoadInt64(&slowCalls)-before != 2 {
+		t.Fatalf("single addr: hedges %d wins %d calls %d", hedges.Count(), wins.Count(), atomic.LoadInt64(&slowCalls)-before)
+	}
+}
+
+func TestClientHedgeBudget(t *testing.T) {
+	slowAddr := &models.HttpAddr{IP: "127.0.0.1", Port: 7104}
+	srv := NewServer(slowAddr)
+	srv.HandleFunc("sleep", func(req []byte) ([]byte, error) {
+		time.Sleep(50 * time.Millisecond)
+		return req, nil
+	})
+	go func() {
+		_ = srv.ListenAndServe()
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	cli := NewClient(&client.Config{
+		Timeout: time.Second,
+		Hedge:   &client.HedgeConfig{Delay: 5 * time.Millisecond, BudgetRatio: 0.5},
+	})
+	hedges := statistics.ClientReg.Get("tp.hedge").(metrics.Counter)
+	throttled := statistics.ClientReg.Get("tp.hedge.throttled").(metrics.Counter)
+
+	// 每个调用积累半个名额, 每两个调用才能对冲一次
+	hedging := client.WithHedging(context.Background())
+	for i := 0; i < 10; i++ {
+		if rsp, err := cli.DoContext(hedging, slowAddr, "sleep", []byte("x")); err != nil || string(rsp) != "x" {
+			t.Fatalf("call %d: %q %v", i, rsp, err)
+		}
+	}
+	if hedges.Count() != 5 || throttled.Count() != 5 {
+		t.Fatalf("hedges %d throttled %d", hedges.Count(), throttled.Count())
+	}
+}
+
+func TestClientHedgeHMAC(t *testing.T) {
+	addr := &models.HttpAddr{IP: "127.0.0.1", Port: 7106}
+	var calls int64
+	srv := NewServer(addr)
+	srv.Authenticator = auth.NewHMACAuthenticator(map[string][]byte{"alice": []byte("alice-key")})
+	srv.HandleFunc("whoami", func(req []byte) ([]byte, error) {
+		// 每个调用的第一次发送变慢, 对冲的发送立即返回
+		if atomic.AddInt64(&calls, 1)%2 == 1 {
+			time.Sleep(200 * time.Millisecond)
+			return []byte("primary"), nil
+		}
+		return []byte("hedge"), nil
+	})
+	limitAddr := &models.HttpAddr{IP: "127.0.0.1", Port: 7107}
+	limited := NewServer(limitAddr)
+	limited.MaxConcurrentRequests = 1
+	limited.QueueTimeout = 10 * time.Millisecond
+	limited.HandleFunc("sleep", func(req []byte) ([]byte, error) {
+		time.Sleep(100 * time.Millisecond)
+		return req, nil
+	})
+	for _, srv := range []*server.Server{srv, limited} {
+		go func(srv *server.Server) {
+			_ = srv.ListenAndServe()
+		}(srv)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	cli := NewClient(&client.Config{
+		Timeout:     time.Second,
+		Credentials: &auth.HMAC{KeyID: "alice", Key: []byte("alice-key")},
+		Hedge:       &client.HedgeConfig{Delay: 20 * time.Millisecond, BudgetRatio: 1},
+	})
+	wins := statistics.ClientReg.Get("tp.hedge.win").(metrics.Counter)
+	hedging := client.WithHedging(context.Background())
+
+	// 对冲的发送重新计算认证信息, 不会被当作重放拒绝
+	before := wins.Count()
+	if rsp, err := cli.DoContext(hedging, addr, "whoami", nil); err != nil || string(rsp) != "hedge" {
+		t.Fatalf("call: %q %v", rsp, err)
+	}
+	results, err := cli.DoBatch(hedging, addr, []client.BatchItem{{Path: "whoami"}})
+	if err != nil || string(results[0].Reply) != "hedge" {
+		t.Fatalf("batch: %+v %v", results, err)
+	}
+	if wins.Count()-before != 2 {
+		t.Fatalf("hedge wins %d", wins.Count()-before)
+	}
+
+	// 对冲的发送被 server 限流, 不影响第一次发送的结果
+	if rsp, err := cli.DoContext(hedging, limitAddr, "sleep", []byte("x")); err != nil || string(rsp) != "x" {
+		t.Fatalf("limited: %q %v", rsp, err)
+	}
+}
+
+// preferFirst 总是选择第一个可用的地址
+type preferFirst struct{}
+
+func (preferFirst) Pick(ctx context.Context, endpoints []*client.Endpoint) *client.Endpoint {
+	return endpoints[0]
+}
+
+func TestClientHedgeCanceledProbe(t *testing.T) {
+	slowAddr := &models.HttpAddr{IP: "127.0.0.1", Port: 7108}
+	fastAddr := &models.HttpAddr{IP: "127.0.0.1", Port: 7109}
+	cli := NewClient(&client.Config{
+		Timeout: time.Second,
+		Breaker: &client.BreakerConfig{MinRequests: 2, CoolDown: 200 * time.Millisecond},
+		Hedge:   &client.HedgeConfig{Delay: 20 * time.Millisecond, BudgetRatio: 1},
+	})
+
+	// 没有 server 监听, 拨号失败后打开
+	for i := 0; i < 2; i++ {
+		_, _ = cli.Do(slowAddr, "whoami", nil)
+	}
+	if cli.BreakerState(slowAddr) != client.BreakerOpen {
+		t.Fatalf("state: %v", cli.BreakerState(slowAddr))
+	}
+
+	slow := NewServer(slowAddr)
+	slow.HandleFunc("whoami", func(req []byte) ([]byte, error) {
+		time.Sleep(300 * time.Millisecond)
+		return []byte("slow"), nil
+	})
+	fast := NewServer(fastAddr)
+	fast.HandleFunc("whoami", func(req []byte) ([]byte, error) {
+		return []byte("fast"), nil
+	})
+	for _, srv := range []*server.Server{slow, fast} {
+		go func(srv *server.Server) {
+			_ = srv.ListenAndServe()
+		}(srv)
+	}
+	time.Sleep(250 * time.Millisecond)
+
+	// 半开的地址上的探测被对冲胜出后取消, 熔断器仍然半开
+	target := client.NewTarget("replicas", preferFirst{}, slowAddr, fastAddr)
+	rsp, err := cli.DoContext(client.WithHedging(context.Background()), target, "whoami", nil)
+	if err != nil || string(rsp) != "fast" {
+		t.Fatalf("hedged: %q %v", rsp, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if cli.BreakerState(slowAddr) != client.BreakerHalfOpen {
+		t.Fatalf("state: %v", cli.BreakerState(slowAddr))
+	}
+
+	// 探测名额已经释放
+	if rsp, err = cli.Do(slowAddr, "whoami", nil); err != nil || string(rsp) != "slow" {
+		t.Fatalf("probe: %q %v", rsp, err)
+	}
+	if cli.BreakerState(slowAddr) != client.BreakerClosed {
+		t.Fatalf("state: %v", cli.BreakerState(slowAddr))
+	}
+}
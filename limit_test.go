@@ -0,0 +1,155 @@
+package vsock_sdk
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/client"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/server"
+	"github.com/brodyxchen/vsock-sdk/stream"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientLoadShedding(t *testing.T) {
+	addr := &models.HttpAddr{IP: "127.0.0.1", Port: 7086}
+	srv := NewServer(addr)
+	srv.MaxConcurrentRequests = 1
+	srv.MaxRequestQueue = 1
+	srv.QueueTimeout = 100 * time.Millisecond
+
+	connAddr := &models.HttpAddr{IP: "127.0.0.1", Port: 7087}
+	connSrv := NewServer(connAddr)
+	connSrv.MaxConns = 1
+
+	block := make(chan struct{})
+	started := make(chan struct{}, 1)
+	srv.HandleFunc("block", func(req []byte) ([]byte, error) {
+		started <- struct{}{}
+		<-block
+		return []byte("done"), nil
+	})
+	for _, s := range []*server.Server{srv, connSrv} {
+		s.HandleFunc("echo", func(req []byte) ([]byte, error) {
+			return req, nil
+		})
+		go func(s *server.Server) {
+			_ = s.ListenAndServe()
+		}(s)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	cli := NewClient(&client.Config{Timeout: 2 * time.Second})
+	running := cli.Go(context.Background(), addr, "block", nil)
+	<-started
+
+	// 一个排队到超时, 一个因队列已满立即被拒绝
+	queued := cli.Go(context.Background(), addr, "echo", nil)
+	time.Sleep(20 * time.Millisecond)
+	now := time.Now()
+	if _, err := cli.Do(addr, "echo", nil); err == nil || !strings.Contains(err.Error(), "resource exhausted") {
+		t.Fatalf("queue full: %v", err)
+	}
+	if cost := time.Since(now); cost > 50*time.Millisecond {
+		t.Fatalf("queue full shed after %v", cost)
+	}
+	if _, err := queued.Wait(); err == nil || !strings.Contains(err.Error(), "resource exhausted") {
+		t.Fatalf("queue timeout: %v", err)
+	}
+
+	close(block)
+	if rsp, err := running.Wait(); err != nil || string(rsp) != "done" {
+		t.Fatalf("running: %q %v", rsp, err)
+	}
+	if rsp, err := cli.Do(addr, "echo", []byte("ok")); err != nil || string(rsp) != "ok" {
+		t.Fatalf("after overload: %q %v", rsp, err)
+	}
+
+	// MaxConns: 第二条连接在第一条关闭前不会被服务
+	first, err := cli.DialTest(connAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := NewClient(&client.Config{Timeout: 200 * time.Millisecond})
+	if _, err = other.Do(connAddr, "echo", nil); err == nil {
+		t.Fatal("connection over MaxConns served")
+	}
+	first.CloseTest()
+	time.Sleep(50 * time.Millisecond)
+	other = NewClient(&client.Config{Timeout: time.Second})
+	if rsp, err := other.Do(connAddr, "echo", []byte("ok")); err != nil || string(rsp) != "ok" {
+		t.Fatalf("after close: %q %v", rsp, err)
+	}
+}
+
+func isStatus(err error, want *errors.Status) bool {
+	st, ok := err.(*errors.Status)
+	return ok && st.Code() == want.Code()
+}
+
+func TestServerStreamConcurrency(t *testing.T) {
+	addr := &models.HttpAddr{IP: "127.0.0.1", Port: 7112}
+	srv := NewServer(addr)
+	srv.MaxConcurrentRequests = 1
+	srv.MaxRequestQueue = 1
+	srv.QueueTimeout = 50 * time.Millisecond
+	srv.HandleBidiStream("echo", func(s *stream.Stream) error {
+		for {
+			msg, err := s.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err = s.Send(msg); err != nil {
+				return err
+			}
+		}
+	})
+	srv.HandleFunc("echo", func(req []byte) ([]byte, error) {
+		return req, nil
+	})
+	go func() {
+		_ = srv.ListenAndServe()
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	cli := NewClient(&client.Config{Timeout: time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// 打开的流占用唯一的执行槽位, 之后的流和调用排队超时后被拒绝
+	open, err := cli.NewBidiStream(ctx, addr, "echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = open.Send([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := open.Recv(); err != nil || string(got) != "x" {
+		t.Fatalf("open stream: %q %v", got, err)
+	}
+	shed, err := cli.NewBidiStream(ctx, addr, "echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = shed.Recv(); !isStatus(err, errors.StatusResourceExhausted) {
+		t.Fatalf("second stream: %v", err)
+	}
+	if _, err = cli.Do(addr, "echo", nil); err == nil || !strings.Contains(err.Error(), "resource exhausted") {
+		t.Fatalf("call during stream: %v", err)
+	}
+
+	// 流结束后释放槽位
+	_ = open.CloseSend()
+	if _, err = open.Recv(); err != io.EOF {
+		t.Fatalf("stream end: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if rsp, err := cli.Do(addr, "echo", []byte("ok")); err != nil || string(rsp) != "ok" {
+		t.Fatalf("after stream: %q %v", rsp, err)
+	}
+}
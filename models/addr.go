@@ -1,6 +1,11 @@
 package models
 
-import "strconv"
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
 
 type Addr interface {
 	GetAddr() string
@@ -23,3 +28,32 @@ type HttpAddr struct {
 func (ha *HttpAddr) GetAddr() string {
 	return ha.IP + ":" + strconv.FormatUint(uint64(ha.Port), 10)
 }
+
+// ParseAddr 解析 "vsock://cid:port", "tcp://host:port" 或不带前缀的 "cid:port", "host:port";
+// 不带前缀时 host 全为数字按 vsock 处理
+func ParseAddr(s string) (Addr, error) {
+	scheme := ""
+	if i := strings.Index(s, "://"); i >= 0 {
+		scheme, s = s[:i], s[i+3:]
+	}
+	host, portStr, err := net.SplitHostPort(s)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %q", s)
+	}
+	cid, cidErr := strconv.ParseUint(host, 10, 32)
+
+	switch {
+	case scheme == "vsock" || (scheme == "" && cidErr == nil):
+		if cidErr != nil {
+			return nil, fmt.Errorf("invalid cid in %q", s)
+		}
+		return &VSockAddr{ContextId: uint32(cid), Port: uint32(port)}, nil
+	case scheme == "tcp" || scheme == "":
+		return &HttpAddr{IP: host, Port: uint32(port)}, nil
+	}
+	return nil, fmt.Errorf("unknown scheme %q", scheme)
+}
@@ -0,0 +1,31 @@
+package models
+
+// HealthStatus 健康检查回复的服务状态, 以 String() 的文本在线上传输
+type HealthStatus int32
+
+const (
+	HealthUnknown    HealthStatus = iota // 服务没有登记, 或 client 还没有检查过
+	HealthServing                        // 可以处理请求
+	HealthNotServing                     // 暂时不处理请求, 例如正在启动或准备退出
+)
+
+func (s HealthStatus) String() string {
+	switch s {
+	case HealthServing:
+		return "SERVING"
+	case HealthNotServing:
+		return "NOT_SERVING"
+	}
+	return "UNKNOWN"
+}
+
+// ParseHealthStatus 无法识别的文本为 HealthUnknown
+func ParseHealthStatus(s string) HealthStatus {
+	switch s {
+	case "SERVING":
+		return HealthServing
+	case "NOT_SERVING":
+		return HealthNotServing
+	}
+	return HealthUnknown
+}
@@ -1,9 +1,16 @@
 package models
 
-import "context"
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/buffer"
+	"github.com/brodyxchen/vsock-sdk/constant"
+	"github.com/brodyxchen/vsock-sdk/errors"
+)
 
 const (
-	HeaderSize = 8 // 8个Byte
+	HeaderSize    = 8  // 8个Byte, v1
+	HeaderSizeV2  = 12 // v2 增加 Flags, Length 扩展到32位
+	MaxHeaderSize = HeaderSizeV2
 )
 
 //Header 一排32位
@@ -12,7 +19,45 @@ type Header struct {
 	Version uint16
 
 	Code   uint16 // action or status_code
-	Length uint16 //64k
+	Flags  uint16 // v2
+	Length uint32 // v1 最大64k
+}
+
+// Protocol 连接上握手协商的结果
+type Protocol struct {
+	Version      uint16
+	Features     uint32
+	MaxFrameSize int   // 对端能接收的最大 body
+	Compressor   uint8 // FeatureCompression 时双方使用的压缩算法
+}
+
+// LegacyProtocol 没有握手或对端不支持握手时使用
+var LegacyProtocol = Protocol{
+	Version:      constant.DefaultVersion,
+	MaxFrameSize: constant.MaxFrameSizeV1,
+}
+
+func (p *Protocol) Has(feature uint32) bool {
+	return p.Features&feature == feature
+}
+
+// FrameFlags 该连接上每一帧都要带的 Flags
+func (p *Protocol) FrameFlags() uint16 {
+	if p.Has(constant.FeatureChecksum) {
+		return constant.FlagChecksum
+	}
+	return 0
+}
+
+// Check 读到的帧是否符合协商的结果, 不符合时连接上的数据已经不可信
+func (p *Protocol) Check(header *Header) error {
+	if header.Version != p.Version {
+		return errors.ErrVersionMismatch
+	}
+	if p.Has(constant.FeatureChecksum) && header.Flags&constant.FlagChecksum == 0 {
+		return errors.ErrChecksumMissing
+	}
+	return nil
 }
 
 type Request struct {
@@ -21,6 +66,11 @@ type Request struct {
 	Ctx  context.Context
 	Addr Addr
 	Body []byte
+
+	Frame *buffer.Buffer // 非空时直接写出该帧(帧头已预留), Body 为其中的body部分
+
+	// Encode 非空时对冲的每次发送都用它重新编码一帧(重新计算一次性的认证信息), 返回的帧由发送方释放
+	Encode func() (*buffer.Buffer, error)
 }
 
 func (r *Request) Context() context.Context {
@@ -0,0 +1,129 @@
+package vsock_sdk
+
+import (
+	"bufio"
+	"context"
+	"github.com/brodyxchen/vsock-sdk/client"
+	"github.com/brodyxchen/vsock-sdk/constant"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/protocols"
+	"github.com/brodyxchen/vsock-sdk/socket"
+	"github.com/brodyxchen/vsock-sdk/statistics"
+	"github.com/brodyxchen/vsock-sdk/statistics/metrics"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClientNotify(t *testing.T) {
+	received := make(chan string, 10)
+	LaunchCustomExampleServer(7073, time.Second, time.Second, time.Second*10, true, func(bytes []byte) ([]byte, error) {
+		received <- string(bytes)
+		return []byte("ignored"), nil
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	cli := NewClient(&client.Config{Timeout: time.Second})
+	addr := &models.HttpAddr{IP: "127.0.0.1", Port: 7073}
+	trips := statistics.ClientReg.Get("tp.trip").(metrics.Histogram)
+
+	for _, msg := range []string{"a", "b"} {
+		if err := cli.Notify(context.Background(), addr, "test", []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 通知不计入调用的往返耗时
+	if trips.Count() != 0 {
+		t.Fatalf("notify recorded in tp.trip: %d", trips.Count())
+	}
+	for _, want := range []string{"a", "b"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("notify not delivered")
+		}
+	}
+
+	// 通知之后同一连接上的普通调用不受影响
+	rsp, err := cli.Do(addr, "test", []byte("c"))
+	if err != nil || string(rsp) != "ignored" {
+		t.Fatalf("do after notify: %q %v", rsp, err)
+	}
+	if trips.Count() != 1 {
+		t.Fatalf("tp.trip: %d", trips.Count())
+	}
+	<-received
+}
+
+// serveLegacyEcho 模拟旧版本 server: 不认识 Header.Code, 对每一帧都回复, 只有 echo 路由
+func serveLegacyEcho(t *testing.T, addr string) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader, writer := bufio.NewReader(conn), bufio.NewWriter(conn)
+				for {
+					header := &models.Header{}
+					buf, _, err := socket.ReadFrame(context.Background(), reader, header, constant.MaxFrameSizeV1)
+					if err != nil {
+						return
+					}
+					var body []byte
+					if buf != nil {
+						body = buf.B
+					}
+					path, req, _, err := protocols.DecodeRequest(body)
+					rspHeader := &models.Header{Magic: constant.DefaultMagic, Version: constant.DefaultVersion}
+					var rsp []byte
+					if err != nil || string(path) != "echo" {
+						rspHeader.Code = errors.StatusInvalidPath.Code()
+						rsp = []byte(errors.StatusInvalidPath.Error())
+					} else {
+						rsp = protocols.AppendResponse(nil, protocols.StatusOK, req, "")
+					}
+					buf.Release()
+					if _, err = socket.WriteSocket(context.Background(), writer, rspHeader, rsp); err != nil {
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+}
+
+func TestClientNotifyLegacyServer(t *testing.T) {
+	serveLegacyEcho(t, "127.0.0.1:7110")
+	addr := &models.HttpAddr{IP: "127.0.0.1", Port: 7110}
+
+	// 握手回退到 v1, 或者不握手: 都没有协商 notify
+	for _, cfg := range []*client.Config{
+		{Timeout: time.Second},
+		{Timeout: time.Second, DisableHandshake: true},
+	} {
+		cli := NewClient(cfg)
+		if err := cli.Notify(context.Background(), addr, "echo", []byte("notify")); err != errors.ErrNotifyUnsupported {
+			t.Fatalf("notify: %v", err)
+		}
+		// 同一连接上的下一个调用收到自己的回复
+		for _, msg := range []string{"a", "b"} {
+			if rsp, err := cli.Do(addr, "echo", []byte(msg)); err != nil || string(rsp) != msg {
+				t.Fatalf("do %s: %q %v", msg, rsp, err)
+			}
+		}
+	}
+}
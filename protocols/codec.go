@@ -0,0 +1,85 @@
+package protocols
+
+import (
+	"errors"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// 热路径上的 Request/Response 编解码, 与 proto.Marshal/Unmarshal 的结果兼容,
+// 但直接 append 到调用方的缓冲中, 解码时不拷贝 bytes 字段
+
+var errInvalidWire = errors.New("invalid protobuf wire data")
+
+// AppendRequest 等同于 proto.Marshal(&Request{Path: path, Req: req})
+func AppendRequest(b []byte, path string, req []byte) []byte {
+	if len(path) > 0 {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, path)
+	}
+	if len(req) > 0 {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, req)
+	}
+	return b
+}
+
+// AppendAuth 在 AppendRequest 的结果之后追加 Request.auth, auth 为空时不追加
+func AppendAuth(b []byte, auth []byte) []byte {
+	if len(auth) > 0 {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, auth)
+	}
+	return b
+}
+
+// DecodeRequest 返回的 path, req 和 auth 都指向 b
+func DecodeRequest(b []byte) (path []byte, req []byte, auth []byte, err error) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, nil, nil, errInvalidWire
+		}
+		b = b[n:]
+
+		if num >= 1 && num <= 3 && typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, nil, nil, errInvalidWire
+			}
+			switch num {
+			case 1:
+				path = v
+			case 2:
+				req = v
+			default:
+				auth = v
+			}
+			b = b[n:]
+			continue
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return nil, nil, nil, errInvalidWire
+		}
+		b = b[n:]
+	}
+	return path, req, auth, nil
+}
+
+// AppendResponse 等同于 proto.Marshal(&Response{Code: code, Rsp: rsp, Err: err})
+func AppendResponse(b []byte, code int32, rsp []byte, err string) []byte {
+	if code != 0 {
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(code))
+	}
+	if len(rsp) > 0 {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, rsp)
+	}
+	if len(err) > 0 {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, err)
+	}
+	return b
+}
@@ -0,0 +1,69 @@
+package protocols
+
+import (
+	"bytes"
+	"google.golang.org/protobuf/proto"
+	"testing"
+)
+
+func TestCodecMatchesProto(t *testing.T) {
+	for _, req := range []*Request{
+		{},
+		{Path: "a"},
+		{Req: []byte("body")},
+		{Path: "test/path", Req: bytes.Repeat([]byte("x"), 300)},
+		{Path: "a", Req: []byte("body"), Auth: []byte("token")},
+	} {
+		want, _ := proto.Marshal(req)
+		got := AppendAuth(AppendRequest(nil, req.Path, req.Req), req.Auth)
+		if !bytes.Equal(got, want) {
+			t.Fatalf("AppendRequest(%v) = %x, want %x", req, got, want)
+		}
+
+		path, body, auth, err := DecodeRequest(want)
+		if err != nil || string(path) != req.Path || !bytes.Equal(body, req.Req) || !bytes.Equal(auth, req.Auth) {
+			t.Fatalf("DecodeRequest(%v) = %q %q %q %v", req, path, body, auth, err)
+		}
+	}
+
+	for _, rsp := range []*Response{
+		{Code: StatusOK, Rsp: []byte("ok")},
+		{Code: StatusErr, Err: "failed"},
+		{Code: -1},
+	} {
+		want, _ := proto.Marshal(rsp)
+		got := AppendResponse(nil, rsp.Code, rsp.Rsp, rsp.Err)
+		if !bytes.Equal(got, want) {
+			t.Fatalf("AppendResponse(%v) = %x, want %x", rsp, got, want)
+		}
+	}
+
+	// 未知字段被跳过
+	withUnknown := append(AppendRequest(nil, "p", []byte("r")), 0x18, 0x01)
+	if path, body, _, err := DecodeRequest(withUnknown); err != nil || string(path) != "p" || string(body) != "r" {
+		t.Fatalf("unknown field: %q %q %v", path, body, err)
+	}
+	if _, _, _, err := DecodeRequest([]byte{0x0a, 0x05, 'a'}); err == nil {
+		t.Fatal("truncated request should fail")
+	}
+}
+
+var benchReq = bytes.Repeat([]byte("x"), 1024)
+
+func BenchmarkRequestProto(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		data, _ := proto.Marshal(&Request{Path: "bench", Req: benchReq})
+		var req Request
+		_ = proto.Unmarshal(data, &req)
+	}
+}
+
+func BenchmarkRequestCodec(b *testing.B) {
+	buf := make([]byte, 0, 2048)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		data := AppendRequest(buf[:0], "bench", benchReq)
+		_, _, _, _ = DecodeRequest(data)
+	}
+}
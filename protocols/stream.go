@@ -0,0 +1,11 @@
+package protocols
+
+var (
+	// 流帧类型, 不能是0
+	StreamOpen   int32 = 1 // client -> server, 打开流
+	StreamData   int32 = 2 // 数据
+	StreamWindow int32 = 3 // 流控窗口增量
+	StreamClose  int32 = 4 // 半关闭: 发送方不再发送数据
+	StreamCancel int32 = 5 // client -> server, 取消
+	StreamEnd    int32 = 6 // server -> client, 流结束并携带状态
+)
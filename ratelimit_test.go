@@ -0,0 +1,69 @@
+package vsock_sdk
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/auth"
+	"github.com/brodyxchen/vsock-sdk/client"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/server"
+	"testing"
+	"time"
+)
+
+func TestClientRateLimit(t *testing.T) {
+	addr := &models.HttpAddr{IP: "127.0.0.1", Port: 7088}
+	srv := NewServer(addr)
+	srv.Authenticator = auth.NewTokenAuthenticator(map[string]string{"a": "alice", "b": "bob"})
+	srv.RateLimits = []server.RateLimit{
+		{Path: "echo", Rate: 1, Burst: 2, Key: server.LimitByPrincipal},
+	}
+	srv.HandleFunc("echo", func(req []byte) ([]byte, error) {
+		return req, nil
+	})
+	srv.HandleFunc("free", func(req []byte) ([]byte, error) {
+		return req, nil
+	})
+	go func() {
+		_ = srv.ListenAndServe()
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	cli := NewClient(&client.Config{Timeout: time.Second, Credentials: auth.Bearer("a")})
+	for i := 0; i < 2; i++ {
+		if _, err := cli.Do(addr, "echo", nil); err != nil {
+			t.Fatalf("burst %d: %v", i, err)
+		}
+	}
+	_, err := cli.Do(addr, "echo", nil)
+	if retryAfter, ok := errors.RetryAfter(err); !ok || retryAfter <= 0 || retryAfter > time.Second {
+		t.Fatalf("limited: %v", err)
+	}
+
+	// 批量调用的子请求和流同样带有重试间隔
+	results, err := cli.DoBatch(context.Background(), addr, []client.BatchItem{{Path: "echo"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retryAfter, ok := errors.RetryAfter(results[0].Error); !ok || retryAfter <= 0 || retryAfter > time.Second {
+		t.Fatalf("batch limited: %v", results[0].Error)
+	}
+	s, err := cli.NewBidiStream(context.Background(), addr, "echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Recv(); err == nil {
+		t.Fatal("stream not limited")
+	} else if retryAfter, ok := errors.RetryAfter(err); !ok || retryAfter <= 0 || retryAfter > time.Second {
+		t.Fatalf("stream limited: %v", err)
+	}
+
+	// 其它路由和其它 principal 不受影响
+	if _, err = cli.Do(addr, "free", nil); err != nil {
+		t.Fatalf("free: %v", err)
+	}
+	bob := client.WithCredentials(context.Background(), auth.Bearer("b"))
+	if _, err = cli.DoContext(bob, addr, "echo", nil); err != nil {
+		t.Fatalf("bob: %v", err)
+	}
+}
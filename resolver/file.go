@@ -0,0 +1,192 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/brodyxchen/vsock-sdk/constant"
+	"github.com/brodyxchen/vsock-sdk/log"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"os"
+	"strings"
+	"time"
+)
+
+// File 从文件读取地址列表, 每隔 Interval 检查一次修改时间和大小, 变化时重新读取, 格式见 Parse.
+// 解析失败或文件为空(正在写入)时保留之前的列表; 更新文件最好先写临时文件再 rename
+type File struct {
+	Path     string
+	Interval time.Duration // 默认 constant.ResolverPollInterval
+}
+
+func (f *File) interval() time.Duration {
+	if f.Interval > 0 {
+		return f.Interval
+	}
+	return constant.ResolverPollInterval
+}
+
+// Watch 第一次读取失败时返回错误, 之后的错误只记录日志
+func (f *File) Watch(ctx context.Context, update func(addrs []models.Addr)) error {
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return err
+	}
+	current, err := f.load()
+	if err != nil {
+		return err
+	}
+	update(current)
+
+	ticker := time.NewTicker(f.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		latest, err := os.Stat(f.Path)
+		if err != nil {
+			if info != nil {
+				log.Errorf("resolver %v: %v\n", f.Path, err)
+			}
+			info = nil
+			continue
+		}
+		if info != nil && latest.ModTime().Equal(info.ModTime()) && latest.Size() == info.Size() {
+			continue
+		}
+		info = latest
+		if latest.Size() == 0 {
+			continue
+		}
+
+		addrs, err := f.load()
+		if err != nil {
+			log.Errorf("resolver %v: %v\n", f.Path, err)
+			continue
+		}
+		if !equal(addrs, current) {
+			current = addrs
+			update(addrs)
+		}
+	}
+}
+
+func (f *File) load() ([]models.Addr, error) {
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse 解析地址列表, 地址格式见 models.ParseAddr. 以 '[' 或 '{' 开头时按 JSON 解析:
+//
+//	["vsock://16:5000", "vsock://17:5000"] 或 {"endpoints": [...]}
+//
+// 否则按 YAML 的子集解析: 字符串的块序列, 可以放在 endpoints 键下, 支持引号和 # 注释
+//
+//	endpoints:
+//	  - vsock://16:5000
+//	  - "17:5000" # 不带前缀且 host 为数字时按 vsock 处理
+func Parse(data []byte) ([]models.Addr, error) {
+	var list []string
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+		if trimmed[0] == '[' {
+			if err := json.Unmarshal(trimmed, &list); err != nil {
+				return nil, err
+			}
+		} else {
+			var doc struct {
+				Endpoints []string `json:"endpoints"`
+			}
+			if err := json.Unmarshal(trimmed, &doc); err != nil {
+				return nil, err
+			}
+			list = doc.Endpoints
+		}
+	} else {
+		var err error
+		if list, err = parseYAML(string(data)); err != nil {
+			return nil, err
+		}
+	}
+
+	addrs := make([]models.Addr, 0, len(list))
+	for _, s := range list {
+		addr, err := models.ParseAddr(s)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+func parseYAML(data string) ([]string, error) {
+	var (
+		list []string
+		key  bool // 已经出现过 endpoints 键
+	)
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(stripComment(line))
+		switch {
+		case line == "" || line == "---":
+		case line == "endpoints:" && !key && len(list) == 0:
+			key = true
+		case line == "-" || strings.HasPrefix(line, "- "):
+			value, err := unquote(strings.TrimSpace(line[1:]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+1, err)
+			}
+			list = append(list, value)
+		default:
+			return nil, fmt.Errorf("line %d: unsupported %q", i+1, line)
+		}
+	}
+	return list, nil
+}
+
+// stripComment 去掉引号之外, 行首或空白之后的 # 注释
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquote(s string) (string, error) {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') {
+		if s[len(s)-1] != s[0] {
+			return "", fmt.Errorf("unterminated quote in %v", s)
+		}
+		return s[1 : len(s)-1], nil
+	}
+	return s, nil
+}
+
+func equal(a, b []models.Addr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].GetAddr() != b[i].GetAddr() {
+			return false
+		}
+	}
+	return true
+}
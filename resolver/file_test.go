@@ -0,0 +1,126 @@
+package resolver
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func addrsOf(addrs []models.Addr) []string {
+	list := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		switch addr.(type) {
+		case *models.VSockAddr:
+			list = append(list, "vsock:"+addr.GetAddr())
+		case *models.HttpAddr:
+			list = append(list, "tcp:"+addr.GetAddr())
+		}
+	}
+	return list
+}
+
+func TestParse(t *testing.T) {
+	want := []string{"vsock:16:5000", "vsock:17:5000", "tcp:127.0.0.1:7000"}
+	docs := map[string]string{
+		"json list":   `["vsock://16:5000", "17:5000", "tcp://127.0.0.1:7000"]`,
+		"json object": `{"endpoints": ["vsock://16:5000", "17:5000", "127.0.0.1:7000"]}`,
+		"yaml list":   "# replicas\n- vsock://16:5000\n- '17:5000'\n- 127.0.0.1:7000 # local\n",
+		"yaml key":    "---\nendpoints:\n  - \"vsock://16:5000\"\n  - 17:5000\n\n  - tcp://127.0.0.1:7000\n",
+	}
+	for name, doc := range docs {
+		addrs, err := Parse([]byte(doc))
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		got := addrsOf(addrs)
+		if len(got) != len(want) {
+			t.Fatalf("%v: %v", name, got)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("%v: %v", name, got)
+			}
+		}
+	}
+
+	for _, doc := range []string{
+		`["vsock://a:1"]`,
+		`["udp://1:1"]`,
+		`["16"]`,
+		"- vsock://16:5000\nport: 1\n",
+		"- '16:5000\n",
+		`{"endpoints": "16:5000"}`,
+	} {
+		if _, err := Parse([]byte(doc)); err == nil {
+			t.Fatalf("%q: expected error", doc)
+		}
+	}
+}
+
+func TestFileWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replicas.yaml")
+	write := func(doc string) {
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, []byte(doc), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resolver := &File{Path: path, Interval: 10 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := resolver.Watch(ctx, func([]models.Addr) {}); err == nil {
+		t.Fatal("missing file")
+	}
+
+	write("- 16:5000\n")
+	updates := make(chan []string, 8)
+	done := make(chan error, 1)
+	go func() {
+		done <- resolver.Watch(ctx, func(addrs []models.Addr) {
+			updates <- addrsOf(addrs)
+		})
+	}()
+	next := func() []string {
+		select {
+		case got := <-updates:
+			return got
+		case <-time.After(time.Second):
+			t.Fatal("no update")
+			return nil
+		}
+	}
+	if got := next(); len(got) != 1 || got[0] != "vsock:16:5000" {
+		t.Fatalf("initial: %v", got)
+	}
+
+	// 解析失败或内容不变时不更新
+	write("- 16:5000\nbroken\n")
+	time.Sleep(50 * time.Millisecond)
+	write("- '16:5000' # same\n")
+	time.Sleep(50 * time.Millisecond)
+	write(`["16:5000", "17:5000"]`)
+	if got := next(); len(got) != 2 || got[1] != "vsock:17:5000" {
+		t.Fatalf("changed: %v", got)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 0 {
+		t.Fatalf("unexpected updates: %v", <-updates)
+	}
+
+	got := make(chan []models.Addr, 1)
+	if err := (Static{&models.VSockAddr{ContextId: 3, Port: 1}}).Watch(context.Background(), func(addrs []models.Addr) {
+		got <- addrs
+	}); err != nil || len(<-got) != 1 {
+		t.Fatalf("static: %v", err)
+	}
+}
@@ -0,0 +1,16 @@
+// Package resolver 内置的 client.Resolver 实现
+package resolver
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/models"
+)
+
+// Static 固定的地址列表
+type Static []models.Addr
+
+// Watch 调用一次 update 后返回
+func (s Static) Watch(_ context.Context, update func(addrs []models.Addr)) error {
+	update(append([]models.Addr(nil), s...))
+	return nil
+}
@@ -0,0 +1,170 @@
+package vsock_sdk
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/client"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/resolver"
+	"github.com/brodyxchen/vsock-sdk/server"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// closeCountingListener 统计 server 关闭的连接数
+type closeCountingListener struct {
+	net.Listener
+	closed int64
+}
+
+func (l *closeCountingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &closeCountingConn{Conn: conn, closed: &l.closed}, nil
+}
+
+type closeCountingConn struct {
+	net.Conn
+	once   sync.Once
+	closed *int64
+}
+
+func (c *closeCountingConn) Close() error {
+	c.once.Do(func() {
+		atomic.AddInt64(c.closed, 1)
+	})
+	return c.Conn.Close()
+}
+
+func TestClientResolverDrain(t *testing.T) {
+	addrs := []models.Addr{
+		&models.HttpAddr{IP: "127.0.0.1", Port: 7096},
+		&models.HttpAddr{IP: "127.0.0.1", Port: 7097},
+	}
+	release := make(chan struct{})
+	servers := make([]*server.Server, 0, len(addrs))
+	listeners := make([]*closeCountingListener, 0, len(addrs))
+	for _, addr := range addrs {
+		name := addr.GetAddr()
+		srv := NewServer(addr)
+		srv.HandleFunc("whoami", func(req []byte) ([]byte, error) {
+			return []byte(name), nil
+		})
+		srv.HandleFunc("block", func(req []byte) ([]byte, error) {
+			<-release
+			return []byte(name), nil
+		})
+		ln, err := net.Listen("tcp", addr.GetAddr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		servers = append(servers, srv)
+		listeners = append(listeners, &closeCountingListener{Listener: ln})
+	}
+	for i, srv := range servers {
+		go func(srv *server.Server, ln net.Listener) {
+			_ = srv.Serve(ln)
+		}(srv, listeners[i])
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	path := filepath.Join(t.TempDir(), "replicas.json")
+	write := func(doc string) {
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, []byte(doc), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`["tcp://127.0.0.1:7096"]`)
+
+	cli := NewClient(&client.Config{Timeout: 2 * time.Second, PoolIdleTimeout: 300 * time.Millisecond})
+	target := client.NewTarget("replicas", nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := cli.WatchTarget(ctx, target, &resolver.File{Path: path, Interval: 10 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.WatchTarget(ctx, client.NewTarget("missing", nil), &resolver.File{Path: path + ".missing"}); err == nil {
+		t.Fatal("missing file")
+	}
+
+	// 两个并发的阻塞调用在第一个地址上建立两条连接, 结束后都进入连接池
+	var wg sync.WaitGroup
+	block := func() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rsp, err := cli.Do(target, "block", nil); err != nil || string(rsp) != addrs[0].GetAddr() {
+				t.Errorf("block: %q %v", rsp, err)
+			}
+		}()
+	}
+	block()
+	block()
+	waitFor := func(what string, cond func() bool) {
+		deadline := time.Now().Add(2 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timeout waiting for %v", what)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor("two calls", func() bool { return target.Endpoint(addrs[0]).Outstanding() == 2 })
+	release <- struct{}{}
+	release <- struct{}{}
+	wg.Wait()
+
+	// 一条连接上有进行中的调用时切换到第二个地址
+	block()
+	waitFor("in-flight call", func() bool { return target.Endpoint(addrs[0]).Outstanding() == 1 })
+	write(`{"endpoints": ["tcp://127.0.0.1:7097"]}`)
+	waitFor("update", func() bool { return target.Endpoint(addrs[0]) == nil })
+	if rsp, err := cli.Do(target, "whoami", nil); err != nil || string(rsp) != addrs[1].GetAddr() {
+		t.Fatalf("after update: %q %v", rsp, err)
+	}
+
+	// 空闲的连接立即关闭
+	waitFor("idle conn closed", func() bool { return atomic.LoadInt64(&listeners[0].closed) == 1 })
+
+	// 进行中的调用超过连接池的空闲超时, 期间另一个地址被移除
+	time.Sleep(350 * time.Millisecond)
+	extra := &models.HttpAddr{IP: "127.0.0.1", Port: 7999}
+	write(`["tcp://127.0.0.1:7097", "tcp://127.0.0.1:7999"]`)
+	waitFor("extra added", func() bool { return target.Endpoint(extra) != nil })
+	write(`["tcp://127.0.0.1:7097"]`)
+	waitFor("extra removed", func() bool { return target.Endpoint(extra) == nil })
+
+	// 调用正常完成, 归还时立即关闭而不是进入连接池等待空闲超时
+	release <- struct{}{}
+	wg.Wait()
+	deadline := time.Now().Add(150 * time.Millisecond)
+	for atomic.LoadInt64(&listeners[0].closed) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("in-flight conn returned to the pool")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// 地址重新加入后连接恢复复用
+	write(`["tcp://127.0.0.1:7096"]`)
+	waitFor("re-add", func() bool { return target.Endpoint(addrs[0]) != nil })
+	for i := 0; i < 3; i++ {
+		if rsp, err := cli.Do(target, "whoami", nil); err != nil || string(rsp) != addrs[0].GetAddr() {
+			t.Fatalf("re-added: %q %v", rsp, err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if closed := atomic.LoadInt64(&listeners[0].closed); closed != 2 {
+		t.Fatalf("closed after re-add: %d", closed)
+	}
+}
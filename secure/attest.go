@@ -0,0 +1,52 @@
+package secure
+
+import (
+	"bytes"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"time"
+)
+
+// Attester 生成证明文档, 例如 Nitro Enclaves 的 NSM. 文档必须绑定 publicKey 和 nonce,
+// server 传入本次连接的 X25519 临时公钥, nonce 为 client 的临时公钥, 保证文档是为这条连接新生成的
+type Attester interface {
+	Attest(publicKey, nonce []byte) ([]byte, error)
+}
+
+// Verifier 校验证明文档的签名(证书链等)并解析出内容, 不检查 publicKey, nonce 和度量值, 由握手统一检查
+type Verifier interface {
+	Verify(doc []byte) (*Attestation, error)
+}
+
+// Attestation 证明文档中握手关心的内容
+type Attestation struct {
+	ModuleID  string
+	Timestamp time.Time
+	PCRs      map[uint32][]byte // 度量值, 下标 -> 摘要
+	PublicKey []byte
+	Nonce     []byte
+}
+
+// checkAttestation 校验绑定关系和度量值策略
+func checkAttestation(cfg *Config, doc, publicKey, nonce []byte) (*Attestation, error) {
+	if len(doc) == 0 {
+		return nil, errors.ErrAttestationMissing
+	}
+	att, err := cfg.Verifier.Verify(doc)
+	if err != nil {
+		return nil, errors.Wrap(errors.ErrAttestation, err)
+	}
+	if !bytes.Equal(att.PublicKey, publicKey) || !bytes.Equal(att.Nonce, nonce) {
+		return nil, errors.ErrAttestationNotBound
+	}
+	for index, want := range cfg.PCRs {
+		if got, ok := att.PCRs[index]; !ok || !bytes.Equal(got, want) {
+			return nil, errors.ErrMeasurementMismatch
+		}
+	}
+	if cfg.VerifyAttestation != nil {
+		if err = cfg.VerifyAttestation(att); err != nil {
+			return nil, err
+		}
+	}
+	return att, nil
+}
@@ -0,0 +1,138 @@
+package secure
+
+import (
+	"crypto"
+	"crypto/cipher"
+	"encoding/binary"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"io"
+	"net"
+	"sync"
+)
+
+const (
+	maxPlaintext  = 16 << 10 // 每条记录最多携带的明文
+	recordHeader  = 4        // 密文长度
+	maxCiphertext = maxPlaintext + 16
+)
+
+// Conn 握手完成后的安全连接, 实现 net.Conn.
+// 每次 Write(bufio 的一次 flush, 通常是一个或多个完整的帧)被封装成一条或多条 AES-GCM 记录:
+// [密文长度 uint32][密文+tag], 长度作为附加数据参与认证.
+// nonce 是每个方向独立递增的序号, 不在线上传输: 被重放、重排或丢弃的记录都无法通过认证, 连接随即失效.
+type Conn struct {
+	net.Conn
+
+	peerKey     crypto.PublicKey // 对端身份公钥, 对端没有身份时为 nil
+	attestation *Attestation     // client: 校验通过的 server 证明文档
+
+	readMutex sync.Mutex
+	reader    cipher.AEAD
+	readSeq   uint64
+	readErr   error
+	recordBuf []byte
+	plain     []byte // 已解密未读取
+
+	writeMutex sync.Mutex
+	writer     cipher.AEAD
+	writeSeq   uint64
+	writeErr   error
+	outBuf     []byte
+}
+
+// PeerKey 对端的静态身份公钥(ed25519.PublicKey, *ecdsa.PublicKey 等), 对端没有身份时为 nil
+func (c *Conn) PeerKey() crypto.PublicKey {
+	return c.peerKey
+}
+
+// Attestation client 端校验通过的 server 证明文档, 没有配置 Verifier 时为 nil
+func (c *Conn) Attestation() *Attestation {
+	return c.attestation
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+
+	for len(c.plain) == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		if err := c.readRecord(); err != nil {
+			c.readErr = err
+			return 0, err
+		}
+	}
+	n := copy(p, c.plain)
+	c.plain = c.plain[n:]
+	return n, nil
+}
+
+func (c *Conn) readRecord() error {
+	var header [recordHeader]byte
+	if _, err := io.ReadFull(c.Conn, header[:]); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxCiphertext {
+		return errors.ErrRecordTooLarge
+	}
+
+	if cap(c.recordBuf) < int(size) {
+		c.recordBuf = make([]byte, maxCiphertext)
+	}
+	record := c.recordBuf[:size]
+	if _, err := io.ReadFull(c.Conn, record); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	plain, err := c.reader.Open(record[:0], nonce(c.readSeq), record, header[:])
+	if err != nil {
+		return errors.ErrRecordAuth
+	}
+	c.readSeq++
+	c.plain = plain
+	return nil
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if c.writeErr != nil {
+		return 0, c.writeErr
+	}
+
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxPlaintext {
+			chunk = chunk[:maxPlaintext]
+		}
+
+		size := len(chunk) + c.writer.Overhead()
+		out := append(c.outBuf[:0], make([]byte, recordHeader)...)
+		binary.BigEndian.PutUint32(out, uint32(size))
+		out = c.writer.Seal(out, nonce(c.writeSeq), chunk, out[:recordHeader])
+		c.writeSeq++
+		c.outBuf = out
+
+		// 写失败后序号已经不同步, 连接不能再用
+		if _, err := c.Conn.Write(out); err != nil {
+			c.writeErr = err
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+func nonce(seq uint64) []byte {
+	var n [12]byte
+	binary.BigEndian.PutUint64(n[4:], seq)
+	return n[:]
+}
@@ -0,0 +1,67 @@
+package secure
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"time"
+)
+
+// FakeAttester 软件实现的 Attester, 用 ed25519 签名代替硬件证明, 不在 enclave 中也能运行.
+// 只用于测试和开发环境: 任何持有签名密钥的进程都能伪造文档
+type FakeAttester struct {
+	ModuleID string
+	PCRs     map[uint32][]byte
+
+	key ed25519.PrivateKey
+}
+
+// fakeDocument FakeAttester 的文档格式, Payload 为 Attestation 的 json
+type fakeDocument struct {
+	Payload   []byte
+	Signature []byte
+}
+
+func NewFakeAttester(moduleID string, pcrs map[uint32][]byte) *FakeAttester {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	return &FakeAttester{ModuleID: moduleID, PCRs: pcrs, key: key}
+}
+
+func (a *FakeAttester) Attest(publicKey, nonce []byte) ([]byte, error) {
+	payload, err := json.Marshal(&Attestation{
+		ModuleID:  a.ModuleID,
+		Timestamp: time.Now(),
+		PCRs:      a.PCRs,
+		PublicKey: publicKey,
+		Nonce:     nonce,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&fakeDocument{Payload: payload, Signature: ed25519.Sign(a.key, payload)})
+}
+
+// Verifier 只信任该 FakeAttester 签发的文档
+func (a *FakeAttester) Verifier() Verifier {
+	return fakeVerifier{key: a.key.Public().(ed25519.PublicKey)}
+}
+
+type fakeVerifier struct {
+	key ed25519.PublicKey
+}
+
+func (v fakeVerifier) Verify(doc []byte) (*Attestation, error) {
+	var fake fakeDocument
+	if err := json.Unmarshal(doc, &fake); err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(v.key, fake.Payload, fake.Signature) {
+		return nil, errors.ErrAttestation
+	}
+	att := &Attestation{}
+	if err := json.Unmarshal(fake.Payload, att); err != nil {
+		return nil, err
+	}
+	return att, nil
+}
@@ -0,0 +1,334 @@
+package secure
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/protocols"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net"
+)
+
+// Config 安全通道的配置, client 和 server 使用同一结构.
+//
+// 握手: client 发送 X25519 临时公钥; server 回复自己的临时公钥、静态身份公钥和对握手摘要的签名;
+// 双方由 ECDH 共享密钥和握手摘要派生两个方向的 AES-256-GCM 密钥;
+// client 再以第一条加密记录发送自己的身份公钥和签名. 身份密钥只用于签名, 每条连接的会话密钥都是临时的.
+//
+// 配置了 Attester 的 server 在回复中附带证明文档, 文档绑定 server 的临时公钥和 client 的临时公钥(nonce),
+// 所以 client 校验文档后可以确认会话密钥只有被证明的 enclave 持有.
+type Config struct {
+	// Identity 本端的静态身份密钥, 支持 ed25519, ecdsa 和 rsa, 可以是 HSM 等外部实现; nil 时不证明身份
+	Identity crypto.Signer
+
+	// PeerKeys 信任的对端身份公钥(pinning), 对端必须使用其中之一; 为空时不限制
+	PeerKeys []crypto.PublicKey
+
+	// VerifyPeer 可选的自定义校验, 在 PeerKeys 校验之后调用, key 在对端没有身份时为 nil
+	VerifyPeer func(key crypto.PublicKey) error
+
+	// Attester server: 在握手中附带绑定临时公钥的证明文档; nil 时不发送
+	Attester Attester
+
+	// Verifier client: 非 nil 时要求 server 提供证明文档并校验, 文档必须绑定本次连接的临时公钥
+	Verifier Verifier
+
+	// PCRs client: 期望的度量值, 文档中对应下标的值必须相同; 未列出的下标不检查.
+	// 配置了 Verifier 时 PCRs 和 VerifyAttestation 至少要有一个, 否则任何被证明的 enclave 都会被接受
+	PCRs map[uint32][]byte
+
+	// VerifyAttestation client: 可选的自定义校验, 在度量值校验之后调用
+	VerifyAttestation func(att *Attestation) error
+}
+
+func (cfg *Config) validate() error {
+	if cfg.Verifier != nil && len(cfg.PCRs) == 0 && cfg.VerifyAttestation == nil {
+		return errors.ErrAttestationPolicy
+	}
+	return nil
+}
+
+const (
+	maxHandshakeMessage = 32 << 10 // 证明文档含证书链, 通常不超过 16K
+
+	labelTranscript = "vsock-sdk secure v1"
+	labelServer     = "vsock-sdk secure v1 server signature"
+	labelClient     = "vsock-sdk secure v1 client signature"
+)
+
+// Client 在 conn 上以 client 身份握手, 调用方负责设置超时
+func Client(conn net.Conn, cfg *Config) (*Conn, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	hello, _ := proto.Marshal(&protocols.SecureHello{Ephemeral: ephemeral.PublicKey().Bytes()})
+	if err = writeMessage(conn, hello); err != nil {
+		return nil, err
+	}
+
+	var serverHello protocols.SecureHello
+	if err = readMessage(conn, &serverHello); err != nil {
+		return nil, err
+	}
+
+	transcript := transcriptHash(ephemeral.PublicKey().Bytes(), serverHello.Ephemeral, serverHello.Identity)
+	serverKey, err := verifyIdentity(cfg, labelServer, transcript, serverHello.Identity, serverHello.Signature)
+	if err != nil {
+		return nil, err
+	}
+	var att *Attestation
+	if cfg.Verifier != nil {
+		att, err = checkAttestation(cfg, serverHello.Attestation, serverHello.Ephemeral, ephemeral.PublicKey().Bytes())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c2s, s2c, err := deriveKeys(ephemeral, serverHello.Ephemeral, transcript)
+	if err != nil {
+		return nil, err
+	}
+	sc := &Conn{Conn: conn, peerKey: serverKey, attestation: att, writer: c2s, reader: s2c}
+
+	identity, signature, err := signIdentity(cfg, labelClient, transcript)
+	if err != nil {
+		return nil, err
+	}
+	finish, _ := proto.Marshal(&protocols.SecureFinish{Identity: identity, Signature: signature})
+	if err = writeMessage(sc, finish); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// Server 在 conn 上以 server 身份握手, 调用方负责设置超时
+func Server(conn net.Conn, cfg *Config) (*Conn, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	var hello protocols.SecureHello
+	if err := readMessage(conn, &hello); err != nil {
+		return nil, err
+	}
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := marshalIdentity(cfg)
+	if err != nil {
+		return nil, err
+	}
+	transcript := transcriptHash(hello.Ephemeral, ephemeral.PublicKey().Bytes(), identity)
+	_, signature, err := signIdentity(cfg, labelServer, transcript)
+	if err != nil {
+		return nil, err
+	}
+
+	c2s, s2c, err := deriveKeys(ephemeral, hello.Ephemeral, transcript)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc []byte
+	if cfg.Attester != nil {
+		if doc, err = cfg.Attester.Attest(ephemeral.PublicKey().Bytes(), hello.Ephemeral); err != nil {
+			return nil, errors.Wrap(errors.ErrAttestation, err)
+		}
+	}
+
+	serverHello, _ := proto.Marshal(&protocols.SecureHello{
+		Ephemeral:   ephemeral.PublicKey().Bytes(),
+		Identity:    identity,
+		Signature:   signature,
+		Attestation: doc,
+	})
+	if err = writeMessage(conn, serverHello); err != nil {
+		return nil, err
+	}
+
+	sc := &Conn{Conn: conn, writer: s2c, reader: c2s}
+	var finish protocols.SecureFinish
+	if err = readMessage(sc, &finish); err != nil {
+		return nil, err
+	}
+	if sc.peerKey, err = verifyIdentity(cfg, labelClient, transcript, finish.Identity, finish.Signature); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+func transcriptHash(clientEphemeral, serverEphemeral, serverIdentity []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(labelTranscript))
+	for _, b := range [][]byte{clientEphemeral, serverEphemeral, serverIdentity} {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(b)))
+		h.Write(size[:])
+		h.Write(b)
+	}
+	return h.Sum(nil)
+}
+
+// deriveKeys HKDF-SHA256: 以握手摘要为 salt 提取, 再分别扩展出两个方向的密钥
+func deriveKeys(private *ecdh.PrivateKey, peerPublic, transcript []byte) (c2s, s2c cipher.AEAD, err error) {
+	peer, err := ecdh.X25519().NewPublicKey(peerPublic)
+	if err != nil {
+		return nil, nil, errors.ErrSecureHandshake
+	}
+	shared, err := private.ECDH(peer)
+	if err != nil {
+		return nil, nil, errors.ErrSecureHandshake
+	}
+
+	prk := hmacSum(transcript, shared)
+	if c2s, err = newAEAD(hmacSum(prk, []byte("c2s key\x01"))); err != nil {
+		return nil, nil, err
+	}
+	if s2c, err = newAEAD(hmacSum(prk, []byte("s2c key\x01"))); err != nil {
+		return nil, nil, err
+	}
+	return c2s, s2c, nil
+}
+
+func hmacSum(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func marshalIdentity(cfg *Config) ([]byte, error) {
+	if cfg.Identity == nil {
+		return nil, nil
+	}
+	return x509.MarshalPKIXPublicKey(cfg.Identity.Public())
+}
+
+// signIdentity 没有身份时返回空的公钥和签名
+func signIdentity(cfg *Config, label string, transcript []byte) ([]byte, []byte, error) {
+	if cfg.Identity == nil {
+		return nil, nil, nil
+	}
+	identity, err := marshalIdentity(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	digest := signedDigest(label, transcript)
+	var opts crypto.SignerOpts = crypto.SHA256
+	if _, ok := cfg.Identity.Public().(ed25519.PublicKey); ok {
+		opts = crypto.Hash(0)
+	}
+	signature, err := cfg.Identity.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return identity, signature, nil
+}
+
+// verifyIdentity 校验签名和 pinning, 返回对端的身份公钥
+func verifyIdentity(cfg *Config, label string, transcript, identity, signature []byte) (crypto.PublicKey, error) {
+	var key crypto.PublicKey
+	if len(identity) > 0 {
+		var err error
+		if key, err = x509.ParsePKIXPublicKey(identity); err != nil {
+			return nil, errors.ErrSecureHandshake
+		}
+
+		digest := signedDigest(label, transcript)
+		valid := false
+		switch pub := key.(type) {
+		case ed25519.PublicKey:
+			valid = ed25519.Verify(pub, digest, signature)
+		case *ecdsa.PublicKey:
+			valid = ecdsa.VerifyASN1(pub, digest, signature)
+		case *rsa.PublicKey:
+			valid = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, signature) == nil
+		}
+		if !valid {
+			return nil, errors.ErrSecureHandshake
+		}
+	}
+
+	if len(cfg.PeerKeys) > 0 && !pinned(cfg.PeerKeys, identity) {
+		return nil, errors.ErrPeerNotTrusted
+	}
+	if cfg.VerifyPeer != nil {
+		if err := cfg.VerifyPeer(key); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+func pinned(keys []crypto.PublicKey, identity []byte) bool {
+	if len(identity) == 0 {
+		return false
+	}
+	for _, key := range keys {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err == nil && bytes.Equal(der, identity) {
+			return true
+		}
+	}
+	return false
+}
+
+func signedDigest(label string, transcript []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(label))
+	h.Write(transcript)
+	return h.Sum(nil)
+}
+
+func writeMessage(w io.Writer, msg []byte) error {
+	buf := make([]byte, 4+len(msg))
+	binary.BigEndian.PutUint32(buf, uint32(len(msg)))
+	copy(buf[4:], msg)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readMessage(r io.Reader, m proto.Message) error {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxHandshakeMessage {
+		return errors.ErrSecureHandshake
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return err
+	}
+	if err := proto.Unmarshal(buf, m); err != nil {
+		return errors.ErrSecureHandshake
+	}
+	return nil
+}
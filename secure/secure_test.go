@@ -0,0 +1,176 @@
+package secure
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// handshake 在 net.Pipe 上同时运行两端的握手
+func handshake(clientCfg, serverCfg *Config) (*Conn, *Conn, error, error) {
+	c, s := net.Pipe()
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		sc, err := Server(s, serverCfg)
+		if err != nil {
+			_ = s.Close()
+		}
+		done <- result{sc, err}
+	}()
+	cc, err := Client(c, clientCfg)
+	if err != nil {
+		_ = c.Close()
+	}
+	r := <-done
+	return cc, r.conn, err, r.err
+}
+
+func TestHandshakeAndPinning(t *testing.T) {
+	_, serverKey, _ := ed25519.GenerateKey(rand.Reader)
+	clientKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	clientCfg := &Config{Identity: clientKey, PeerKeys: []crypto.PublicKey{serverKey.Public()}}
+	serverCfg := &Config{Identity: serverKey, PeerKeys: []crypto.PublicKey{clientKey.Public()}}
+	cc, sc, cerr, serr := handshake(clientCfg, serverCfg)
+	if cerr != nil || serr != nil {
+		t.Fatal(cerr, serr)
+	}
+	if !serverKey.Public().(ed25519.PublicKey).Equal(cc.PeerKey()) || !clientKey.PublicKey.Equal(sc.PeerKey()) {
+		t.Fatal("peer keys")
+	}
+
+	// 超过一条记录的写入被拆分
+	big := bytes.Repeat([]byte("x"), maxPlaintext*2+10)
+	go func() {
+		_, _ = cc.Write(big)
+	}()
+	got := make([]byte, len(big))
+	if _, err := io.ReadFull(sc, got); err != nil || !bytes.Equal(got, big) {
+		t.Fatalf("read: %v", err)
+	}
+	_ = cc.Close()
+
+	// server 不在 client 的信任列表中
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, _, cerr, _ = handshake(clientCfg, &Config{Identity: otherKey})
+	if cerr != errors.ErrPeerNotTrusted {
+		t.Fatalf("untrusted server: %v", cerr)
+	}
+
+	// 匿名 client 不能连接要求身份的 server
+	_, _, _, serr = handshake(&Config{}, serverCfg)
+	if serr != errors.ErrPeerNotTrusted {
+		t.Fatalf("anonymous client: %v", serr)
+	}
+}
+
+// bufConn 把写入的记录保存下来, 用于构造篡改和重放
+type bufConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *bufConn) Read(p []byte) (int, error)  { return c.buf.Read(p) }
+func (c *bufConn) Write(p []byte) (int, error) { return c.buf.Write(p) }
+
+func TestRecordTamperAndReplay(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	aead, _ := newAEAD(key)
+
+	out := &bufConn{}
+	writer := &Conn{Conn: out, writer: aead}
+	_, _ = writer.Write([]byte("first"))
+	first := append([]byte(nil), out.buf.Bytes()...)
+	_, _ = writer.Write([]byte("second"))
+	records := append([]byte(nil), out.buf.Bytes()...)
+
+	read := func(data []byte) ([]byte, error) {
+		reader := &Conn{Conn: &bufConn{}, reader: aead}
+		reader.Conn.(*bufConn).buf.Write(data)
+		var got []byte
+		buf := make([]byte, 64)
+		for {
+			n, err := reader.Read(buf)
+			got = append(got, buf[:n]...)
+			if err != nil {
+				return got, err
+			}
+		}
+	}
+
+	if got, err := read(records); err != io.EOF || string(got) != "firstsecond" {
+		t.Fatalf("records: %q %v", got, err)
+	}
+
+	tampered := append([]byte(nil), records...)
+	tampered[len(first)+recordHeader+1] ^= 1
+	if got, err := read(tampered); err != errors.ErrRecordAuth || string(got) != "first" {
+		t.Fatalf("tampered: %q %v", got, err)
+	}
+
+	// 同一条记录再出现一次, 序号对不上
+	replayed := append(append([]byte(nil), first...), first...)
+	if got, err := read(replayed); err != errors.ErrRecordAuth || string(got) != "first" {
+		t.Fatalf("replayed: %q %v", got, err)
+	}
+}
+
+// replayAttester 总是返回同一份文档, 模拟转发其它连接的证明
+type replayAttester []byte
+
+func (a replayAttester) Attest(publicKey, nonce []byte) ([]byte, error) {
+	return a, nil
+}
+
+func TestAttestation(t *testing.T) {
+	pcrs := map[uint32][]byte{0: bytes.Repeat([]byte{1}, 48), 8: bytes.Repeat([]byte{2}, 48)}
+	attester := NewFakeAttester("enclave-1", pcrs)
+	clientCfg := &Config{Verifier: attester.Verifier(), PCRs: map[uint32][]byte{0: pcrs[0]}}
+
+	cc, _, cerr, serr := handshake(clientCfg, &Config{Attester: attester})
+	if cerr != nil || serr != nil {
+		t.Fatal(cerr, serr)
+	}
+	if att := cc.Attestation(); att == nil || att.ModuleID != "enclave-1" || !bytes.Equal(att.PCRs[8], pcrs[8]) {
+		t.Fatalf("attestation: %+v", att)
+	}
+
+	if _, _, cerr, _ = handshake(clientCfg, &Config{}); cerr != errors.ErrAttestationMissing {
+		t.Fatalf("missing: %v", cerr)
+	}
+
+	other := NewFakeAttester("enclave-1", pcrs)
+	if _, _, cerr, _ = handshake(clientCfg, &Config{Attester: other}); cerr == nil {
+		t.Fatal("untrusted attester accepted")
+	}
+
+	mismatch := &Config{Verifier: attester.Verifier(), PCRs: map[uint32][]byte{0: pcrs[8]}}
+	if _, _, cerr, _ = handshake(mismatch, &Config{Attester: attester}); cerr != errors.ErrMeasurementMismatch {
+		t.Fatalf("mismatch: %v", cerr)
+	}
+
+	// 没有度量值策略的 Verifier 会接受任何被证明的 enclave
+	if _, _, cerr, _ = handshake(&Config{Verifier: attester.Verifier()}, &Config{Attester: attester}); cerr != errors.ErrAttestationPolicy {
+		t.Fatalf("no policy: %v", cerr)
+	}
+	custom := &Config{Verifier: attester.Verifier(), VerifyAttestation: func(att *Attestation) error { return nil }}
+	if _, _, cerr, serr := handshake(custom, &Config{Attester: attester}); cerr != nil || serr != nil {
+		t.Fatalf("custom policy: %v %v", cerr, serr)
+	}
+
+	doc, _ := attester.Attest([]byte("old key"), []byte("old nonce"))
+	if _, _, cerr, _ = handshake(clientCfg, &Config{Attester: replayAttester(doc)}); cerr != errors.ErrAttestationNotBound {
+		t.Fatalf("replayed: %v", cerr)
+	}
+}
@@ -0,0 +1,87 @@
+package vsock_sdk
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/brodyxchen/vsock-sdk/client"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/secure"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientSecureChannel(t *testing.T) {
+	_, serverKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, clientKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	addr := &models.HttpAddr{IP: "127.0.0.1", Port: 7079}
+	srv := NewServer(addr)
+	srv.Secure = &secure.Config{Identity: serverKey, PeerKeys: []crypto.PublicKey{clientKey.Public()}}
+	srv.HandleFunc("echo", func(req []byte) ([]byte, error) {
+		return req, nil
+	})
+	go func() {
+		_ = srv.ListenAndServe()
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	cli := NewClient(&client.Config{
+		Timeout:        time.Second,
+		EnableChecksum: true,
+		Secure:         &secure.Config{Identity: clientKey, PeerKeys: []crypto.PublicKey{serverKey.Public()}},
+	})
+	for i := 0; i < 3; i++ {
+		rsp, err := cli.Do(addr, "echo", []byte("secret"))
+		if err != nil || string(rsp) != "secret" {
+			t.Fatalf("secure echo: %q %v", rsp, err)
+		}
+	}
+
+	// 明文 client 和身份不受信任的 client 都被拒绝
+	plain := NewClient(&client.Config{Timeout: time.Second})
+	if _, err := plain.Do(addr, "echo", []byte("secret")); err == nil {
+		t.Fatal("plaintext client accepted")
+	}
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	other := NewClient(&client.Config{Timeout: time.Second, Secure: &secure.Config{Identity: otherKey}})
+	if _, err := other.Do(addr, "echo", []byte("secret")); err == nil {
+		t.Fatal("untrusted client accepted")
+	}
+}
+
+func TestServerSecureHandshakePanic(t *testing.T) {
+	_, serverKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, clientKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	var panicked int32
+	addr := &models.HttpAddr{IP: "127.0.0.1", Port: 7111}
+	srv := NewServer(addr)
+	srv.Secure = &secure.Config{Identity: serverKey, VerifyPeer: func(key crypto.PublicKey) error {
+		if atomic.CompareAndSwapInt32(&panicked, 0, 1) {
+			panic("verify peer")
+		}
+		return nil
+	}}
+	srv.HandleFunc("echo", func(req []byte) ([]byte, error) {
+		return req, nil
+	})
+	go func() {
+		_ = srv.ListenAndServe()
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// 握手中的 panic 只关闭这条连接, server 继续服务
+	cli := NewClient(&client.Config{
+		Timeout: time.Second,
+		Secure:  &secure.Config{Identity: clientKey, PeerKeys: []crypto.PublicKey{serverKey.Public()}},
+	})
+	_, _ = cli.Do(addr, "echo", []byte("first"))
+	if atomic.LoadInt32(&panicked) != 1 {
+		t.Fatal("verifier not called")
+	}
+	if rsp, err := cli.Do(addr, "echo", []byte("secret")); err != nil || string(rsp) != "secret" {
+		t.Fatalf("after panic: %q %v", rsp, err)
+	}
+}
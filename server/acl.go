@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/log"
+	"github.com/mdlayher/vsock"
+	"net"
+	"strings"
+)
+
+// ACL 按对端地址限制访问. 每个字段为空时不限制, 只检查与对端地址类型相关的字段:
+// vsock 对端检查 CIDs 和 Ports, tcp 对端检查 CIDRs
+type ACL struct {
+	CIDs  []uint32 // vsock: 允许的 CID, 例如父实例为 3
+	Ports []uint32 // vsock: 允许的对端端口
+	CIDRs []string // tcp: 允许的网段, 例如 "10.0.0.0/8", "::1/128"
+
+	nets []*net.IPNet // CIDRs 解析后的结果
+}
+
+// compile 解析 CIDRs, 在 Serve 开始时调用
+func (acl *ACL) compile() error {
+	acl.nets = acl.nets[:0]
+	for _, cidr := range acl.CIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+		acl.nets = append(acl.nets, ipNet)
+	}
+	return nil
+}
+
+func (acl *ACL) allow(addr net.Addr) bool {
+	switch ad := addr.(type) {
+	case *vsock.Addr:
+		return (len(acl.CIDs) == 0 || containsUint32(acl.CIDs, ad.ContextID)) &&
+			(len(acl.Ports) == 0 || containsUint32(acl.Ports, ad.Port))
+	case *net.TCPAddr:
+		if len(acl.nets) == 0 {
+			return true
+		}
+		for _, ipNet := range acl.nets {
+			if ipNet.Contains(ad.IP) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func containsUint32(values []uint32, v uint32) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Rule 路由级的授权规则. Server.Rules 中第一条 Path 匹配的规则决定是否允许, 所有非空的条件都要满足
+type Rule struct {
+	Path string // 精确匹配; 以 "*" 结尾时为前缀匹配, "*" 匹配所有路由
+
+	ACL                 // 对端地址
+	Principals []string // 允许的 principal(见 Authenticator), 为空不限制
+
+	// Allow 可选的自定义检查, 在以上条件都满足后调用
+	Allow func(peer *Peer, principal string) bool
+}
+
+func (r *Rule) match(path string) bool {
+	if strings.HasSuffix(r.Path, "*") {
+		return strings.HasPrefix(path, r.Path[:len(r.Path)-1])
+	}
+	return r.Path == path
+}
+
+func (r *Rule) allow(peer *Peer, principal string) bool {
+	if peer != nil && !r.ACL.allow(peer.Addr) {
+		return false
+	}
+	if len(r.Principals) > 0 {
+		found := false
+		for _, p := range r.Principals {
+			if p == principal {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return r.Allow == nil || r.Allow(peer, principal)
+}
+
+// compilePolicy 解析 ACL 和 Rules 中的网段
+func (srv *Server) compilePolicy() error {
+	if srv.ACL != nil {
+		if err := srv.ACL.compile(); err != nil {
+			return err
+		}
+	}
+	for i := range srv.Rules {
+		if err := srv.Rules[i].ACL.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// acceptConn Accept 之后的连接级检查, 被拒绝的连接由调用方关闭
+func (srv *Server) acceptConn(addr net.Addr) bool {
+	if srv.ACL == nil || srv.ACL.allow(addr) {
+		return true
+	}
+	srv.aclDenyCounter.Inc(1)
+	srv.deniedPeers.Inc(peerKey(addr))
+	log.Errorf("acl deny connection from %v\n", addr)
+	return false
+}
+
+// authorize 按 Server.Rules 检查路由, 在认证之后调用
+func (c *Conn) authorize(ctx context.Context, path string) error {
+	for i := range c.server.Rules {
+		rule := &c.server.Rules[i]
+		if !rule.match(path) {
+			continue
+		}
+		if rule.allow(PeerFromContext(ctx), PrincipalFromContext(ctx)) {
+			return nil
+		}
+		return c.deny(path, PrincipalFromContext(ctx))
+	}
+	if c.server.DefaultDeny {
+		return c.deny(path, PrincipalFromContext(ctx))
+	}
+	return nil
+}
+
+func (c *Conn) deny(path, principal string) error {
+	c.server.authzDenyCounter.Inc(1)
+	c.server.deniedPeers.Inc(c.peer)
+	log.Errorf("deny %v from %v principal %q\n", path, c.remoteAddr, principal)
+	return errors.StatusPermissionDenied
+}
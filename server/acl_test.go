@@ -0,0 +1,55 @@
+package server
+
+import (
+	"github.com/mdlayher/vsock"
+	"net"
+	"testing"
+)
+
+func TestACL(t *testing.T) {
+	acl := &ACL{CIDs: []uint32{3, 16}, Ports: []uint32{5000}, CIDRs: []string{"10.0.0.0/8", "::1/128"}}
+	if err := acl.compile(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		addr  net.Addr
+		allow bool
+	}{
+		{&vsock.Addr{ContextID: 3, Port: 5000}, true},
+		{&vsock.Addr{ContextID: 16, Port: 5000}, true},
+		{&vsock.Addr{ContextID: 17, Port: 5000}, false},
+		{&vsock.Addr{ContextID: 3, Port: 5001}, false},
+		{&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 80}, true},
+		{&net.TCPAddr{IP: net.ParseIP("::1"), Port: 80}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 80}, false},
+	} {
+		if got := acl.allow(c.addr); got != c.allow {
+			t.Errorf("allow(%v) = %v", c.addr, got)
+		}
+	}
+
+	if err := (&ACL{CIDRs: []string{"10.0.0.0"}}).compile(); err == nil {
+		t.Fatal("invalid cidr accepted")
+	}
+}
+
+func TestRule(t *testing.T) {
+	parent := &Peer{Addr: &vsock.Addr{ContextID: 3, Port: 1}}
+	sibling := &Peer{Addr: &vsock.Addr{ContextID: 16, Port: 1}}
+
+	rule := &Rule{Path: "admin/*", ACL: ACL{CIDs: []uint32{3}}, Principals: []string{"ops"}}
+	if !rule.match("admin/reload") || !rule.match("admin/") || rule.match("admin") || rule.match("user/get") {
+		t.Fatal("prefix match")
+	}
+	if !(&Rule{Path: "*"}).match("anything") || !(&Rule{Path: "get"}).match("get") || (&Rule{Path: "get"}).match("get2") {
+		t.Fatal("match")
+	}
+
+	if !rule.allow(parent, "ops") || rule.allow(parent, "dev") || rule.allow(sibling, "ops") {
+		t.Fatal("allow")
+	}
+	rule.Allow = func(peer *Peer, principal string) bool { return false }
+	if rule.allow(parent, "ops") {
+		t.Fatal("custom check ignored")
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/protocols"
+	"time"
+)
+
+// AuditRecord 一次请求的审计记录. 请求在认证或授权阶段被拒绝时同样记录
+type AuditRecord struct {
+	Time      time.Time     `json:"time"` // 开始处理的时间
+	Kind      string        `json:"kind"` // call, notify, batch(子请求), stream
+	Peer      string        `json:"peer"` // 对端地址
+	Principal string        `json:"principal,omitempty"`
+	Path      string        `json:"path"`
+	Code      int32         `json:"code"` // protocols.StatusOK, protocols.StatusErr 或拒绝时的 Status 码
+	Error     string        `json:"error,omitempty"`
+	Latency   time.Duration `json:"latency_ns"`
+
+	RequestSize   int    `json:"req_size"`
+	ResponseSize  int    `json:"rsp_size"`
+	RequestDigest string `json:"req_sha256"` // 请求 body 的 SHA-256, hex
+}
+
+// AuditSink 接收审计记录, 在处理请求的 goroutine 中同步调用, 实现需要并发安全且尽快返回.
+// rec 在调用返回后不再被使用. 内置的文件实现见 audit 包
+type AuditSink interface {
+	Audit(rec *AuditRecord)
+}
+
+const (
+	auditCall   = "call"
+	auditNotify = "notify"
+	auditBatch  = "batch"
+	auditStream = "stream"
+)
+
+// audit 没有配置 AuditSink 时什么都不做
+func (c *Conn) audit(ctx context.Context, kind, path string, req []byte, start time.Time, code int32, errMsg string, rspSize int) {
+	if c.server.AuditSink == nil {
+		return
+	}
+	digest := sha256.Sum256(req)
+	c.server.AuditSink.Audit(&AuditRecord{
+		Time:          start,
+		Kind:          kind,
+		Peer:          c.remoteAddr,
+		Principal:     PrincipalFromContext(ctx),
+		Path:          path,
+		Code:          code,
+		Error:         errMsg,
+		Latency:       time.Since(start),
+		RequestSize:   len(req),
+		ResponseSize:  rspSize,
+		RequestDigest: hex.EncodeToString(digest[:]),
+	})
+}
+
+// handlerResult handler 的错误都以 StatusErr 回复
+func handlerResult(err error) (int32, string) {
+	if err != nil {
+		return protocols.StatusErr, err.Error()
+	}
+	return protocols.StatusOK, ""
+}
+
+// statusResult 分发前被拒绝(认证, 授权, path 不存在)时回复的 Status
+func statusResult(status error) (int32, string) {
+	if st, ok := status.(*errors.Status); ok {
+		return int32(st.Code()), st.Error()
+	}
+	return protocols.StatusErr, status.Error()
+}
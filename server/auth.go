@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/log"
+)
+
+// Authenticator 校验请求附带的认证数据(client.Credentials 生成), 返回调用方的身份(principal).
+// body 为请求的 body, 流为 nil; ctx 中有连接对端的信息. 内置实现见 auth 包
+type Authenticator interface {
+	Authenticate(ctx context.Context, path string, body, credential []byte) (string, error)
+}
+
+type principalContextKey struct{}
+
+// PrincipalFromContext 认证通过的调用方身份, 没有开启认证时为空
+func PrincipalFromContext(ctx context.Context) string {
+	principal, _ := ctx.Value(principalContextKey{}).(string)
+	return principal
+}
+
+// authenticate 返回带有 principal 的 ctx; 失败的原因只记录日志, 不回复给对端
+func (c *Conn) authenticate(ctx context.Context, path string, body, credential []byte) (context.Context, error) {
+	if c.server.Authenticator == nil {
+		return ctx, nil
+	}
+	principal, err := c.server.Authenticator.Authenticate(ctx, path, body, credential)
+	if err != nil {
+		c.server.authFailCounter.Inc(1)
+		log.Errorf("authenticate %v from %v: %v\n", path, c.remoteAddr, err)
+		return ctx, errors.StatusUnauthenticated
+	}
+	return context.WithValue(ctx, principalContextKey{}, principal), nil
+}
+
+// admit 分发前的认证, 授权和限流
+func (c *Conn) admit(ctx context.Context, path string, body, credential []byte) (context.Context, error) {
+	ctx, err := c.authenticate(ctx, path, body, credential)
+	if err != nil {
+		return ctx, err
+	}
+	if err = c.authorize(ctx, path); err != nil {
+		return ctx, err
+	}
+	return ctx, c.rateLimit(ctx, path)
+}
+
+// admitRequests 是否需要 admit, 不需要时热路径上不转换 path
+func (srv *Server) admitRequests() bool {
+	return srv.Authenticator != nil || len(srv.Rules) > 0 || srv.DefaultDeny || len(srv.RateLimits) > 0
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/log"
+	"github.com/brodyxchen/vsock-sdk/protocols"
+	"google.golang.org/protobuf/proto"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// handleBatch 子请求的错误放在各自的 Response 中, 只有整帧无法解析时返回 status
+func (c *Conn) handleBatch(ctx context.Context, body []byte) ([]byte, error) {
+	var batch protocols.BatchRequest
+	err := proto.Unmarshal(body, &batch)
+	if err != nil {
+		return nil, errors.StatusInvalidRequest
+	}
+
+	items := make([]*protocols.Response, len(batch.Items))
+
+	concurrency := c.server.BatchConcurrency
+	if concurrency <= 1 || len(batch.Items) <= 1 {
+		for i, item := range batch.Items {
+			items[i] = c.handleBatchItem(ctx, item)
+		}
+	} else {
+		sem := make(chan struct{}, concurrency)
+		wg := sync.WaitGroup{}
+		wg.Add(len(batch.Items))
+		for i, item := range batch.Items {
+			sem <- struct{}{}
+			go func(i int, item *protocols.Request) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				items[i] = c.handleBatchItem(ctx, item)
+			}(i, item)
+		}
+		wg.Wait()
+	}
+
+	rspBytes, err := proto.Marshal(&protocols.BatchResponse{Items: items})
+	if err != nil {
+		panic(err)
+	}
+	return rspBytes, nil
+}
+
+func (c *Conn) handleBatchItem(ctx context.Context, item *protocols.Request) (rsp *protocols.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			log.Errorf("http: panic serving batch %v: %v\n%s", c.remoteAddr, err, buf)
+
+			rsp = &protocols.Response{
+				Code: 500,
+				Err:  fmt.Sprintf("panic serving : %v", err),
+			}
+		}
+	}()
+
+	// 在 recover 之前执行, panic 时 rsp 还是 nil
+	if c.server.AuditSink != nil {
+		start := time.Now()
+		defer func() {
+			code, errMsg := int32(500), "panic"
+			if rsp != nil {
+				code, errMsg = rsp.Code, rsp.Err
+			}
+			c.audit(ctx, auditBatch, item.Path, item.Req, start, code, errMsg, len(rsp.GetRsp()))
+		}()
+	}
+
+	ctx, err := c.admit(ctx, item.Path, item.Req, item.Auth)
+	if err != nil {
+		st := err.(*errors.Status)
+		return &protocols.Response{
+			Code:       int32(st.Code()),
+			Err:        st.Error(),
+			RetryAfter: st.RetryAfterMillis(),
+		}
+	}
+
+	handler := c.server.getHandler(item.Path)
+	if handler == nil {
+		return &protocols.Response{
+			Code: int32(errors.StatusInvalidPath.Code()),
+			Err:  errors.StatusInvalidPath.Error(),
+		}
+	}
+
+	bytes, err := handler(ctx, item.Req)
+	if err != nil {
+		return &protocols.Response{
+			Code: protocols.StatusErr,
+			Err:  err.Error(),
+		}
+	}
+	return &protocols.Response{
+		Code: protocols.StatusOK,
+		Rsp:  bytes,
+	}
+}
@@ -3,16 +3,24 @@ package server
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"encoding/binary"
 	"fmt"
+	"github.com/brodyxchen/vsock-sdk/buffer"
+	"github.com/brodyxchen/vsock-sdk/compress"
 	"github.com/brodyxchen/vsock-sdk/constant"
 	"github.com/brodyxchen/vsock-sdk/errors"
 	"github.com/brodyxchen/vsock-sdk/log"
 	"github.com/brodyxchen/vsock-sdk/models"
 	"github.com/brodyxchen/vsock-sdk/protocols"
+	"github.com/brodyxchen/vsock-sdk/secure"
 	"github.com/brodyxchen/vsock-sdk/socket"
+	"github.com/brodyxchen/vsock-sdk/stream"
 	"google.golang.org/protobuf/proto"
+	"math"
 	"net"
 	"runtime"
+	"sync"
 	"time"
 )
 
@@ -20,10 +28,20 @@ type Conn struct {
 	Name       int64
 	server     *Server
 	remoteAddr string
+	peer       string // peerKey, 用于按对端统计
 
 	rwc       net.Conn
 	bufReader *bufio.Reader
 	bufWriter *bufio.Writer
+	coalescer *socket.Coalescer
+
+	protocol models.Protocol // 握手协商的结果, 旧版本 client 为 models.LegacyProtocol
+
+	pipeQueue chan *pipeItem // 流水线模式下等待写回的请求
+
+	pipeMutex    sync.Mutex // 守护以下两个变量
+	pipeInflight int        // 流水线中已读取还没有执行完的请求
+	pipeWaiting  bool       // 流水线的读循环在等待下一个请求
 }
 
 func (c *Conn) Read(p []byte) (n int, err error) {
@@ -34,48 +52,192 @@ func (c *Conn) Write(p []byte) (n int, err error) {
 	return c.rwc.Write(p)
 }
 
-func (c *Conn) handleServe(ctx context.Context, body []byte) ([]byte, error) {
-	wrap := func(bytes []byte, err error) []byte {
-		var rsp *protocols.Response
+// handleServe 消费 req(释放其引用), 返回待写出的回复帧
+func (c *Conn) handleServe(ctx context.Context, header *models.Header, req *buffer.Buffer) (rspFrame *buffer.Buffer, status error) {
+	release, ok := c.admitRequest()
+	if !ok {
+		req.Release()
+		return nil, errors.StatusResourceExhausted
+	}
+	if release != nil {
+		defer release()
+	}
+
+	req, err := c.decompress(header, req)
+	if err != nil {
+		log.Errorf("decompress request from %v: %v\n", c.remoteAddr, err)
+		return nil, errors.StatusInvalidRequest
+	}
+	defer req.Release()
+	action := header.Code
+
+	// handler 的结果, 审计用; handler panic 时保持初值
+	var (
+		rspCode int32 = 500
+		rspErr        = "panic"
+		rspSize int
+	)
+	wrap := func(bytes []byte, err error) *buffer.Buffer {
+		rspCode, rspErr = handlerResult(err)
+		rspSize = len(bytes)
 		if err != nil {
-			rsp = &protocols.Response{
-				Code: protocols.StatusErr,
-				Rsp:  nil,
-				Err:  err.Error(),
-			}
-		} else {
-			rsp = &protocols.Response{
-				Code: protocols.StatusOK,
-				Rsp:  bytes,
-				Err:  "",
+			errMsg := err.Error()
+			frame := socket.NewFrame(len(errMsg) + 16)
+			frame.B = protocols.AppendResponse(frame.B, protocols.StatusErr, nil, errMsg)
+			return frame
+		}
+		frame := socket.NewFrame(len(bytes) + 16)
+		frame.B = protocols.AppendResponse(frame.B, protocols.StatusOK, bytes, "")
+		return frame
+	}
+
+	var body []byte
+	if req != nil {
+		body = req.B
+	}
+
+	// 握手只能是连接上的第一帧
+	if action == constant.ActionHandshake {
+		return nil, errors.StatusInvalidRequest
+	}
+
+	if action == constant.ActionBatch {
+		batchRsp, status := c.handleBatch(ctx, body)
+		if status != nil {
+			return nil, status
+		}
+		return wrap(batchRsp, nil), nil
+	}
+
+	path, reqBody, auth, err := protocols.DecodeRequest(body)
+	if err != nil {
+		return nil, errors.StatusInvalidRequest
+	}
+	if c.server.AuditSink != nil {
+		start := time.Now()
+		defer func() {
+			if status != nil {
+				rspCode, rspErr = statusResult(status)
 			}
+			c.audit(ctx, auditCall, string(path), reqBody, start, rspCode, rspErr, rspSize)
+		}()
+	}
+	// 未认证的请求不能探测哪些 path 存在
+	if c.server.admitRequests() {
+		if ctx, err = c.admit(ctx, string(path), reqBody, auth); err != nil {
+			return nil, err
 		}
-		rspBytes, err := proto.Marshal(rsp)
-		if err != nil {
-			panic(err)
+	}
+
+	handler, bufHandler := c.server.lookupHandler(path)
+	if bufHandler != nil {
+		return wrap(bufHandler(reqBody, req)), nil
+	}
+	if handler == nil {
+		return nil, errors.StatusInvalidPath
+	}
+
+	// 普通 handler 可能持有请求, 给它一份独立的拷贝
+	if reqBody != nil {
+		reqBody = append([]byte(nil), reqBody...)
+	}
+	return wrap(handler(ctx, reqBody)), nil
+}
+
+// handleNotify 没有回复帧, 失败只记录日志和计数
+func (c *Conn) handleNotify(ctx context.Context, header *models.Header, req *buffer.Buffer) {
+	c.server.notifyCounter.Inc(1)
+
+	defer func() {
+		if err := recover(); err != nil {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			log.Errorf("http: panic serving notify %v: %v\n%s", c.remoteAddr, err, buf)
+			c.server.notifyFailCounter.Inc(1)
 		}
-		return rspBytes
+	}()
+
+	release, ok := c.admitRequest()
+	if !ok {
+		req.Release()
+		log.Errorf("notify from %v: %v\n", c.remoteAddr, errors.StatusResourceExhausted)
+		c.server.notifyFailCounter.Inc(1)
+		return
+	}
+	if release != nil {
+		defer release()
+	}
+
+	req, err := c.decompress(header, req)
+	if err != nil {
+		log.Errorf("decompress notify from %v: %v\n", c.remoteAddr, err)
+		c.server.notifyFailCounter.Inc(1)
+		return
 	}
 
 	var request protocols.Request
-	err := proto.Unmarshal(body, &request)
+	err = unmarshal(req, &request)
+	req.Release()
 	if err != nil {
-		return nil, errors.StatusInvalidRequest
+		log.Errorf("notify from %v: %v\n", c.remoteAddr, errors.StatusInvalidRequest)
+		c.server.notifyFailCounter.Inc(1)
+		return
+	}
+
+	code, errMsg := int32(500), "panic"
+	if c.server.AuditSink != nil {
+		start := time.Now()
+		defer func() {
+			c.audit(ctx, auditNotify, request.Path, request.Req, start, code, errMsg, 0)
+		}()
+	}
+
+	if ctx, err = c.admit(ctx, request.Path, request.Req, request.Auth); err != nil {
+		code, errMsg = statusResult(err)
+		c.server.notifyFailCounter.Inc(1)
+		return
 	}
 
 	handler := c.server.getHandler(request.Path)
 	if handler == nil {
-		return nil, errors.StatusInvalidPath
+		code, errMsg = statusResult(errors.StatusInvalidPath)
+		log.Errorf("notify from %v: %v %v\n", c.remoteAddr, errors.StatusInvalidPath, request.Path)
+		c.server.notifyFailCounter.Inc(1)
+		return
+	}
+
+	_, err = handler(ctx, request.Req)
+	code, errMsg = handlerResult(err)
+	if err != nil {
+		log.Debugf("notify from %v: %v\n", c.remoteAddr, err)
+		c.server.notifyFailCounter.Inc(1)
+	}
+}
+
+// waitNext 阻塞等待 下一份数据
+func (c *Conn) waitNext() error {
+	wait := c.server.idleTimeout()
+
+	if wait != 0 {
+		_ = c.rwc.SetReadDeadline(time.Now().Add(wait))
+	} else {
+		_ = c.rwc.SetReadDeadline(time.Time{})
 	}
 
-	rsp := wrap(handler(request.Req))
+	_, err := c.bufReader.Peek(2) //models.HeaderSize
+	if err != nil {
+		return errors.Wrap(errors.ErrPeekWritingErr, err) // io.EOF 代表对面关闭了???  or i/o timeout
+	}
 
-	return rsp, nil
+	_ = c.rwc.SetReadDeadline(time.Time{})
+	return nil
 }
 
 // Serve a new connection.
 func (c *Conn) serve(ctx context.Context) {
 	defer c.server.connsHist.Dec(1)
+	defer c.server.releaseConnSlot()
 
 	closeErr := errors.New("serve default close")
 	defer func() {
@@ -83,6 +245,7 @@ func (c *Conn) serve(ctx context.Context) {
 	}()
 
 	c.remoteAddr = c.rwc.RemoteAddr().String()
+	c.peer = peerKey(c.rwc.RemoteAddr())
 
 	defer func() {
 		if err := recover(); err != nil {
@@ -91,6 +254,12 @@ func (c *Conn) serve(ctx context.Context) {
 			buf = buf[:runtime.Stack(buf, false)]
 			log.Errorf("http: panic serving %v: %v\n%s", c.remoteAddr, err, buf)
 
+			// TLS 或安全握手中 panic 时还不能按帧回复, 直接关闭
+			if c.coalescer == nil {
+				closeErr = fmt.Errorf("panic serving : %v", err)
+				return
+			}
+
 			writeNow := time.Now()
 			panicErr := errors.NewStatus(500, fmt.Sprintf("panic serving : %v\n{%s}", err, string(buf)))
 			broken, err := c.responseStatus(ctx, panicErr)
@@ -102,8 +271,24 @@ func (c *Conn) serve(ctx context.Context) {
 		}
 	}()
 
+	peer := &Peer{Addr: c.rwc.RemoteAddr()}
+	if c.server.TLSConfig != nil {
+		if err := c.tlsHandshake(ctx, peer); err != nil {
+			closeErr = err
+			return
+		}
+	}
+	if c.server.Secure != nil {
+		if err := c.secureHandshake(peer); err != nil {
+			closeErr = err
+			return
+		}
+	}
+	ctx = context.WithValue(ctx, peerContextKey{}, peer)
+
 	c.bufReader = getBufReader(c)
 	c.bufWriter = getBufWriter(c)
+	c.coalescer = socket.NewCoalescer(c.bufWriter, c.server.WriteCoalesceDelay)
 
 	if c.server.ReadTimeout == 0 {
 		_ = c.rwc.SetReadDeadline(time.Time{})
@@ -112,29 +297,18 @@ func (c *Conn) serve(ctx context.Context) {
 		_ = c.rwc.SetWriteDeadline(time.Time{})
 	}
 
-	waitNext := func() error { // 阻塞等待 下一份数据
-		for {
-			wait := c.server.idleTimeout()
-
-			if wait != 0 {
-				_ = c.rwc.SetReadDeadline(time.Now().Add(wait))
-			} else {
-				_ = c.rwc.SetReadDeadline(time.Time{})
-			}
-
-			_, err := c.bufReader.Peek(2) //models.HeaderSize
-			if err != nil {
-				return errors.Wrap(errors.ErrPeekWritingErr, err) // io.EOF 代表对面关闭了???  or i/o timeout
-			}
-
-			_ = c.rwc.SetReadDeadline(time.Time{})
-			return nil
-		}
+	if err := c.handshake(ctx); err != nil {
+		closeErr = err
+		return
+	}
 
+	if c.server.PipelineConcurrency > 0 {
+		closeErr = c.servePipelined(ctx)
+		return
 	}
 
 	for {
-		if err := waitNext(); err != nil {
+		if err := c.waitNext(); err != nil {
 			closeErr = err
 			return
 		}
@@ -146,10 +320,20 @@ func (c *Conn) serve(ctx context.Context) {
 		}
 
 		readNow := time.Now()
-		header, body, broken, err := socket.ReadSocket(ctx, c.bufReader)
+		header := &models.Header{}
+		req, broken, err := socket.ReadFrame(ctx, c.bufReader, header, c.protocol.MaxFrameSize)
 		c.server.readHist.Update(time.Since(readNow).Milliseconds())
 
+		if err == nil {
+			if err = c.protocol.Check(header); err != nil {
+				req.Release()
+			}
+		}
 		if err != nil {
+			if errors.IsProtocolError(err) {
+				closeErr = c.protocolError(ctx, err)
+				return
+			}
 			if broken {
 				closeErr = err
 				return
@@ -157,12 +341,29 @@ func (c *Conn) serve(ctx context.Context) {
 			continue
 		}
 
+		// 流式调用独占连接, 结束后关闭
+		if header.Code == constant.ActionStream {
+			closeErr = c.serveStream(ctx, req)
+			return
+		}
+
+		// 单向通知, 不回复
+		if header.Code == constant.ActionNotify {
+			c.handleNotify(ctx, header, req)
+
+			if !c.server.doKeepAlives() {
+				closeErr = errors.ErrNoKeepAlive
+				return
+			}
+			continue
+		}
+
 		// 设置底层conn write超时
 		if c.server.WriteTimeout != 0 {
 			_ = c.rwc.SetWriteDeadline(time.Now().Add(c.server.WriteTimeout))
 		}
 		// handle
-		rspBytes, status := c.handleServe(ctx, body)
+		rspFrame, status := c.handleServe(ctx, header, req)
 
 		writeNow := time.Now()
 		if status != nil {
@@ -173,7 +374,7 @@ func (c *Conn) serve(ctx context.Context) {
 				return
 			}
 		} else {
-			broken, err := c.responseSuccess(ctx, header, rspBytes)
+			broken, err := c.responseSuccess(ctx, header, rspFrame)
 			c.server.writeHist.Update(time.Since(writeNow).Milliseconds())
 			if err != nil && broken {
 				closeErr = err
@@ -189,29 +390,253 @@ func (c *Conn) serve(ctx context.Context) {
 	}
 }
 
-func (c *Conn) responseSuccess(ctx context.Context, header *models.Header, rspBytes []byte) (bool, error) {
+func (c *Conn) serveStream(ctx context.Context, req *buffer.Buffer) error {
+	var open protocols.StreamFrame
+	err := unmarshal(req, &open)
+	req.Release()
+	if err != nil || open.Type != protocols.StreamOpen {
+		_, _ = c.responseStatus(ctx, errors.StatusInvalidRequest)
+		return errors.ErrStreamInvalidFrame
+	}
+
+	// 之后由 stream 直接写 bufWriter
+	if _, err = c.coalescer.Flush(); err != nil {
+		return err
+	}
+	c.coalescer.Stop()
+
+	// 流的读写由 stream 自己控制超时
+	_ = c.rwc.SetReadDeadline(time.Time{})
+	_ = c.rwc.SetWriteDeadline(time.Time{})
+
+	// 流和调用共用执行槽位, 直到 handler 返回才释放
+	release, admitted := c.admitRequest()
+	if release != nil {
+		defer release()
+	}
+
+	// 认证或授权失败也先建立流, 以流的结束帧回复; principal 随 ctx 进入 s.Context()
+	var authErr error
+	if admitted {
+		ctx, authErr = c.admit(ctx, open.Path, nil, open.Auth)
+	}
+
+	opts := stream.Options{
+		Window:       c.server.StreamWindowSize,
+		WriteTimeout: c.server.WriteTimeout,
+		Protocol:     c.protocol,
+	}
+	s, err := stream.NewServer(ctx, c.rwc, c.bufReader, c.bufWriter, &open, opts)
+	if err != nil {
+		return err
+	}
+
+	// 在 recover 之后执行, panic 时保持初值
+	code, errMsg := int32(500), "panic"
+	if c.server.AuditSink != nil {
+		start := time.Now()
+		defer func() {
+			c.audit(ctx, auditStream, open.Path, nil, start, code, errMsg, 0)
+		}()
+	}
+
+	if !admitted {
+		code, errMsg = statusResult(errors.StatusResourceExhausted)
+		s.Finish(errors.StatusResourceExhausted)
+		return errors.ErrStreamClosed
+	}
+	if authErr != nil {
+		code, errMsg = statusResult(authErr)
+		s.Finish(authErr.(*errors.Status))
+		return errors.ErrStreamClosed
+	}
+
+	handler := c.server.getStreamHandler(open.Path)
+	if handler == nil {
+		code, errMsg = statusResult(errors.StatusInvalidPath)
+		s.Finish(errors.StatusInvalidPath)
+		return errors.ErrStreamClosed
+	}
+
+	defer func() {
+		if err := recover(); err != nil {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			log.Errorf("http: panic serving stream %v: %v\n%s", c.remoteAddr, err, buf)
+
+			s.Finish(errors.NewStatus(500, fmt.Sprintf("panic serving : %v\n{%s}", err, string(buf))))
+		}
+	}()
+
+	err = handler(s)
+	if err == nil {
+		code, errMsg = handlerResult(nil)
+	} else {
+		code, errMsg = statusResult(err)
+	}
+	switch st := err.(type) {
+	case nil:
+		s.Finish(nil)
+	case *errors.Status:
+		s.Finish(st)
+	default:
+		s.Finish(errors.NewStatus(uint16(protocols.StatusErr), err.Error()))
+	}
+	return errors.ErrStreamClosed
+}
+
+// responseSuccess 写出后释放 rspFrame
+func (c *Conn) responseSuccess(ctx context.Context, header *models.Header, rspFrame *buffer.Buffer) (bool, error) {
+	defer rspFrame.Release()
 	header.Code = 0
-	header.Length = uint16(len(rspBytes))
-	return socket.WriteSocket(ctx, c.bufWriter, header, rspBytes)
+	header.Flags = c.protocol.FrameFlags()
+
+	if c.protocol.Compressor != 0 && len(socket.FrameBody(rspFrame)) >= c.server.compressThreshold() {
+		if compressed := c.compress(rspFrame); compressed != nil {
+			defer compressed.Release()
+			rspFrame = compressed
+			header.Flags |= constant.FlagCompressed
+		}
+	}
+
+	if len(socket.FrameBody(rspFrame)) > c.protocol.MaxFrameSize {
+		return c.responseStatus(ctx, errors.StatusFrameTooLarge)
+	}
+	return c.coalescer.WriteFrame(ctx, header, rspFrame, c.hasMore())
 }
 
 func (c *Conn) responseStatus(ctx context.Context, status *errors.Status) (bool, error) {
 	header := &models.Header{
 		Magic:   constant.DefaultMagic,
-		Version: constant.DefaultVersion,
+		Version: c.protocol.Version,
 		Code:    status.Code(),
+		Flags:   c.protocol.FrameFlags(),
 		Length:  0,
 	}
-	body := []byte(status.Error())
-	header.Length = uint16(len(body))
+	msg := status.Error()
+	frame := socket.NewFrame(4 + len(msg))
+	// v1 帧头没有 Flags, 不携带重试间隔
+	if retryAfter := status.RetryAfterMillis(); retryAfter > 0 && c.protocol.Version > constant.DefaultVersion {
+		if retryAfter > math.MaxUint32 {
+			retryAfter = math.MaxUint32
+		}
+		header.Flags |= constant.FlagRetryAfter
+		frame.B = binary.BigEndian.AppendUint32(frame.B, uint32(retryAfter))
+	}
+	frame.B = append(frame.B, msg...)
+	defer frame.Release()
 
-	return socket.WriteSocket(ctx, c.bufWriter, header, body)
+	return c.coalescer.WriteFrame(ctx, header, frame, c.hasMore())
 }
 
 func (c *Conn) Close(err error) {
 	fmt.Println("conn.close() ", c.Name, err)
 	_ = c.rwc.Close()
 
+	// 安全握手失败时还没有分配缓冲
+	if c.coalescer == nil {
+		return
+	}
+	c.coalescer.Stop()
 	putBufReader(c.bufReader)
 	putBufWriter(c.bufWriter)
 }
+
+// tlsHandshake 之后 c.rwc 为 *tls.Conn
+func (c *Conn) tlsHandshake(ctx context.Context, peer *Peer) error {
+	tlsConn := tls.Server(c.rwc, c.server.TLSConfig)
+	hctx, cancel := context.WithTimeout(ctx, constant.HandshakeTimeout)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(hctx); err != nil {
+		c.server.tlsFailCounter.Inc(1)
+		log.Errorf("tls handshake from %v: %v\n", c.remoteAddr, err)
+		return err
+	}
+	state := tlsConn.ConnectionState()
+	peer.TLS = &state
+	c.rwc = tlsConn
+	return nil
+}
+
+// secureHandshake 建立加密通道, 之后 c.rwc 读写的都是明文
+func (c *Conn) secureHandshake(peer *Peer) error {
+	_ = c.rwc.SetDeadline(time.Now().Add(constant.HandshakeTimeout))
+	sc, err := secure.Server(c.rwc, c.server.Secure)
+	if err != nil {
+		c.server.secureFailCounter.Inc(1)
+		log.Errorf("secure handshake from %v: %v\n", c.remoteAddr, err)
+		return errors.Wrap(errors.ErrSecureHandshake, err)
+	}
+	_ = c.rwc.SetDeadline(time.Time{})
+	peer.IdentityKey = sc.PeerKey()
+	c.rwc = sc
+	return nil
+}
+
+// protocolError 帧格式错误后无法确定下一帧从哪里开始, 不尝试重新同步:
+// 回复说明错误的状态帧, 按对端计数, 返回 err 由调用方关闭连接
+func (c *Conn) protocolError(ctx context.Context, err error) error {
+	c.server.protocolErrCounter.Inc(1)
+	c.server.protocolErrPeers.Inc(c.peer)
+	log.Errorf("protocol error from %v: %v\n", c.remoteAddr, err)
+
+	if c.server.WriteTimeout != 0 {
+		_ = c.rwc.SetWriteDeadline(time.Now().Add(c.server.WriteTimeout))
+	}
+	if _, werr := c.responseStatus(ctx, errors.ProtocolError(err)); werr == nil {
+		_, _ = c.coalescer.Flush()
+	}
+	return err
+}
+
+// compress 压缩失败或没有变小时返回 nil
+func (c *Conn) compress(frame *buffer.Buffer) *buffer.Buffer {
+	now := time.Now()
+	compressed, err := socket.CompressFrame(compress.Get(c.protocol.Compressor), frame)
+	c.server.compressCostHist.Update(time.Since(now).Microseconds())
+	if err != nil {
+		log.Errorf("compress response to %v: %v\n", c.remoteAddr, err)
+		return nil
+	}
+	if compressed == nil {
+		c.server.compressRatioHist.Update(100)
+		return nil
+	}
+	c.server.compressRatioHist.Update(int64(len(socket.FrameBody(compressed)) * 100 / len(socket.FrameBody(frame))))
+	return compressed
+}
+
+// decompress 帧头带 FlagCompressed 时返回解压后的缓冲并释放 req, 否则原样返回
+func (c *Conn) decompress(header *models.Header, req *buffer.Buffer) (*buffer.Buffer, error) {
+	if header.Flags&constant.FlagCompressed == 0 {
+		return req, nil
+	}
+	defer req.Release()
+
+	compressor := compress.Get(c.protocol.Compressor)
+	if c.protocol.Compressor == 0 || compressor == nil {
+		return nil, errors.ErrUnknownCompressor
+	}
+
+	now := time.Now()
+	body, err := socket.DecompressBody(compressor, req, c.server.maxFrameSize())
+	c.server.decompressCostHist.Update(time.Since(now).Microseconds())
+	return body, err
+}
+
+// hasMore 是否还有回复即将写出, 用于写合并
+func (c *Conn) hasMore() bool {
+	if c.pipeQueue != nil {
+		return len(c.pipeQueue) > 0
+	}
+	return c.bufReader.Buffered() > 0 // 下一个请求已经到达
+}
+
+// unmarshal req 为空代表空的消息
+func unmarshal(req *buffer.Buffer, m proto.Message) error {
+	if req == nil {
+		return nil
+	}
+	return proto.Unmarshal(req.B, m)
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/brodyxchen/vsock-sdk/compress"
+	"github.com/brodyxchen/vsock-sdk/constant"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/protocols"
+	"github.com/brodyxchen/vsock-sdk/socket"
+	"google.golang.org/protobuf/proto"
+	"math"
+	"time"
+)
+
+// handshake 连接上的第一帧是 ActionHandshake 时协商版本和特性;
+// 否则对端是旧版本 client, 按 v1 处理, 该帧留给之后的读循环.
+// 没有共同的版本时回复 StatusIncompatibleVersion, 返回错误后连接被关闭
+func (c *Conn) handshake(ctx context.Context) error {
+	c.protocol = models.LegacyProtocol
+
+	if err := c.waitNext(); err != nil {
+		return err
+	}
+
+	headerBuf, err := c.bufReader.Peek(6) // magic, version, code
+	if err != nil {
+		return errors.Wrap(errors.ErrPeekWritingErr, err)
+	}
+	if binary.BigEndian.Uint16(headerBuf) != constant.DefaultMagic ||
+		binary.BigEndian.Uint16(headerBuf[2:]) != constant.DefaultVersion ||
+		binary.BigEndian.Uint16(headerBuf[4:]) != constant.ActionHandshake {
+		if c.server.MinVersion > constant.DefaultVersion {
+			_, _ = c.writeHandshake(ctx, errors.StatusIncompatibleVersion.Code(), []byte(errors.StatusIncompatibleVersion.Error()))
+			return errors.ErrIncompatibleVersion
+		}
+		return nil
+	}
+
+	if c.server.ReadTimeout != 0 {
+		_ = c.rwc.SetReadDeadline(time.Now().Add(c.server.ReadTimeout))
+	}
+	header := &models.Header{}
+	req, _, err := socket.ReadFrame(ctx, c.bufReader, header, constant.MaxFrameSizeV1)
+	if err != nil {
+		if errors.IsProtocolError(err) {
+			return c.protocolError(ctx, err)
+		}
+		return err
+	}
+	var hello protocols.Hello
+	err = unmarshal(req, &hello)
+	req.Release()
+	if err != nil {
+		return c.protocolError(ctx, errors.ErrInvalidHandshake)
+	}
+
+	version := c.negotiateVersion(hello.Versions)
+	if version == 0 {
+		_, _ = c.writeHandshake(ctx, errors.StatusIncompatibleVersion.Code(), []byte(errors.StatusIncompatibleVersion.Error()))
+		return errors.ErrIncompatibleVersion
+	}
+
+	// 最大帧取双方的较小值, 每端只会收到不超过自己上限的帧
+	maxFrameSize := c.server.maxFrameSize()
+	if peer := int(hello.MaxFrameSize); peer > 0 && peer < maxFrameSize {
+		maxFrameSize = peer
+	}
+	if version == constant.DefaultVersion && maxFrameSize > constant.MaxFrameSizeV1 {
+		maxFrameSize = constant.MaxFrameSizeV1
+	}
+
+	c.protocol = models.Protocol{
+		Version:      version,
+		Features:     hello.Features & constant.SupportedFeatures,
+		MaxFrameSize: maxFrameSize,
+	}
+
+	// 压缩和校验和的标记在 v2 帧头中
+	if version == constant.DefaultVersion {
+		c.protocol.Features &^= constant.V2Features
+	}
+	if c.protocol.Has(constant.FeatureCompression) {
+		c.protocol.Compressor = c.negotiateCompressor(hello.Compressors)
+		if c.protocol.Compressor == 0 {
+			c.protocol.Features &^= constant.FeatureCompression
+			c.protocol.Compressor = 0
+		}
+	}
+
+	rsp, _ := proto.Marshal(&protocols.Hello{
+		Version:      uint32(c.protocol.Version),
+		Features:     c.protocol.Features,
+		MaxFrameSize: uint32(c.protocol.MaxFrameSize),
+		Compressor:   uint32(c.protocol.Compressor),
+	})
+	if _, err = c.writeHandshake(ctx, 0, rsp); err != nil {
+		return err
+	}
+	return nil
+}
+
+// negotiateVersion 双方都支持的最高版本, 没有时返回0
+func (c *Conn) negotiateVersion(versions []uint32) uint16 {
+	var best uint16
+	for _, v := range versions {
+		if v < uint32(c.server.MinVersion) || v < uint32(constant.DefaultVersion) || v > uint32(constant.MaxVersion) {
+			continue
+		}
+		if uint16(v) > best {
+			best = uint16(v)
+		}
+	}
+	return best
+}
+
+// negotiateCompressor 按 client 的偏好选择本端允许且已注册的算法, 没有时返回0
+func (c *Conn) negotiateCompressor(offered []uint32) uint8 {
+	for _, id := range offered {
+		if id == 0 || id > math.MaxUint8 || compress.Get(uint8(id)) == nil {
+			continue
+		}
+		if c.server.Compressors == nil {
+			return uint8(id)
+		}
+		for _, allowed := range c.server.Compressors {
+			if allowed == uint8(id) {
+				return allowed
+			}
+		}
+	}
+	return 0
+}
+
+// writeHandshake 握手的回复总是 v1 帧, 直接flush
+func (c *Conn) writeHandshake(ctx context.Context, code uint16, body []byte) (bool, error) {
+	if c.server.WriteTimeout != 0 {
+		_ = c.rwc.SetWriteDeadline(time.Now().Add(c.server.WriteTimeout))
+	}
+	header := &models.Header{
+		Magic:   constant.DefaultMagic,
+		Version: constant.DefaultVersion,
+		Code:    code,
+	}
+	return socket.WriteSocket(ctx, c.bufWriter, header, body)
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/constant"
+	"github.com/brodyxchen/vsock-sdk/log"
+	"github.com/brodyxchen/vsock-sdk/models"
+)
+
+// SetServingStatus 登记或修改服务的状态, service 为空时修改整个 server 的状态.
+// 内置的健康检查路由为 constant.HealthPath, 与其它路由一样经过认证, 授权和限流
+func (srv *Server) SetServingStatus(service string, status models.HealthStatus) {
+	srv.healthMutex.Lock()
+	defer srv.healthMutex.Unlock()
+	if old, ok := srv.healthStatuses[service]; !ok || old != status {
+		log.Infof("health %q: %v\n", service, status)
+	}
+	srv.healthStatuses[service] = status
+}
+
+// ServingStatus 没有登记的服务为 HealthUnknown
+func (srv *Server) ServingStatus(service string) models.HealthStatus {
+	srv.healthMutex.RLock()
+	defer srv.healthMutex.RUnlock()
+	return srv.healthStatuses[service]
+}
+
+func (srv *Server) handleHealth(_ context.Context, req []byte) ([]byte, error) {
+	return []byte(srv.ServingStatus(string(req)).String()), nil
+}
+
+func (srv *Server) initHealth() {
+	srv.healthStatuses = map[string]models.HealthStatus{"": models.HealthServing}
+	srv.handlers[constant.HealthPath] = srv.handleHealth
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/constant"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"testing"
+)
+
+func TestServingStatus(t *testing.T) {
+	srv := &Server{}
+	srv.Init()
+	handler := srv.getHandler(constant.HealthPath)
+	check := func(service string) string {
+		rsp, err := handler(context.Background(), []byte(service))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(rsp)
+	}
+
+	if got := check(""); got != "SERVING" {
+		t.Fatalf("server: %v", got)
+	}
+	if got := check("kv"); got != "UNKNOWN" {
+		t.Fatalf("unregistered: %v", got)
+	}
+	srv.SetServingStatus("kv", models.HealthNotServing)
+	srv.SetServingStatus("", models.HealthNotServing)
+	if got := check("kv"); models.ParseHealthStatus(got) != models.HealthNotServing {
+		t.Fatalf("kv: %v", got)
+	}
+	if srv.ServingStatus("") != models.HealthNotServing || srv.ServingStatus("other") != models.HealthUnknown {
+		t.Fatalf("status: %v %v", srv.ServingStatus(""), srv.ServingStatus("other"))
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// limiter 限制同时执行的请求数. 执行槽位满时请求排队, 队列满或等待超过 timeout 时立即拒绝,
+// 让 client 尽快得到 StatusResourceExhausted 而不是等到自己超时
+type limiter struct {
+	slots      chan struct{}
+	waiting    int64 // atomic, 正在排队的请求数
+	maxWaiting int64
+	timeout    time.Duration
+}
+
+func newLimiter(concurrency, queueSize int, timeout time.Duration) *limiter {
+	return &limiter{
+		slots:      make(chan struct{}, concurrency),
+		maxWaiting: int64(queueSize),
+		timeout:    timeout,
+	}
+}
+
+// acquire 成功后调用方负责 release, 返回排队的时长
+func (l *limiter) acquire() (bool, time.Duration) {
+	select {
+	case l.slots <- struct{}{}:
+		return true, 0
+	default:
+	}
+
+	if atomic.AddInt64(&l.waiting, 1) > l.maxWaiting {
+		atomic.AddInt64(&l.waiting, -1)
+		return false, 0
+	}
+	defer atomic.AddInt64(&l.waiting, -1)
+
+	now := time.Now()
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true, time.Since(now)
+	case <-timer.C:
+		return false, time.Since(now)
+	}
+}
+
+func (l *limiter) release() {
+	<-l.slots
+}
+
+// admitRequest 没有配置 MaxConcurrentRequests 时总是成功; 成功时返回的 release 不为 nil
+func (c *Conn) admitRequest() (func(), bool) {
+	l := c.server.requestLimiter
+	if l == nil {
+		return nil, true
+	}
+	ok, wait := l.acquire()
+	c.server.queueWaitHist.Update(wait.Microseconds())
+	if !ok {
+		c.server.shedCounter.Inc(1)
+		return nil, false
+	}
+	return l.release, true
+}
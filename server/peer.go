@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/brodyxchen/vsock-sdk/constant"
+	"github.com/mdlayher/vsock"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Peer 连接对端的信息, handler 通过 PeerFromContext 取得
+type Peer struct {
+	Addr net.Addr
+
+	// TLS 开启 TLS 时的连接状态, PeerCertificates 和 VerifiedChains 为对端的证书链
+	TLS *tls.ConnectionState
+
+	// IdentityKey 安全通道(Server.Secure)中对端的静态身份公钥
+	IdentityKey crypto.PublicKey
+}
+
+type peerContextKey struct{}
+
+// PeerFromContext ctx 不是 handler 收到的 ctx 时返回 nil
+func PeerFromContext(ctx context.Context) *Peer {
+	peer, _ := ctx.Value(peerContextKey{}).(*Peer)
+	return peer
+}
+
+// PeerCertificates 对端的证书链, 没有开启 TLS 或对端没有提供证书时为空
+func PeerCertificates(ctx context.Context) []*x509.Certificate {
+	peer := PeerFromContext(ctx)
+	if peer == nil || peer.TLS == nil {
+		return nil
+	}
+	return peer.TLS.PeerCertificates
+}
+
+// peerKey 对端的标识: vsock 为 CID, tcp 为 IP, 不含端口
+func peerKey(addr net.Addr) string {
+	switch ad := addr.(type) {
+	case *vsock.Addr:
+		return "vsock:" + strconv.FormatUint(uint64(ad.ContextID), 10)
+	case *net.TCPAddr:
+		return ad.IP.String()
+	case nil:
+		return ""
+	default:
+		return addr.String()
+	}
+}
+
+// peerCounter 按对端计数, 最多记录 constant.MaxTrackedPeers 个对端
+type peerCounter struct {
+	mutex  sync.Mutex
+	counts map[string]int64
+}
+
+func (pc *peerCounter) Inc(peer string) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	if pc.counts == nil {
+		pc.counts = make(map[string]int64)
+	}
+	if _, ok := pc.counts[peer]; !ok && len(pc.counts) >= constant.MaxTrackedPeers {
+		peer = constant.OtherPeers
+	}
+	pc.counts[peer]++
+}
+
+func (pc *peerCounter) Snapshot() map[string]int64 {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	counts := make(map[string]int64, len(pc.counts))
+	for peer, n := range pc.counts {
+		counts[peer] = n
+	}
+	return counts
+}
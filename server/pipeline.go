@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/brodyxchen/vsock-sdk/buffer"
+	"github.com/brodyxchen/vsock-sdk/constant"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/log"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/socket"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// pipeItem 流水线中的一个请求, 回复按读取顺序写回
+type pipeItem struct {
+	header *models.Header
+	req    *buffer.Buffer
+
+	rspFrame *buffer.Buffer
+	status   error
+	done     chan struct{}
+}
+
+// servePipelined 读循环持续解码请求放入有界队列, 最多 PipelineConcurrency 个 handler 并发执行,
+// 写循环按请求顺序写回回复. 协议不变, client 可以不等回复连续发送多个请求.
+func (c *Conn) servePipelined(ctx context.Context) error {
+	queue := make(chan *pipeItem, c.server.pipelineQueueSize()) // 按顺序等待写回
+	c.pipeQueue = queue
+	work := make(chan *pipeItem, c.server.PipelineConcurrency)
+
+	wg := sync.WaitGroup{}
+	wg.Add(c.server.PipelineConcurrency)
+	for i := 0; i < c.server.PipelineConcurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for item := range work {
+				c.handlePipeItem(ctx, item)
+			}
+		}()
+	}
+
+	writeErrCh := make(chan error, 1)
+	go func() {
+		writeErrCh <- c.pipelineWriteLoop(ctx, queue)
+	}()
+
+	streamOpen, readErr := c.pipelineReadLoop(ctx, queue, work)
+
+	close(work)
+	close(queue)
+	writeErr := <-writeErrCh
+	wg.Wait()
+
+	if writeErr != nil {
+		return writeErr
+	}
+	// 之前读到的请求都已回复, 再报告协议错误
+	if errors.IsProtocolError(readErr) {
+		return c.protocolError(ctx, readErr)
+	}
+	if streamOpen != nil {
+		return c.serveStream(ctx, streamOpen)
+	}
+	return readErr
+}
+
+// pipelineReadLoop 读到 StreamOpen 时返回该帧, 由调用方在回复全部写完后切换到流
+func (c *Conn) pipelineReadLoop(ctx context.Context, queue, work chan<- *pipeItem) (*buffer.Buffer, error) {
+	for {
+		if err := c.pipeWaitNext(); err != nil {
+			return nil, err
+		}
+
+		if c.server.ReadTimeout != 0 {
+			_ = c.rwc.SetReadDeadline(time.Now().Add(c.server.ReadTimeout))
+		}
+
+		readNow := time.Now()
+		header := &models.Header{}
+		req, broken, err := socket.ReadFrame(ctx, c.bufReader, header, c.protocol.MaxFrameSize)
+		c.server.readHist.Update(time.Since(readNow).Milliseconds())
+
+		if err == nil {
+			if err = c.protocol.Check(header); err != nil {
+				req.Release()
+			}
+		}
+		if err != nil {
+			if broken || errors.IsProtocolError(err) {
+				return nil, err
+			}
+			continue
+		}
+
+		// 流式调用独占连接, 等之前的回复全部写完再切换
+		if header.Code == constant.ActionStream {
+			return req, nil
+		}
+
+		item := &pipeItem{
+			header: header,
+			req:    req,
+			done:   make(chan struct{}),
+		}
+		c.pipeMutex.Lock()
+		c.pipeInflight++
+		c.pipeMutex.Unlock()
+		if header.Code != constant.ActionNotify {
+			queue <- item // 队列满时阻塞, 不再继续读取
+		}
+		work <- item
+
+		if !c.server.doKeepAlives() {
+			return nil, errors.ErrNoKeepAlive
+		}
+	}
+}
+
+// pipeWaitNext 与 waitNext 相同, 但还有 handler 在执行时连接不算空闲, 不设置空闲超时;
+// 最后一个 handler 执行完时由 pipeItemDone 开始计时
+func (c *Conn) pipeWaitNext() error {
+	wait := c.server.idleTimeout()
+
+	c.pipeMutex.Lock()
+	c.pipeWaiting = true
+	if wait != 0 && c.pipeInflight == 0 {
+		_ = c.rwc.SetReadDeadline(time.Now().Add(wait))
+	} else {
+		_ = c.rwc.SetReadDeadline(time.Time{})
+	}
+	c.pipeMutex.Unlock()
+
+	_, err := c.bufReader.Peek(2) //models.HeaderSize
+
+	c.pipeMutex.Lock()
+	c.pipeWaiting = false
+	c.pipeMutex.Unlock()
+	if err != nil {
+		return errors.Wrap(errors.ErrPeekWritingErr, err)
+	}
+
+	_ = c.rwc.SetReadDeadline(time.Time{})
+	return nil
+}
+
+func (c *Conn) pipeItemDone() {
+	c.pipeMutex.Lock()
+	defer c.pipeMutex.Unlock()
+	c.pipeInflight--
+	if wait := c.server.idleTimeout(); wait != 0 && c.pipeInflight == 0 && c.pipeWaiting {
+		_ = c.rwc.SetReadDeadline(time.Now().Add(wait))
+	}
+}
+
+func (c *Conn) handlePipeItem(ctx context.Context, item *pipeItem) {
+	defer close(item.done)
+	defer c.pipeItemDone()
+
+	if item.header.Code == constant.ActionNotify {
+		c.handleNotify(ctx, item.header, item.req)
+		return
+	}
+
+	defer func() {
+		if err := recover(); err != nil {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			log.Errorf("http: panic serving %v: %v\n%s", c.remoteAddr, err, buf)
+
+			item.rspFrame = nil
+			item.status = errors.NewStatus(500, fmt.Sprintf("panic serving : %v\n{%s}", err, string(buf)))
+		}
+	}()
+
+	item.rspFrame, item.status = c.handleServe(ctx, item.header, item.req)
+}
+
+// pipelineWriteLoop 写失败后继续消费队列, 避免读循环阻塞
+func (c *Conn) pipelineWriteLoop(ctx context.Context, queue <-chan *pipeItem) error {
+	var writeErr error
+	for item := range queue {
+		<-item.done
+		if writeErr != nil {
+			item.rspFrame.Release()
+			continue
+		}
+
+		if c.server.WriteTimeout != 0 {
+			_ = c.rwc.SetWriteDeadline(time.Now().Add(c.server.WriteTimeout))
+		}
+
+		var (
+			broken bool
+			err    error
+		)
+		writeNow := time.Now()
+		if item.status != nil {
+			broken, err = c.responseStatus(ctx, item.status.(*errors.Status))
+		} else {
+			broken, err = c.responseSuccess(ctx, item.header, item.rspFrame)
+		}
+		c.server.writeHist.Update(time.Since(writeNow).Milliseconds())
+
+		if err != nil && broken {
+			writeErr = err
+			_ = c.rwc.Close() // 让读循环退出
+		}
+	}
+	return writeErr
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"github.com/brodyxchen/vsock-sdk/constant"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/protocols"
+	"github.com/brodyxchen/vsock-sdk/socket"
+	"github.com/brodyxchen/vsock-sdk/statistics"
+	"google.golang.org/protobuf/proto"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPipelinedRepliesInOrder(t *testing.T) {
+	statistics.InitServer()
+
+	srv := &Server{
+		Addr:                &models.HttpAddr{IP: "127.0.0.1", Port: 0},
+		ReadTimeout:         time.Second,
+		WriteTimeout:        time.Second,
+		IdleTimeout:         time.Second,
+		PipelineConcurrency: 4,
+		WriteCoalesceDelay:  time.Millisecond,
+	}
+	srv.Init()
+	srv.HandleFunc("sleep", func(bytes []byte) ([]byte, error) {
+		ms, _ := strconv.Atoi(string(bytes))
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		return bytes, nil
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	// 前面的请求更慢, 回复仍需按顺序返回
+	delays := []string{"150", "100", "50", "0"}
+	begin := time.Now()
+	for _, delay := range delays {
+		body, _ := proto.Marshal(&protocols.Request{Path: "sleep", Req: []byte(delay)})
+		header := &models.Header{Magic: constant.DefaultMagic, Version: constant.DefaultVersion, Code: constant.ActionCall}
+		if _, err = socket.WriteSocket(context.Background(), writer, header, body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, delay := range delays {
+		header, body, _, err := socket.ReadSocket(context.Background(), reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Code != 0 {
+			t.Fatalf("status %v: %s", header.Code, body)
+		}
+		var rsp protocols.Response
+		if err = proto.Unmarshal(body, &rsp); err != nil {
+			t.Fatal(err)
+		}
+		if string(rsp.Rsp) != delay {
+			t.Fatalf("got reply %q, want %q", rsp.Rsp, delay)
+		}
+	}
+
+	// 串行需要300ms
+	if cost := time.Since(begin); cost > 250*time.Millisecond {
+		t.Fatalf("pipelined requests took %v", cost)
+	}
+}
+
+func TestPipelinedIdleWithInflight(t *testing.T) {
+	statistics.InitServer()
+
+	srv := &Server{
+		Addr:                &models.HttpAddr{IP: "127.0.0.1", Port: 0},
+		ReadTimeout:         time.Second,
+		WriteTimeout:        time.Second,
+		IdleTimeout:         50 * time.Millisecond,
+		PipelineConcurrency: 2,
+	}
+	srv.Init()
+	srv.HandleFunc("sleep", func(bytes []byte) ([]byte, error) {
+		time.Sleep(200 * time.Millisecond)
+		return bytes, nil
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	// handler 执行时间超过空闲超时, 连接不能按空闲关闭, 执行期间发送的请求照常处理
+	header := &models.Header{Magic: constant.DefaultMagic, Version: constant.DefaultVersion, Code: constant.ActionCall}
+	for i, msg := range []string{"first", "second"} {
+		if i > 0 {
+			time.Sleep(100 * time.Millisecond)
+		}
+		body, _ := proto.Marshal(&protocols.Request{Path: "sleep", Req: []byte(msg)})
+		if _, err = socket.WriteSocket(context.Background(), writer, header, body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, msg := range []string{"first", "second"} {
+		rspHeader, rspBody, _, err := socket.ReadSocket(context.Background(), reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rsp protocols.Response
+		if rspHeader.Code != 0 || proto.Unmarshal(rspBody, &rsp) != nil || string(rsp.Rsp) != msg {
+			t.Fatalf("reply: %v %q", rspHeader.Code, rspBody)
+		}
+	}
+
+	// 没有请求在执行后恢复空闲超时
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = reader.ReadByte(); err == nil {
+		t.Fatal("idle connection not closed")
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("idle connection not closed")
+	}
+}
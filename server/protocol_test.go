@@ -0,0 +1,84 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"github.com/brodyxchen/vsock-sdk/constant"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/protocols"
+	"github.com/brodyxchen/vsock-sdk/socket"
+	"github.com/brodyxchen/vsock-sdk/statistics"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProtocolErrorReplyAndClose(t *testing.T) {
+	for _, pipeline := range []int{0, 2} {
+		statistics.InitServer()
+
+		srv := &Server{
+			Addr:                &models.HttpAddr{IP: "127.0.0.1", Port: 0},
+			ReadTimeout:         time.Second,
+			WriteTimeout:        time.Second,
+			IdleTimeout:         time.Second,
+			PipelineConcurrency: pipeline,
+		}
+		srv.Init()
+		srv.HandleFunc("echo", func(bytes []byte) ([]byte, error) {
+			return bytes, nil
+		})
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			_ = srv.Serve(ln)
+		}()
+
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader := bufio.NewReader(conn)
+		writer := bufio.NewWriter(conn)
+
+		// 正常的请求之后紧跟一个错误的帧头
+		body, _ := proto.Marshal(&protocols.Request{Path: "echo", Req: []byte("ok")})
+		header := &models.Header{Magic: constant.DefaultMagic, Version: constant.DefaultVersion, Code: constant.ActionCall}
+		if _, err = socket.WriteSocket(context.Background(), writer, header, body); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = conn.Write([]byte("garbage!garbage!")); err != nil {
+			t.Fatal(err)
+		}
+
+		rspHeader, rspBody, _, err := socket.ReadSocket(context.Background(), reader)
+		if err != nil || rspHeader.Code != 0 {
+			t.Fatalf("pipeline %d: first reply %v %v", pipeline, rspHeader, err)
+		}
+
+		rspHeader, rspBody, _, err = socket.ReadSocket(context.Background(), reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rspHeader.Code != errors.StatusProtocolError.Code() || !strings.Contains(string(rspBody), errors.ErrInvalidHeaderMagic.Error()) {
+			t.Fatalf("pipeline %d: error reply %d %q", pipeline, rspHeader.Code, rspBody)
+		}
+
+		if _, err = reader.ReadByte(); err != io.EOF {
+			t.Fatalf("pipeline %d: conn not closed: %v", pipeline, err)
+		}
+		_ = conn.Close()
+		_ = ln.Close()
+
+		if n := srv.ProtocolErrors()["127.0.0.1"]; n != 1 {
+			t.Fatalf("pipeline %d: protocol errors %v", pipeline, srv.ProtocolErrors())
+		}
+	}
+}
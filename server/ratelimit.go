@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/constant"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/log"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimitKey 令牌桶按什么区分调用方
+type RateLimitKey int
+
+const (
+	LimitByPeer      RateLimitKey = iota // 对端的 CID 或 IP, 见 peerKey
+	LimitByPrincipal                     // 认证后的 principal, 没有 principal 时按对端
+)
+
+// RateLimit 路由级的令牌桶限流. Server.RateLimits 中第一条 Path 匹配的生效,
+// 每个调用方(见 Key)一个桶, 超出时回复 errors.RateLimited, 带有建议的重试间隔
+type RateLimit struct {
+	Path  string  // 同 Rule.Path
+	Rate  float64 // 每秒补充的令牌数
+	Burst int     // 桶的容量, 默认为 Rate 向上取整
+	Key   RateLimitKey
+
+	buckets *bucketSet
+}
+
+func (rl *RateLimit) match(path string) bool {
+	return (&Rule{Path: rl.Path}).match(path)
+}
+
+func (rl *RateLimit) burst() float64 {
+	if rl.Burst > 0 {
+		return float64(rl.Burst)
+	}
+	return math.Max(1, math.Ceil(rl.Rate))
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// bucketSet 最多 constant.MaxTrackedPeers 个桶, 已经回满的桶可以被丢弃;
+// 仍然满时新的调用方共用 constant.OtherPeers 的桶
+type bucketSet struct {
+	mutex   sync.Mutex
+	buckets map[string]*bucket
+}
+
+// take 取一个令牌, 失败时返回需要等待的时长
+func (rl *RateLimit) take(key string, now time.Time) (bool, time.Duration) {
+	set := rl.buckets
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+
+	burst := rl.burst()
+	b, ok := set.buckets[key]
+	if !ok {
+		if len(set.buckets) >= constant.MaxTrackedPeers {
+			rl.sweep(now)
+		}
+		if len(set.buckets) >= constant.MaxTrackedPeers {
+			key = constant.OtherPeers
+			b = set.buckets[key]
+		}
+		if b == nil {
+			b = &bucket{tokens: burst, last: now}
+			set.buckets[key] = b
+		}
+	}
+
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rl.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if rl.Rate <= 0 {
+		return false, time.Second
+	}
+	return false, time.Duration((1 - b.tokens) / rl.Rate * float64(time.Second))
+}
+
+// sweep 丢弃已经回满的桶, 它们与新建的桶没有区别
+func (rl *RateLimit) sweep(now time.Time) {
+	burst := rl.burst()
+	for key, b := range rl.buckets.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rl.Rate >= burst {
+			delete(rl.buckets.buckets, key)
+		}
+	}
+}
+
+func (srv *Server) compileRateLimits() {
+	for i := range srv.RateLimits {
+		srv.RateLimits[i].buckets = &bucketSet{buckets: make(map[string]*bucket)}
+	}
+}
+
+// rateLimit 在认证和授权之后检查
+func (c *Conn) rateLimit(ctx context.Context, path string) error {
+	for i := range c.server.RateLimits {
+		rl := &c.server.RateLimits[i]
+		if !rl.match(path) {
+			continue
+		}
+		key := c.peer
+		if principal := PrincipalFromContext(ctx); rl.Key == LimitByPrincipal && principal != "" {
+			key = "principal:" + principal
+		}
+		ok, retryAfter := rl.take(key, time.Now())
+		if ok {
+			return nil
+		}
+		c.server.rateLimitedCounter.Inc(1)
+		log.Debugf("rate limit %v for %v, retry after %v\n", path, key, retryAfter)
+		return errors.RateLimited(retryAfter)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"github.com/brodyxchen/vsock-sdk/constant"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	rl := &RateLimit{Rate: 2, Burst: 3, buckets: &bucketSet{buckets: make(map[string]*bucket)}}
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := rl.take("a", now); !ok {
+			t.Fatalf("burst %d rejected", i)
+		}
+	}
+	ok, retryAfter := rl.take("a", now)
+	if ok || retryAfter != 500*time.Millisecond {
+		t.Fatalf("over burst: %v %v", ok, retryAfter)
+	}
+	if ok, _ = rl.take("b", now); !ok {
+		t.Fatal("keys share a bucket")
+	}
+	if ok, _ = rl.take("a", now.Add(500*time.Millisecond)); !ok {
+		t.Fatal("not refilled")
+	}
+}
+
+func TestTokenBucketBounded(t *testing.T) {
+	rl := &RateLimit{Rate: 1, Burst: 1, buckets: &bucketSet{buckets: make(map[string]*bucket)}}
+	now := time.Now()
+	for i := 0; i < constant.MaxTrackedPeers; i++ {
+		rl.take(strconv.Itoa(i), now)
+	}
+
+	// 满了之后新的调用方共用一个桶
+	if ok, _ := rl.take("new1", now); !ok {
+		t.Fatal("overflow bucket empty")
+	}
+	if ok, _ := rl.take("new2", now); ok {
+		t.Fatal("overflow keys not shared")
+	}
+	if len(rl.buckets.buckets) != constant.MaxTrackedPeers+1 {
+		t.Fatalf("buckets: %d", len(rl.buckets.buckets))
+	}
+
+	// 回满的桶被清理
+	if ok, _ := rl.take("new3", now.Add(2*time.Second)); !ok || len(rl.buckets.buckets) != 1 {
+		t.Fatalf("sweep: %v %d", ok, len(rl.buckets.buckets))
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	st := errors.RateLimited(1234567 * time.Microsecond)
+	if d, ok := errors.RetryAfter(st); !ok || d != 1235*time.Millisecond || st.RetryAfterMillis() != 1235 {
+		t.Fatalf("retry after: %v %v (%v)", d, ok, st)
+	}
+	// 间隔不从 message 中解析
+	if _, ok := errors.RetryAfter(errors.NewStatus(st.Code(), st.Error())); ok {
+		t.Fatal("parsed from message")
+	}
+	if _, ok := errors.RetryAfter(errors.StatusResourceExhausted); ok {
+		t.Fatal("not rate limited")
+	}
+}
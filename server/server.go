@@ -2,10 +2,15 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"github.com/brodyxchen/vsock-sdk/buffer"
+	"github.com/brodyxchen/vsock-sdk/constant"
 	"github.com/brodyxchen/vsock-sdk/log"
 	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/secure"
 	"github.com/brodyxchen/vsock-sdk/statistics"
 	"github.com/brodyxchen/vsock-sdk/statistics/metrics"
+	"github.com/brodyxchen/vsock-sdk/stream"
 	"github.com/mdlayher/vsock"
 	"net"
 	"sync"
@@ -15,16 +20,78 @@ import (
 
 type handleFunc func([]byte) ([]byte, error)
 
+// contextHandleFunc ctx 中带有连接对端的信息, 见 PeerFromContext
+type contextHandleFunc func(ctx context.Context, req []byte) ([]byte, error)
+
+// bufferHandleFunc req 指向池化的帧缓冲 buf, 只在 handler 返回前有效;
+// 返回后还要使用时先 buf.Retain(), 用完后 buf.Release()
+type bufferHandleFunc func(req []byte, buf *buffer.Buffer) ([]byte, error)
+
+type streamHandleFunc func(*stream.Stream) error
+
 type Server struct {
 	Addr models.Addr
 
-	handlers map[string]handleFunc
-	mutex    sync.RWMutex
+	handlers       map[string]contextHandleFunc
+	bufHandlers    map[string]bufferHandleFunc
+	streamHandlers map[string]streamHandleFunc
+	mutex          sync.RWMutex
 
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
 	IdleTimeout  time.Duration
 
+	StreamWindowSize int // 流式调用的接收窗口
+	BatchConcurrency int // 批量调用中子请求的并发数, <=1 按顺序执行
+
+	PipelineConcurrency int // >0 开启流水线模式: 每条连接上同时执行的 handler 数
+	PipelineQueueSize   int // 流水线模式下每条连接已读取未回复的请求上限, 默认为 PipelineConcurrency*2
+
+	// 写合并: 还有回复等待写出时暂不flush, 最多延迟这么久; 0 每个回复都flush
+	WriteCoalesceDelay time.Duration
+
+	MinVersion   uint16 // 低于该版本的 client 被拒绝, 0 接受旧版本(不握手)的 client
+	MaxFrameSize int    // 能接收的最大 body, 默认 constant.MaxFrameSize
+
+	Compressors       []uint8 // 允许 client 选用的压缩算法, nil 为所有已注册的算法
+	CompressThreshold int     // 回复的 body 小于该大小时不压缩, 默认 constant.CompressThreshold
+
+	Secure *secure.Config // 非空时只接受建立了加密通道的连接
+
+	// TLSConfig 非空时连接先完成 TLS 握手, ClientAuth 设置为 RequireAndVerifyClientCert 即为 mTLS.
+	// 与 Secure 同时开启时, 加密通道建立在 TLS 之内
+	TLSConfig *tls.Config
+
+	// Authenticator 非空时每个请求(包括批量的子请求和流)在分发前都要通过认证, 失败回复 StatusUnauthenticated
+	Authenticator Authenticator
+
+	// ACL 非空时 Accept 之后立即检查对端地址, 不允许的连接直接关闭
+	ACL *ACL
+
+	// Rules 路由级的授权规则, 在认证之后检查, 拒绝时回复 StatusPermissionDenied.
+	// 没有规则匹配时允许, DefaultDeny 为 true 时拒绝
+	Rules       []Rule
+	DefaultDeny bool
+
+	// AuditSink 非空时每个分发的请求(包括被拒绝的)都产生一条审计记录
+	AuditSink AuditSink
+
+	// MaxConns >0 时同时服务的连接数达到上限后暂停 Accept, 直到有连接关闭
+	MaxConns int
+
+	// MaxConcurrentRequests >0 时限制同时执行的请求(调用, 批量, 通知, 流)数, 流从打开到 handler 返回占用一个.
+	// 超出的请求最多 MaxRequestQueue 个排队(默认等于 MaxConcurrentRequests), 排队超过 QueueTimeout
+	// (默认 constant.QueueTimeout) 或队列已满时回复 StatusResourceExhausted
+	MaxConcurrentRequests int
+	MaxRequestQueue       int
+	QueueTimeout          time.Duration
+
+	// RateLimits 路由级的令牌桶限流, 在认证和授权之后检查
+	RateLimits []RateLimit
+
+	healthStatuses map[string]models.HealthStatus // 服务名到状态, "" 为整个 server, 初始为 HealthServing
+	healthMutex    sync.RWMutex
+
 	DisableKeepAlives int32 // accessed atomically.
 
 	connIndex int64 // atomic visit
@@ -32,6 +99,30 @@ type Server struct {
 	connsHist metrics.Counter
 	readHist  metrics.Histogram
 	writeHist metrics.Histogram
+
+	notifyCounter     metrics.Counter
+	notifyFailCounter metrics.Counter
+
+	protocolErrCounter metrics.Counter
+	protocolErrPeers   peerCounter
+	secureFailCounter  metrics.Counter
+	tlsFailCounter     metrics.Counter
+	authFailCounter    metrics.Counter
+	aclDenyCounter     metrics.Counter
+	authzDenyCounter   metrics.Counter
+	deniedPeers        peerCounter
+
+	connSlots       chan struct{} // MaxConns
+	requestLimiter  *limiter      // MaxConcurrentRequests
+	throttleCounter metrics.Counter
+	shedCounter     metrics.Counter
+	queueWaitHist   metrics.Histogram // 微秒
+
+	rateLimitedCounter metrics.Counter
+
+	compressRatioHist  metrics.Histogram // 压缩后/压缩前, 百分比
+	compressCostHist   metrics.Histogram // 微秒
+	decompressCostHist metrics.Histogram // 微秒
 }
 
 func (srv *Server) getConnIndex() int64 {
@@ -44,26 +135,89 @@ func (srv *Server) ShowConnIndex() int64 {
 }
 
 func (srv *Server) Init() {
-	srv.handlers = make(map[string]handleFunc, 0)
+	srv.handlers = make(map[string]contextHandleFunc, 0)
+	srv.bufHandlers = make(map[string]bufferHandleFunc, 0)
+	srv.streamHandlers = make(map[string]streamHandleFunc, 0)
 	srv.mutex = sync.RWMutex{}
+	srv.initHealth()
 }
 
 func (srv *Server) HandleFunc(path string, handleFn handleFunc) {
+	srv.HandleContextFunc(path, func(_ context.Context, req []byte) ([]byte, error) {
+		return handleFn(req)
+	})
+}
+
+// HandleContextFunc handler 可以从 ctx 中取得对端的证书链等信息, 见 PeerFromContext
+func (srv *Server) HandleContextFunc(path string, handleFn contextHandleFunc) {
 	srv.mutex.Lock()
 	defer srv.mutex.Unlock()
 	srv.handlers[path] = handleFn
 }
 
-func (srv *Server) getHandler(path string) handleFunc {
+// HandleClientStream client 多次发送, handler 读到 io.EOF 后返回一次回复
+func (srv *Server) HandleClientStream(path string, handleFn func(*stream.Stream) ([]byte, error)) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	srv.streamHandlers[path] = func(s *stream.Stream) error {
+		rsp, err := handleFn(s)
+		if err != nil {
+			return err
+		}
+		return s.Send(rsp)
+	}
+}
+
+// HandleBidiStream 双向流, handler 返回即结束该流
+func (srv *Server) HandleBidiStream(path string, handleFn func(*stream.Stream) error) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	srv.streamHandlers[path] = handleFn
+}
+
+func (srv *Server) getStreamHandler(path string) streamHandleFunc {
 	srv.mutex.RLock()
 	defer srv.mutex.RUnlock()
-	handler, ok := srv.handlers[path]
+	handler, ok := srv.streamHandlers[path]
 	if !ok {
 		return nil
 	}
 	return handler
 }
 
+// HandleBufferFunc 请求不拷贝, 直接借用读取时的池化缓冲
+func (srv *Server) HandleBufferFunc(path string, handleFn bufferHandleFunc) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	srv.bufHandlers[path] = handleFn
+}
+
+func (srv *Server) getHandler(path string) contextHandleFunc {
+	srv.mutex.RLock()
+	defer srv.mutex.RUnlock()
+	handler, ok := srv.handlers[path]
+	if ok {
+		return handler
+	}
+	bufHandler, ok := srv.bufHandlers[path]
+	if ok {
+		return func(_ context.Context, req []byte) ([]byte, error) {
+			return bufHandler(req, buffer.Wrap(req))
+		}
+	}
+	return nil
+}
+
+// lookupHandler 用 []byte 查找, 不产生 string 分配
+func (srv *Server) lookupHandler(path []byte) (contextHandleFunc, bufferHandleFunc) {
+	srv.mutex.RLock()
+	defer srv.mutex.RUnlock()
+	if bufHandler, ok := srv.bufHandlers[string(path)]; ok {
+		return nil, bufHandler
+	}
+	return srv.handlers[string(path)], nil
+}
+
 func (srv *Server) ListenAndServe() error {
 	var (
 		ln  net.Listener
@@ -107,9 +261,76 @@ func (srv *Server) Serve(l net.Listener) error {
 	srv.readHist = readHist
 	srv.writeHist = writeHist
 
+	notifyCounter := metrics.NewCounter()
+	notifyFailCounter := metrics.NewCounter()
+	_ = statistics.ServerReg.Register("srv.notify", notifyCounter)
+	_ = statistics.ServerReg.Register("srv.notify.fail", notifyFailCounter)
+	srv.notifyCounter = notifyCounter
+	srv.notifyFailCounter = notifyFailCounter
+
+	protocolErrCounter := metrics.NewCounter()
+	_ = statistics.ServerReg.Register("srv.protocol.errors", protocolErrCounter)
+	srv.protocolErrCounter = protocolErrCounter
+
+	secureFailCounter := metrics.NewCounter()
+	_ = statistics.ServerReg.Register("srv.secure.fail", secureFailCounter)
+	srv.secureFailCounter = secureFailCounter
+
+	tlsFailCounter := metrics.NewCounter()
+	_ = statistics.ServerReg.Register("srv.tls.fail", tlsFailCounter)
+	srv.tlsFailCounter = tlsFailCounter
+
+	authFailCounter := metrics.NewCounter()
+	_ = statistics.ServerReg.Register("srv.auth.fail", authFailCounter)
+	srv.authFailCounter = authFailCounter
+
+	aclDenyCounter := metrics.NewCounter()
+	authzDenyCounter := metrics.NewCounter()
+	_ = statistics.ServerReg.Register("srv.acl.deny", aclDenyCounter)
+	_ = statistics.ServerReg.Register("srv.authz.deny", authzDenyCounter)
+	srv.aclDenyCounter = aclDenyCounter
+	srv.authzDenyCounter = authzDenyCounter
+
+	if err := srv.compilePolicy(); err != nil {
+		return err
+	}
+
+	throttleCounter := metrics.NewCounter()
+	shedCounter := metrics.NewCounter()
+	queueWaitHist := metrics.NewHistogram(metrics.NewUniformSample(1028))
+	_ = statistics.ServerReg.Register("srv.conns.throttled", throttleCounter)
+	_ = statistics.ServerReg.Register("srv.shed", shedCounter)
+	_ = statistics.ServerReg.Register("srv.queue.waitUs", queueWaitHist)
+	srv.throttleCounter = throttleCounter
+	srv.shedCounter = shedCounter
+	srv.queueWaitHist = queueWaitHist
+	rateLimitedCounter := metrics.NewCounter()
+	_ = statistics.ServerReg.Register("srv.ratelimited", rateLimitedCounter)
+	srv.rateLimitedCounter = rateLimitedCounter
+	srv.compileRateLimits()
+
+	if srv.MaxConns > 0 {
+		srv.connSlots = make(chan struct{}, srv.MaxConns)
+	}
+	if srv.MaxConcurrentRequests > 0 {
+		srv.requestLimiter = newLimiter(srv.MaxConcurrentRequests, srv.maxRequestQueue(), srv.queueTimeout())
+	}
+
+	compressRatioHist := metrics.NewHistogram(metrics.NewUniformSample(1028))
+	compressCostHist := metrics.NewHistogram(metrics.NewUniformSample(1028))
+	decompressCostHist := metrics.NewHistogram(metrics.NewUniformSample(1028))
+	_ = statistics.ServerReg.Register("srv.compress.ratio", compressRatioHist)
+	_ = statistics.ServerReg.Register("srv.compress.costUs", compressCostHist)
+	_ = statistics.ServerReg.Register("srv.decompress.costUs", decompressCostHist)
+	srv.compressRatioHist = compressRatioHist
+	srv.compressCostHist = compressCostHist
+	srv.decompressCostHist = decompressCostHist
+
 	for {
+		srv.acquireConnSlot()
 		rw, err := l.Accept()
 		if err != nil {
+			srv.releaseConnSlot()
 			if ne, ok := err.(net.Error); ok && ne.Temporary() {
 				tempDelay = srv.sleep(tempDelay)
 				continue
@@ -119,6 +340,12 @@ func (srv *Server) Serve(l net.Listener) error {
 
 		acceptNow := time.Now()
 
+		if !srv.acceptConn(rw.RemoteAddr()) {
+			srv.releaseConnSlot()
+			_ = rw.Close()
+			continue
+		}
+
 		connCtx := ctx
 		tempDelay = 0
 
@@ -142,6 +369,49 @@ func (srv *Server) newConn(rwc net.Conn) *Conn {
 	return c
 }
 
+// ProtocolErrors 每个对端(见 peerKey)发生的协议错误次数
+func (srv *Server) ProtocolErrors() map[string]int64 {
+	return srv.protocolErrPeers.Snapshot()
+}
+
+// Denials 每个对端(见 peerKey)被 ACL 或 Rules 拒绝的次数
+func (srv *Server) Denials() map[string]int64 {
+	return srv.deniedPeers.Snapshot()
+}
+
+// acquireConnSlot 达到 MaxConns 时阻塞
+func (srv *Server) acquireConnSlot() {
+	if srv.connSlots == nil {
+		return
+	}
+	select {
+	case srv.connSlots <- struct{}{}:
+	default:
+		srv.throttleCounter.Inc(1)
+		srv.connSlots <- struct{}{}
+	}
+}
+
+func (srv *Server) releaseConnSlot() {
+	if srv.connSlots != nil {
+		<-srv.connSlots
+	}
+}
+
+func (srv *Server) maxRequestQueue() int {
+	if srv.MaxRequestQueue > 0 {
+		return srv.MaxRequestQueue
+	}
+	return srv.MaxConcurrentRequests
+}
+
+func (srv *Server) queueTimeout() time.Duration {
+	if srv.QueueTimeout > 0 {
+		return srv.QueueTimeout
+	}
+	return constant.QueueTimeout
+}
+
 func (srv *Server) doKeepAlives() bool {
 	return atomic.LoadInt32(&srv.DisableKeepAlives) == 0
 }
@@ -153,6 +423,27 @@ func (srv *Server) idleTimeout() time.Duration {
 	return srv.ReadTimeout
 }
 
+func (srv *Server) maxFrameSize() int {
+	if srv.MaxFrameSize > 0 {
+		return srv.MaxFrameSize
+	}
+	return constant.MaxFrameSize
+}
+
+func (srv *Server) compressThreshold() int {
+	if srv.CompressThreshold > 0 {
+		return srv.CompressThreshold
+	}
+	return constant.CompressThreshold
+}
+
+func (srv *Server) pipelineQueueSize() int {
+	if srv.PipelineQueueSize > 0 {
+		return srv.PipelineQueueSize
+	}
+	return srv.PipelineConcurrency * 2
+}
+
 func (srv *Server) sleep(tempDelay time.Duration) time.Duration {
 	if tempDelay == 0 {
 		tempDelay = 5 * time.Millisecond
@@ -0,0 +1,108 @@
+package socket
+
+import (
+	"bufio"
+	"context"
+	"github.com/brodyxchen/vsock-sdk/buffer"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"sync"
+	"time"
+)
+
+// Coalescer 合并小帧的写出, 减少 syscall:
+// 调用方还有后续帧排队时只写入缓冲, 队列清空时立即 flush;
+// 最早一个未 flush 的帧等待超过 maxDelay 时由定时器 flush.
+// maxDelay<=0 时每帧都 flush.
+type Coalescer struct {
+	writer   *bufio.Writer
+	maxDelay time.Duration
+
+	mutex   sync.Mutex // 守护 writer 和以下变量
+	timer   *time.Timer
+	pending bool
+	err     error // 定时器 flush 失败
+	stopped bool
+}
+
+func NewCoalescer(writer *bufio.Writer, maxDelay time.Duration) *Coalescer {
+	return &Coalescer{
+		writer:   writer,
+		maxDelay: maxDelay,
+	}
+}
+
+// WriteFrame more 表示调用方还有帧等待写出
+func (co *Coalescer) WriteFrame(ctx context.Context, header *models.Header, frame *buffer.Buffer, more bool) (bool, error) {
+	co.mutex.Lock()
+	defer co.mutex.Unlock()
+
+	if co.err != nil {
+		return true, co.err
+	}
+
+	broken, err := BufferFrame(ctx, co.writer, header, frame)
+	if err != nil {
+		return broken, err
+	}
+
+	if !more || co.maxDelay <= 0 {
+		return co.flushLocked()
+	}
+
+	if !co.pending {
+		co.pending = true
+		if co.timer == nil {
+			co.timer = time.AfterFunc(co.maxDelay, co.onTimer)
+		} else {
+			co.timer.Reset(co.maxDelay)
+		}
+	}
+	return false, nil
+}
+
+func (co *Coalescer) Flush() (bool, error) {
+	co.mutex.Lock()
+	defer co.mutex.Unlock()
+
+	if co.err != nil {
+		return true, co.err
+	}
+	if !co.pending {
+		return false, nil
+	}
+	return co.flushLocked()
+}
+
+// Stop 之后定时器不再访问 writer, writer 可以被回收
+func (co *Coalescer) Stop() {
+	co.mutex.Lock()
+	defer co.mutex.Unlock()
+
+	co.stopped = true
+	if co.timer != nil {
+		co.timer.Stop()
+	}
+}
+
+func (co *Coalescer) flushLocked() (bool, error) {
+	co.pending = false
+	if co.timer != nil {
+		co.timer.Stop()
+	}
+
+	if err := co.writer.Flush(); err != nil {
+		co.err = err
+		return true, err
+	}
+	return false, nil
+}
+
+func (co *Coalescer) onTimer() {
+	co.mutex.Lock()
+	defer co.mutex.Unlock()
+
+	if co.stopped || !co.pending || co.err != nil {
+		return
+	}
+	_, _ = co.flushLocked()
+}
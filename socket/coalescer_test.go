@@ -0,0 +1,83 @@
+package socket
+
+import (
+	"bufio"
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countWriter 记录底层 Write 次数
+type countWriter struct {
+	mutex sync.Mutex
+	count int
+	bytes int
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.count++
+	w.bytes += len(p)
+	return len(p), nil
+}
+
+func (w *countWriter) writes() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.count
+}
+
+func writeSmall(t *testing.T, co *Coalescer, more bool) {
+	frame := NewFrame(4)
+	frame.B = append(frame.B, "ping"...)
+	defer frame.Release()
+	if _, err := co.WriteFrame(context.Background(), newHeader(), frame, more); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCoalescerFlushWhenDrained(t *testing.T) {
+	w := &countWriter{}
+	co := NewCoalescer(bufio.NewWriter(w), time.Hour)
+	defer co.Stop()
+
+	for i := 0; i < 3; i++ {
+		writeSmall(t, co, true)
+	}
+	if n := w.writes(); n != 0 {
+		t.Fatalf("flushed %v times while frames queued", n)
+	}
+
+	writeSmall(t, co, false)
+	if n := w.writes(); n != 1 {
+		t.Fatalf("want one coalesced write, got %v", n)
+	}
+}
+
+func TestCoalescerLatencyBound(t *testing.T) {
+	w := &countWriter{}
+	co := NewCoalescer(bufio.NewWriter(w), 10*time.Millisecond)
+	defer co.Stop()
+
+	writeSmall(t, co, true)
+	deadline := time.Now().Add(time.Second)
+	for w.writes() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("pending frame not flushed after max delay")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCoalescerDisabled(t *testing.T) {
+	w := &countWriter{}
+	co := NewCoalescer(bufio.NewWriter(w), 0)
+
+	writeSmall(t, co, true)
+	writeSmall(t, co, true)
+	if n := w.writes(); n != 2 {
+		t.Fatalf("want flush per frame, got %v writes", n)
+	}
+}
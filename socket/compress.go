@@ -0,0 +1,39 @@
+package socket
+
+import (
+	"github.com/brodyxchen/vsock-sdk/buffer"
+	"github.com/brodyxchen/vsock-sdk/compress"
+)
+
+// CompressFrame 压缩 NewFrame 得到的帧的 body, 返回新的帧, frame 不被释放.
+// 压缩后没有变小时返回 nil, 调用方应按原样发送
+func CompressFrame(c compress.Compressor, frame *buffer.Buffer) (*buffer.Buffer, error) {
+	body := FrameBody(frame)
+	out := NewFrame(len(body))
+	var err error
+	out.B, err = c.Compress(out.B, body)
+	if err != nil || len(out.B) >= len(frame.B) {
+		out.Release()
+		return nil, err
+	}
+	return out, nil
+}
+
+// DecompressBody 解压 ReadFrame 得到的 body, 返回新的缓冲, body 不被释放
+func DecompressBody(c compress.Compressor, body *buffer.Buffer, limit int) (*buffer.Buffer, error) {
+	if body == nil {
+		return nil, nil
+	}
+	size := len(body.B) * 4
+	if size > limit {
+		size = limit
+	}
+	out := buffer.Get(size)
+	var err error
+	out.B, err = c.Decompress(out.B, body.B, limit)
+	if err != nil {
+		out.Release()
+		return nil, err
+	}
+	return out, nil
+}
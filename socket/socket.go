@@ -4,94 +4,272 @@ import (
 	"bufio"
 	"context"
 	"encoding/binary"
+	"github.com/brodyxchen/vsock-sdk/buffer"
 	"github.com/brodyxchen/vsock-sdk/constant"
 	"github.com/brodyxchen/vsock-sdk/errors"
 	"github.com/brodyxchen/vsock-sdk/models"
+	"hash/crc32"
 	"io"
 	"math"
 )
 
+// ReadSocket body 由调用方持有, 每帧分配一次; 热路径使用 ReadFrame
 func ReadSocket(ctx context.Context, reader *bufio.Reader) (*models.Header, []byte, bool, error) {
+	header := &models.Header{}
+	broken, err := readHeader(ctx, reader, header, constant.MaxFrameSize)
+	if err != nil {
+		return nil, nil, broken, err
+	}
+
+	if header.Length <= 0 {
+		return header, nil, false, nil
+	}
+
+	bodyBuf := make([]byte, header.Length)
+	broken, err = readBody(reader, bodyBuf)
+	if err != nil {
+		return nil, nil, broken, err
+	}
+	if bodyBuf, err = verifyChecksum(header, bodyBuf); err != nil {
+		return nil, nil, true, err
+	}
+	return header, bodyBuf, false, nil
+}
+
+// ReadFrame 帧头直接从 reader 的缓冲中解析, body 读入池化的 Buffer(只含body),
+// 调用方用完后 Release; body 为空时返回 nil. body 超过 maxBody 时返回 ErrExceedBody
+func ReadFrame(ctx context.Context, reader *bufio.Reader, header *models.Header, maxBody int) (*buffer.Buffer, bool, error) {
+	broken, err := readHeader(ctx, reader, header, maxBody)
+	if err != nil {
+		return nil, broken, err
+	}
+
+	if header.Length <= 0 {
+		return nil, false, nil
+	}
+
+	buf := buffer.Get(int(header.Length))
+	buf.B = buf.B[:header.Length]
+	broken, err = readBody(reader, buf.B)
+	if err != nil {
+		buf.Release()
+		return nil, broken, err
+	}
+	if buf.B, err = verifyChecksum(header, buf.B); err != nil {
+		buf.Release()
+		return nil, true, err
+	}
+	if len(buf.B) == 0 {
+		buf.Release()
+		return nil, false, nil
+	}
+	return buf, false, nil
+}
+
+// headerSize 不支持的版本返回0
+func headerSize(version uint16) int {
+	switch version {
+	case 1:
+		return models.HeaderSize
+	case 2:
+		return models.HeaderSizeV2
+	}
+	return 0
+}
+
+func readHeader(ctx context.Context, reader *bufio.Reader, header *models.Header, maxBody int) (bool, error) {
 	select {
 	case <-ctx.Done():
-		return nil, nil, false, errors.ErrCtxReadDone
+		return false, errors.ErrCtxReadDone
 	default:
 	}
 
-	header := &models.Header{}
-
-	headerBuf := make([]byte, models.HeaderSize)
-	n, err := io.ReadFull(reader, headerBuf)
+	headerBuf, err := reader.Peek(4)
 	if err != nil {
 		if err == io.EOF {
-			return nil, nil, true, io.ErrUnexpectedEOF
+			return true, io.ErrUnexpectedEOF
 		}
-		return nil, nil, true, err
-	}
-	if n < models.HeaderSize {
-		return nil, nil, false, errors.ErrInvalidHeader
+		return true, err
 	}
 
 	header.Magic = binary.BigEndian.Uint16(headerBuf[:])
+	header.Version = binary.BigEndian.Uint16(headerBuf[2:])
+
 	if header.Magic != constant.DefaultMagic {
-		return nil, nil, false, errors.ErrInvalidHeaderMagic
+		_, _ = reader.Discard(models.HeaderSize)
+		return false, errors.ErrInvalidHeaderMagic
+	}
+
+	// 不认识的版本无法确定帧头长度, 之后的数据都无法解析
+	size := headerSize(header.Version)
+	if size == 0 {
+		return true, errors.ErrUnsupportedVersion
+	}
+
+	headerBuf, err = reader.Peek(size)
+	if err != nil {
+		if err == io.EOF {
+			return true, io.ErrUnexpectedEOF
+		}
+		return true, err
 	}
 
-	header.Version = binary.BigEndian.Uint16(headerBuf[2:])
 	header.Code = binary.BigEndian.Uint16(headerBuf[4:])
-	header.Length = binary.BigEndian.Uint16(headerBuf[6:])
+	if size == models.HeaderSize {
+		header.Flags = 0
+		header.Length = uint32(binary.BigEndian.Uint16(headerBuf[6:]))
+	} else {
+		header.Flags = binary.BigEndian.Uint16(headerBuf[6:])
+		header.Length = binary.BigEndian.Uint32(headerBuf[8:])
+	}
+	_, _ = reader.Discard(size) // headerBuf 之后失效
 
-	if header.Length <= 0 {
-		return header, nil, false, nil
+	if header.Flags&constant.FlagChecksum != 0 {
+		if header.Length < checksumSize {
+			return true, errors.ErrInvalidHeader
+		}
+		maxBody += checksumSize
+	}
+	if int64(header.Length) > int64(maxBody) {
+		return true, errors.ErrExceedBody
 	}
+	return false, nil
+}
 
-	bodyBuf := make([]byte, header.Length)
-	n, err = io.ReadFull(reader, bodyBuf)
+func readBody(reader *bufio.Reader, body []byte) (bool, error) {
+	n, err := io.ReadFull(reader, body)
 	if err != nil {
 		if err == io.EOF {
-			return header, nil, true, io.ErrUnexpectedEOF
+			return true, io.ErrUnexpectedEOF
 		}
-		return nil, nil, true, err
+		return true, err
 	}
 
-	if n < int(header.Length) {
-		return nil, nil, false, errors.ErrInvalidBody
+	if n < len(body) {
+		return false, errors.ErrInvalidBody
 	}
-
-	return header, bodyBuf, false, nil
+	return false, nil
 }
 
+// WriteSocket body 被拷贝进池化的帧缓冲后写出
 func WriteSocket(ctx context.Context, writer *bufio.Writer, header *models.Header, body []byte) (bool, error) {
+	frame := NewFrame(len(body))
+	frame.B = append(frame.B, body...)
+	broken, err := WriteFrame(ctx, writer, header, frame)
+	frame.Release()
+	return broken, err
+}
+
+// NewFrame 返回预留了帧头空间的池化缓冲, body 直接 append 到 B 之后, 用 WriteFrame 写出.
+// 帧头和 body 在同一块连续内存中, 一次 Write 即可写出, 不需要拼接或 writev.
+// 按最长的帧头预留, 较短的帧头紧贴 body 写入
+func NewFrame(bodySize int) *buffer.Buffer {
+	frame := buffer.Get(models.MaxHeaderSize + bodySize)
+	frame.B = frame.B[:models.MaxHeaderSize]
+	return frame
+}
+
+// FrameBody NewFrame 得到的帧中 body 部分
+func FrameBody(frame *buffer.Buffer) []byte {
+	return frame.B[models.MaxHeaderSize:]
+}
+
+// WriteFrame 按 header.Version 填充 frame 的帧头并写出, 不释放 frame
+func WriteFrame(ctx context.Context, writer *bufio.Writer, header *models.Header, frame *buffer.Buffer) (bool, error) {
+	broken, err := BufferFrame(ctx, writer, header, frame)
+	if err != nil {
+		return broken, err
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		return true, err
+	}
+
+	return false, nil
+}
+
+// BufferFrame 同 WriteFrame, 但不 flush, 帧可能还停留在 writer 的缓冲中
+func BufferFrame(ctx context.Context, writer *bufio.Writer, header *models.Header, frame *buffer.Buffer) (bool, error) {
 	select {
 	case <-ctx.Done():
 		return false, errors.ErrCtxWriteDone
 	default:
 	}
 
-	length := len(body)
-	if length > math.MaxUint16 {
-		return false, errors.ErrExceedBody
+	size := headerSize(header.Version)
+	if size == 0 {
+		return false, errors.ErrUnsupportedVersion
 	}
-	header.Length = uint16(length)
 
-	buf := make([]byte, models.HeaderSize+length)
-	binary.BigEndian.PutUint16(buf, header.Magic)
-	binary.BigEndian.PutUint16(buf[2:], header.Version)
-	binary.BigEndian.PutUint16(buf[4:], header.Code)
-	binary.BigEndian.PutUint16(buf[6:], header.Length)
-	if length > 0 {
-		copy(buf[models.HeaderSize:], body)
+	length := len(frame.B) - models.MaxHeaderSize
+	if header.Flags&constant.FlagChecksum != 0 {
+		length += checksumSize
+	}
+	if size == models.HeaderSize {
+		if length > math.MaxUint16 {
+			return false, errors.ErrExceedBody
+		}
+		if header.Flags != 0 {
+			return false, errors.ErrUnsupportedFlags
+		}
+	} else if int64(length) > math.MaxUint32 {
+		return false, errors.ErrExceedBody
 	}
+	header.Length = uint32(length)
+
+	buf := frame.B[models.MaxHeaderSize-size:]
+	putHeader(buf, header, size)
 
 	_, err := writer.Write(buf)
 	if err != nil {
 		return true, err
 	}
 
-	err = writer.Flush()
-	if err != nil {
-		return true, err
+	// 校验和不写入 frame, 同一个帧可以被重复写出
+	if header.Flags&constant.FlagChecksum != 0 {
+		var trailer [checksumSize]byte
+		binary.BigEndian.PutUint32(trailer[:], crc32.Checksum(buf, castagnoli))
+		if _, err = writer.Write(trailer[:]); err != nil {
+			return true, err
+		}
 	}
 
 	return false, nil
 }
+
+func putHeader(buf []byte, header *models.Header, size int) {
+	binary.BigEndian.PutUint16(buf, header.Magic)
+	binary.BigEndian.PutUint16(buf[2:], header.Version)
+	binary.BigEndian.PutUint16(buf[4:], header.Code)
+	if size == models.HeaderSize {
+		binary.BigEndian.PutUint16(buf[6:], uint16(header.Length))
+	} else {
+		binary.BigEndian.PutUint16(buf[6:], header.Flags)
+		binary.BigEndian.PutUint32(buf[8:], header.Length)
+	}
+}
+
+// checksumSize 带 FlagChecksum 的帧在 body 之后有 4 字节的 CRC32C, 覆盖帧头和 body, 计入 Length
+const checksumSize = 4
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// verifyChecksum 校验并去掉校验和, header.Length 改为 body 的长度
+func verifyChecksum(header *models.Header, body []byte) ([]byte, error) {
+	if header.Flags&constant.FlagChecksum == 0 {
+		return body, nil
+	}
+
+	size := headerSize(header.Version)
+	var headerBuf [models.MaxHeaderSize]byte
+	putHeader(headerBuf[:], header, size)
+
+	n := len(body) - checksumSize
+	crc := crc32.Update(crc32.Checksum(headerBuf[:size], castagnoli), castagnoli, body[:n])
+	if crc != binary.BigEndian.Uint32(body[n:]) {
+		return nil, errors.ErrChecksumMismatch
+	}
+	header.Length = uint32(n)
+	return body[:n], nil
+}
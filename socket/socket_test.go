@@ -0,0 +1,260 @@
+package socket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/brodyxchen/vsock-sdk/constant"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"io"
+	"testing"
+)
+
+func newHeader() *models.Header {
+	return &models.Header{Magic: constant.DefaultMagic, Version: constant.DefaultVersion, Code: 7}
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	var conn bytes.Buffer
+	writer := bufio.NewWriter(&conn)
+
+	frame := NewFrame(5)
+	frame.B = append(frame.B, "hello"...)
+	if _, err := WriteFrame(context.Background(), writer, newHeader(), frame); err != nil {
+		t.Fatal(err)
+	}
+	frame.Release()
+	if _, err := WriteSocket(context.Background(), writer, newHeader(), []byte("world")); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(&conn)
+	var header models.Header
+	buf, _, err := ReadFrame(context.Background(), reader, &header, constant.MaxFrameSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Code != 7 || header.Length != 5 || string(buf.B) != "hello" {
+		t.Fatalf("frame: %+v %q", header, buf.B)
+	}
+	buf.Release()
+
+	h, body, _, err := ReadSocket(context.Background(), reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Code != 7 || string(body) != "world" {
+		t.Fatalf("socket: %+v %q", h, body)
+	}
+
+	if _, _, err = ReadFrame(context.Background(), reader, &header, constant.MaxFrameSize); err != io.ErrUnexpectedEOF {
+		t.Fatalf("read at eof: %v", err)
+	}
+}
+
+func TestFrameVersions(t *testing.T) {
+	var conn bytes.Buffer
+	writer := bufio.NewWriter(&conn)
+
+	big := bytes.Repeat([]byte("x"), 70000)
+	v2 := &models.Header{Magic: constant.DefaultMagic, Version: 2, Code: 7, Flags: 3}
+	if _, err := WriteSocket(context.Background(), writer, v2, big); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := WriteSocket(context.Background(), writer, newHeader(), big); err != errors.ErrExceedBody {
+		t.Fatalf("v1 oversized body: %v", err)
+	}
+	v1 := newHeader()
+	v1.Flags = 1
+	if _, err := WriteSocket(context.Background(), writer, v1, nil); err != errors.ErrUnsupportedFlags {
+		t.Fatalf("v1 flags: %v", err)
+	}
+	if _, err := WriteSocket(context.Background(), writer, newHeader(), []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(&conn)
+	var header models.Header
+	buf, _, err := ReadFrame(context.Background(), reader, &header, constant.MaxFrameSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != 2 || header.Flags != 3 || header.Length != uint32(len(big)) || !bytes.Equal(buf.B, big) {
+		t.Fatalf("v2 frame: %+v", header)
+	}
+	buf.Release()
+
+	buf, _, err = ReadFrame(context.Background(), reader, &header, constant.MaxFrameSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != 1 || header.Flags != 0 || string(buf.B) != "v1" {
+		t.Fatalf("v1 frame: %+v %q", header, buf.B)
+	}
+	buf.Release()
+
+	// 超过上限和未知版本都无法继续读取
+	conn.Reset()
+	_, _ = WriteSocket(context.Background(), writer, v2, big)
+	if _, broken, err := ReadFrame(context.Background(), reader, &header, 1024); err != errors.ErrExceedBody || !broken {
+		t.Fatalf("exceed: %v %v", broken, err)
+	}
+	conn.Reset()
+	reader.Reset(&conn)
+	_, _ = conn.Write([]byte{0x16, 0x17, 0, 9, 0, 0, 0, 0})
+	if _, broken, err := ReadFrame(context.Background(), reader, &header, 1024); err != errors.ErrUnsupportedVersion || !broken {
+		t.Fatalf("unknown version: %v %v", broken, err)
+	}
+}
+
+func TestFrameChecksum(t *testing.T) {
+	var conn bytes.Buffer
+	writer := bufio.NewWriter(&conn)
+	header := &models.Header{Magic: constant.DefaultMagic, Version: 2, Code: 7, Flags: constant.FlagChecksum}
+
+	frame := NewFrame(5)
+	frame.B = append(frame.B, "hello"...)
+	for i := 0; i < 2; i++ { // 重复写出同一个帧
+		if _, err := WriteFrame(context.Background(), writer, header, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	frame.Release()
+	if _, err := WriteSocket(context.Background(), writer, header, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(conn.Bytes()))
+	var got models.Header
+	for i := 0; i < 2; i++ {
+		buf, _, err := ReadFrame(context.Background(), reader, &got, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Length != 5 || string(buf.B) != "hello" {
+			t.Fatalf("frame: %+v %q", got, buf.B)
+		}
+		buf.Release()
+	}
+	if buf, _, err := ReadFrame(context.Background(), reader, &got, 5); err != nil || buf != nil || got.Length != 0 {
+		t.Fatalf("empty frame: %+v %v", got, err)
+	}
+
+	// 帧头或 body 的任意一位出错都能发现
+	for _, pos := range []int{5, models.HeaderSizeV2 + 1, models.HeaderSizeV2 + 5} {
+		data := append([]byte(nil), conn.Bytes()...)
+		data[pos] ^= 0x10
+		reader = bufio.NewReader(bytes.NewReader(data))
+		if _, broken, err := ReadFrame(context.Background(), reader, &got, 5); err != errors.ErrChecksumMismatch || !broken {
+			t.Fatalf("corrupted byte %d: %v %v", pos, broken, err)
+		}
+	}
+}
+
+// legacyWriteSocket 改造前 WriteSocket 的实现, 作为基准
+func legacyWriteSocket(writer *bufio.Writer, header *models.Header, body []byte) error {
+	buf := make([]byte, models.HeaderSize+len(body))
+	binary.BigEndian.PutUint16(buf, header.Magic)
+	binary.BigEndian.PutUint16(buf[2:], header.Version)
+	binary.BigEndian.PutUint16(buf[4:], header.Code)
+	binary.BigEndian.PutUint16(buf[6:], uint16(len(body)))
+	copy(buf[models.HeaderSize:], body)
+	if _, err := writer.Write(buf); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// legacyReadSocket 改造前 ReadSocket 的实现, 作为基准
+func legacyReadSocket(reader *bufio.Reader) ([]byte, error) {
+	headerBuf := make([]byte, models.HeaderSize)
+	if _, err := io.ReadFull(reader, headerBuf); err != nil {
+		return nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint16(headerBuf[6:]))
+	_, err := io.ReadFull(reader, body)
+	return body, err
+}
+
+var benchBody = bytes.Repeat([]byte("x"), 1024)
+
+func BenchmarkWriteLegacy(b *testing.B) {
+	writer := bufio.NewWriter(io.Discard)
+	header := newHeader()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = legacyWriteSocket(writer, header, benchBody)
+	}
+}
+
+func BenchmarkWriteSocket(b *testing.B) {
+	writer := bufio.NewWriter(io.Discard)
+	header := newHeader()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = WriteSocket(context.Background(), writer, header, benchBody)
+	}
+}
+
+func BenchmarkWriteFrame(b *testing.B) {
+	writer := bufio.NewWriter(io.Discard)
+	header := newHeader()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		frame := NewFrame(len(benchBody))
+		frame.B = append(frame.B, benchBody...)
+		_, _ = WriteFrame(context.Background(), writer, header, frame)
+		frame.Release()
+	}
+}
+
+// repeatReader 无限重复同一帧
+type repeatReader struct {
+	frame []byte
+	off   int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], r.frame[r.off:])
+		n += c
+		r.off = (r.off + c) % len(r.frame)
+	}
+	return n, nil
+}
+
+func newRepeatReader() *bufio.Reader {
+	var conn bytes.Buffer
+	writer := bufio.NewWriter(&conn)
+	_ = legacyWriteSocket(writer, newHeader(), benchBody)
+	return bufio.NewReader(&repeatReader{frame: conn.Bytes()})
+}
+
+func BenchmarkReadLegacy(b *testing.B) {
+	reader := newRepeatReader()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = legacyReadSocket(reader)
+	}
+}
+
+func BenchmarkReadSocket(b *testing.B) {
+	reader := newRepeatReader()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _, _, _ = ReadSocket(context.Background(), reader)
+	}
+}
+
+func BenchmarkReadFrame(b *testing.B) {
+	reader := newRepeatReader()
+	var header models.Header
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf, _, _ := ReadFrame(context.Background(), reader, &header, constant.MaxFrameSize)
+		buf.Release()
+	}
+}
@@ -0,0 +1,483 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"github.com/brodyxchen/vsock-sdk/constant"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/protocols"
+	"github.com/brodyxchen/vsock-sdk/socket"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Stream 独占一条连接的双向流, client与server共用.
+//
+// 流控: 每端声明自己的接收窗口(字节), 发送方只有在持有对端授予的额度时才发送,
+// 接收方在应用层消费数据后再以 StreamWindow 帧归还额度, 所以任一端缓存的数据不会超过自己的窗口.
+//
+// 半关闭: CloseSend 之后本端不能再 Send, 对端 Recv 在读完缓存后得到 io.EOF.
+// 结束: server 的 handler 返回后发送 StreamEnd(携带状态) 并关闭连接;
+// client 的 ctx 结束或调用 Cancel 时发送 StreamCancel 并关闭连接, 两端未完成的 Send/Recv 立即返回错误.
+type Stream struct {
+	isClient bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+
+	writeMutex   sync.Mutex
+	writeTimeout time.Duration
+	protocol     models.Protocol
+
+	mutex      sync.Mutex // 守护以下变量
+	window     int        // 本端接收窗口
+	peerWindow int        // 对端接收窗口, 0代表还未收到
+	sendCredit int        // 剩余发送额度
+	sendClosed bool
+
+	recvQueue    [][]byte
+	recvBuffered int // 已缓存未消费
+	recvConsumed int // 已消费未归还
+	recvClosed   bool
+	peerErr      error // 对端结束状态
+	peerDone     bool  // 已收到 StreamEnd
+
+	closed   error
+	changed  chan struct{} // 状态变化时关闭并替换, 广播给所有等待者
+	closedCh chan struct{}
+	readDone chan struct{} // 读循环退出
+}
+
+type Options struct {
+	Window       int             // 本端接收窗口
+	PeerWindow   int             // server 从 StreamOpen 中得到; client 为0, 等待 server 授予
+	WriteTimeout time.Duration   // 每帧写超时
+	Protocol     models.Protocol // 连接上协商的结果, 零值为 models.LegacyProtocol
+	Auth         []byte          // client: StreamOpen 附带的认证数据
+}
+
+func newStream(ctx context.Context, timeout time.Duration, isClient bool, conn net.Conn, reader *bufio.Reader, writer *bufio.Writer, opts Options) *Stream {
+	window := opts.Window
+	if window <= 0 {
+		window = constant.StreamWindowSize
+	}
+
+	protocol := opts.Protocol
+	if protocol.Version == 0 {
+		protocol = models.LegacyProtocol
+	}
+
+	s := &Stream{
+		isClient:     isClient,
+		conn:         conn,
+		reader:       reader,
+		writer:       writer,
+		writeTimeout: opts.WriteTimeout,
+		protocol:     protocol,
+		window:       window,
+		peerWindow:   opts.PeerWindow,
+		sendCredit:   opts.PeerWindow,
+		changed:      make(chan struct{}),
+		closedCh:     make(chan struct{}),
+		readDone:     make(chan struct{}),
+	}
+	if timeout > 0 {
+		s.ctx, s.cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		s.ctx, s.cancel = context.WithCancel(ctx)
+	}
+	return s
+}
+
+// NewClient 发送 StreamOpen 并启动读循环
+func NewClient(ctx context.Context, conn net.Conn, reader *bufio.Reader, writer *bufio.Writer, path string, opts Options) (*Stream, error) {
+	opts.PeerWindow = 0
+	s := newStream(ctx, 0, true, conn, reader, writer, opts)
+
+	open := &protocols.StreamFrame{
+		Type:   protocols.StreamOpen,
+		Path:   path,
+		Window: uint32(s.window),
+		Auth:   opts.Auth,
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline).Milliseconds()
+		if timeout <= 0 {
+			s.cancel()
+			return nil, errors.StatusDeadlineExceeded
+		}
+		open.Timeout = timeout
+	}
+	if err := s.writeFrame(open); err != nil {
+		s.cancel()
+		return nil, err
+	}
+
+	go s.readLoop()
+	go s.watch()
+	return s, nil
+}
+
+// NewServer 由server在读到 StreamOpen 后创建, 立即授予对端初始窗口
+func NewServer(ctx context.Context, conn net.Conn, reader *bufio.Reader, writer *bufio.Writer, open *protocols.StreamFrame, opts Options) (*Stream, error) {
+	opts.PeerWindow = int(open.Window)
+	if opts.PeerWindow <= 0 {
+		opts.PeerWindow = constant.StreamWindowSize
+	}
+	s := newStream(ctx, time.Duration(open.Timeout)*time.Millisecond, false, conn, reader, writer, opts)
+
+	if err := s.writeFrame(&protocols.StreamFrame{Type: protocols.StreamWindow, Window: uint32(s.window)}); err != nil {
+		s.cancel()
+		return nil, err
+	}
+
+	go s.readLoop()
+	go s.watch()
+	return s, nil
+}
+
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+// Send 阻塞直到获得足够的发送额度, 超过对端窗口或协商的最大帧的消息返回 ErrStreamTooLarge
+func (s *Stream) Send(data []byte) error {
+	// v1 的最大帧小于默认窗口, 整个窗口大小的消息也可能写不出, 写出失败会结束整个流
+	frame := &protocols.StreamFrame{Type: protocols.StreamData, Data: data}
+	if proto.Size(frame) > s.protocol.MaxFrameSize {
+		return errors.ErrStreamTooLarge
+	}
+
+	s.mutex.Lock()
+	for {
+		if s.peerDone {
+			s.mutex.Unlock()
+			if s.peerErr != nil {
+				return s.peerErr
+			}
+			return errors.ErrStreamClosed
+		}
+		if s.closed != nil {
+			s.mutex.Unlock()
+			return s.closed
+		}
+		if s.sendClosed {
+			s.mutex.Unlock()
+			return errors.ErrStreamSendClosed
+		}
+
+		if s.peerWindow > 0 {
+			// 超过对端窗口的消息永远不会获得足够的额度, 流仍然可用
+			c := cost(len(data))
+			if c > s.peerWindow {
+				s.mutex.Unlock()
+				return errors.ErrStreamTooLarge
+			}
+			if s.sendCredit >= c {
+				s.sendCredit -= c
+				break
+			}
+		}
+
+		changed := s.changed
+		s.mutex.Unlock()
+		<-changed
+		s.mutex.Lock()
+	}
+	s.mutex.Unlock()
+
+	err := s.writeFrame(frame)
+	if err != nil {
+		s.terminate(err)
+		return err
+	}
+	return nil
+}
+
+// Recv 读完缓存后, 对端半关闭返回 io.EOF, 对端以错误结束返回该错误
+func (s *Stream) Recv() ([]byte, error) {
+	s.mutex.Lock()
+	for {
+		if s.closed != nil && !s.peerDone {
+			s.mutex.Unlock()
+			return nil, s.closed
+		}
+
+		if len(s.recvQueue) > 0 {
+			data := s.recvQueue[0]
+			s.recvQueue[0] = nil
+			s.recvQueue = s.recvQueue[1:]
+
+			c := cost(len(data))
+			s.recvBuffered -= c
+			s.recvConsumed += c
+
+			var update int
+			if !s.recvClosed && s.recvConsumed >= s.window/2 {
+				update = s.recvConsumed
+				s.recvConsumed = 0
+			}
+			s.mutex.Unlock()
+
+			if update > 0 {
+				if err := s.writeFrame(&protocols.StreamFrame{Type: protocols.StreamWindow, Window: uint32(update)}); err != nil {
+					s.terminate(err)
+				}
+			}
+			return data, nil
+		}
+
+		if s.recvClosed {
+			s.mutex.Unlock()
+			if s.peerErr != nil {
+				return nil, s.peerErr
+			}
+			return nil, io.EOF
+		}
+
+		changed := s.changed
+		s.mutex.Unlock()
+		<-changed
+		s.mutex.Lock()
+	}
+}
+
+// CloseSend 半关闭
+func (s *Stream) CloseSend() error {
+	s.mutex.Lock()
+	if s.closed != nil {
+		s.mutex.Unlock()
+		return s.closed
+	}
+	if s.sendClosed || s.peerDone {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.sendClosed = true
+	s.broadcastLocked()
+	s.mutex.Unlock()
+
+	err := s.writeFrame(&protocols.StreamFrame{Type: protocols.StreamClose})
+	if err != nil {
+		s.terminate(err)
+	}
+	return err
+}
+
+// Cancel client 主动取消
+func (s *Stream) Cancel() {
+	s.terminate(errors.ErrStreamCanceled)
+}
+
+// Finish server 的 handler 返回后调用, 发送结束状态并关闭连接
+func (s *Stream) Finish(status *errors.Status) {
+	frame := &protocols.StreamFrame{Type: protocols.StreamEnd, Code: protocols.StatusOK}
+	if status != nil {
+		frame.Code = int32(status.Code())
+		frame.Err = status.Error()
+		frame.RetryAfter = status.RetryAfterMillis()
+	}
+
+	s.mutex.Lock()
+	closed := s.closed
+	s.mutex.Unlock()
+
+	if closed != errors.ErrStreamCanceled {
+		_ = s.writeFrame(frame)
+	}
+	s.terminate(errors.ErrStreamClosed)
+	_ = s.conn.Close()
+
+	<-s.readDone // 调用方回收 reader 之前, 读循环必须已退出
+}
+
+// Done 流结束后关闭
+func (s *Stream) Done() <-chan struct{} {
+	return s.closedCh
+}
+
+func (s *Stream) Err() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closed
+}
+
+func (s *Stream) readLoop() {
+	defer close(s.readDone)
+
+	for {
+		var header models.Header
+		buf, _, err := socket.ReadFrame(context.Background(), s.reader, &header, s.protocol.MaxFrameSize)
+		if err != nil {
+			s.terminate(err)
+			return
+		}
+		if err = s.protocol.Check(&header); err != nil {
+			buf.Release()
+			s.terminate(err)
+			return
+		}
+		if header.Code != constant.ActionStream || buf == nil {
+			buf.Release()
+			s.terminate(errors.ErrStreamInvalidFrame)
+			return
+		}
+
+		var frame protocols.StreamFrame
+		err = proto.Unmarshal(buf.B, &frame) // Data 被拷贝
+		buf.Release()
+		if err != nil {
+			s.terminate(errors.ErrStreamInvalidFrame)
+			return
+		}
+
+		if !s.handleFrame(&frame) {
+			return
+		}
+	}
+}
+
+// handleFrame 返回false时读循环退出
+func (s *Stream) handleFrame(frame *protocols.StreamFrame) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed != nil {
+		return false
+	}
+
+	switch frame.Type {
+	case protocols.StreamData:
+		if s.recvClosed {
+			s.terminateLocked(errors.ErrStreamInvalidFrame)
+			return false
+		}
+		c := cost(len(frame.Data))
+		if s.recvBuffered+c > s.window {
+			s.terminateLocked(errors.ErrStreamFlowControl)
+			return false
+		}
+		s.recvBuffered += c
+		s.recvQueue = append(s.recvQueue, frame.Data)
+	case protocols.StreamWindow:
+		if s.peerWindow == 0 {
+			s.peerWindow = int(frame.Window)
+		}
+		s.sendCredit += int(frame.Window)
+	case protocols.StreamClose:
+		s.recvClosed = true
+	case protocols.StreamCancel:
+		if s.isClient {
+			s.terminateLocked(errors.ErrStreamInvalidFrame)
+			return false
+		}
+		s.terminateLocked(errors.ErrStreamCanceled)
+		return false
+	case protocols.StreamEnd:
+		if !s.isClient {
+			s.terminateLocked(errors.ErrStreamInvalidFrame)
+			return false
+		}
+		s.recvClosed = true
+		s.peerDone = true
+		if frame.Code != protocols.StatusOK {
+			s.peerErr = errors.NewStatus(uint16(frame.Code), frame.Err).WithRetryAfter(time.Duration(frame.RetryAfter) * time.Millisecond)
+		}
+		s.terminateLocked(errors.ErrStreamClosed)
+		return false
+	default:
+		s.terminateLocked(errors.ErrStreamInvalidFrame)
+		return false
+	}
+
+	s.broadcastLocked()
+	return true
+}
+
+// watch ctx结束(超时/取消/terminate) 后的收尾
+func (s *Stream) watch() {
+	<-s.ctx.Done()
+
+	s.mutex.Lock()
+	if s.closed == nil {
+		if s.ctx.Err() == context.DeadlineExceeded {
+			s.terminateLocked(errors.StatusDeadlineExceeded)
+		} else {
+			s.terminateLocked(errors.ErrStreamCanceled)
+		}
+	}
+	closed := s.closed
+	peerDone := s.peerDone
+	s.mutex.Unlock()
+
+	if !s.isClient {
+		return // server 由 Finish 关闭连接
+	}
+
+	if !peerDone && closed != errors.ErrStreamClosed {
+		_ = s.writeFrame(&protocols.StreamFrame{Type: protocols.StreamCancel})
+	}
+	_ = s.conn.Close()
+}
+
+func (s *Stream) terminate(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.terminateLocked(err)
+}
+
+func (s *Stream) terminateLocked(err error) {
+	if s.closed != nil {
+		return
+	}
+	s.closed = err
+	close(s.closedCh)
+	s.broadcastLocked()
+	s.cancel()
+}
+
+func (s *Stream) broadcastLocked() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Stream) writeFrame(frame *protocols.StreamFrame) error {
+	buf := socket.NewFrame(proto.Size(frame))
+	defer buf.Release()
+	var err error
+	buf.B, err = proto.MarshalOptions{}.MarshalAppend(buf.B, frame)
+	if err != nil {
+		return err
+	}
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	if s.writeTimeout > 0 {
+		_ = s.conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+	}
+	header := &models.Header{
+		Magic:   constant.DefaultMagic,
+		Version: s.protocol.Version,
+		Code:    constant.ActionStream,
+		Flags:   s.protocol.FrameFlags(),
+	}
+	_, err = socket.WriteFrame(context.Background(), s.writer, header, buf)
+	return err
+}
+
+// cost 一条消息占用的窗口, 空消息也占1
+func cost(n int) int {
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
@@ -0,0 +1,217 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"github.com/brodyxchen/vsock-sdk/constant"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/protocols"
+	"github.com/brodyxchen/vsock-sdk/socket"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func openPair(t *testing.T, ctx context.Context, clientWindow, serverWindow int) (*Stream, *Stream) {
+	cliConn, srvConn := net.Pipe()
+
+	type result struct {
+		s   *Stream
+		err error
+	}
+	srvCh := make(chan result, 1)
+	go func() {
+		reader := bufio.NewReader(srvConn)
+		_, body, _, err := socket.ReadSocket(context.Background(), reader)
+		if err != nil {
+			srvCh <- result{nil, err}
+			return
+		}
+		var open protocols.StreamFrame
+		if err = proto.Unmarshal(body, &open); err != nil {
+			srvCh <- result{nil, err}
+			return
+		}
+		s, err := NewServer(context.Background(), srvConn, reader, bufio.NewWriter(srvConn), &open, Options{Window: serverWindow})
+		srvCh <- result{s, err}
+	}()
+
+	cli, err := NewClient(ctx, cliConn, bufio.NewReader(cliConn), bufio.NewWriter(cliConn), "echo", Options{Window: clientWindow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := <-srvCh
+	if rs.err != nil {
+		t.Fatal(rs.err)
+	}
+	return cli, rs.s
+}
+
+func TestStreamHalfClose(t *testing.T) {
+	cli, srv := openPair(t, context.Background(), 0, 0)
+
+	go func() {
+		for {
+			msg, err := srv.Recv()
+			if err == io.EOF {
+				srv.Finish(nil)
+				return
+			}
+			if err != nil {
+				srv.Finish(errors.NewStatus(500, err.Error()))
+				return
+			}
+			_ = srv.Send(append([]byte("echo:"), msg...))
+		}
+	}()
+
+	for _, msg := range []string{"a", "b", "c"} {
+		if err := cli.Send([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cli.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Send([]byte("d")); err != errors.ErrStreamSendClosed {
+		t.Fatalf("send after close: %v", err)
+	}
+
+	for _, want := range []string{"echo:a", "echo:b", "echo:c"} {
+		got, err := cli.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+	if _, err := cli.Recv(); err != io.EOF {
+		t.Fatalf("recv after end: %v", err)
+	}
+}
+
+func TestStreamFlowControl(t *testing.T) {
+	cli, srv := openPair(t, context.Background(), 0, 4)
+	defer srv.Finish(nil)
+
+	for i := 0; i < 4; i++ {
+		if err := cli.Send([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sent := make(chan error, 1)
+	go func() {
+		sent <- cli.Send([]byte{4})
+	}()
+
+	select {
+	case err := <-sent:
+		t.Fatalf("send beyond window returned early: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// 消费半个窗口后归还额度
+	for i := 0; i < 2; i++ {
+		if _, err := srv.Recv(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("send still blocked after window update")
+	}
+}
+
+func TestStreamTooLarge(t *testing.T) {
+	cli, srv := openPair(t, context.Background(), 0, 4)
+	defer srv.Finish(nil)
+
+	if err := cli.Send([]byte("12345")); err != errors.ErrStreamTooLarge {
+		t.Fatalf("oversize send: %v", err)
+	}
+	// 流仍然可用, 整个窗口大小的消息可以发送
+	if err := cli.Send([]byte("1234")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := srv.Recv(); err != nil || string(got) != "1234" {
+		t.Fatalf("recv: %q %v", got, err)
+	}
+}
+
+func TestStreamTooLargeV1(t *testing.T) {
+	// 没有握手的连接使用 v1, 最大帧小于默认窗口
+	cli, srv := openPair(t, context.Background(), 0, 0)
+	defer srv.Finish(nil)
+
+	if err := cli.Send(make([]byte, constant.StreamWindowSize)); err != errors.ErrStreamTooLarge {
+		t.Fatalf("full window send: %v", err)
+	}
+	// 流仍然可用, 恰好一个 v1 帧的消息可以发送
+	n := constant.MaxFrameSizeV1
+	for proto.Size(&protocols.StreamFrame{Type: protocols.StreamData, Data: make([]byte, n)}) > constant.MaxFrameSizeV1 {
+		n--
+	}
+	if err := cli.Send(make([]byte, n+1)); err != errors.ErrStreamTooLarge {
+		t.Fatalf("oversize send: %v", err)
+	}
+	if err := cli.Send(make([]byte, n)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := srv.Recv(); err != nil || len(got) != n {
+		t.Fatalf("recv: %d %v", len(got), err)
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	cli, srv := openPair(t, context.Background(), 0, 0)
+	defer srv.Finish(nil)
+
+	cli.Cancel()
+
+	if _, err := srv.Recv(); err != errors.ErrStreamCanceled {
+		t.Fatalf("server recv after cancel: %v", err)
+	}
+	if err := cli.Send([]byte("x")); err != errors.ErrStreamCanceled {
+		t.Fatalf("client send after cancel: %v", err)
+	}
+}
+
+func TestStreamDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	cli, srv := openPair(t, ctx, 0, 0)
+	defer srv.Finish(nil)
+
+	if _, err := cli.Recv(); err != errors.StatusDeadlineExceeded {
+		t.Fatalf("client recv after deadline: %v", err)
+	}
+	select {
+	case <-srv.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("server context not done after client deadline")
+	}
+}
+
+func TestStreamEndStatus(t *testing.T) {
+	cli, srv := openPair(t, context.Background(), 0, 0)
+
+	srv.Finish(errors.NewStatus(409, "conflict"))
+
+	_, err := cli.Recv()
+	st, ok := err.(*errors.Status)
+	if !ok || st.Code() != 409 {
+		t.Fatalf("recv status: %v", err)
+	}
+	if err = cli.Send([]byte("x")); err != st {
+		t.Fatalf("send after end: %v", err)
+	}
+}
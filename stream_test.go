@@ -0,0 +1,103 @@
+package vsock_sdk
+
+import (
+	"context"
+	"github.com/brodyxchen/vsock-sdk/client"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/stream"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestClientAndBidiStream(t *testing.T) {
+	addr := &models.HttpAddr{IP: "127.0.0.1", Port: 7071}
+
+	srv := NewServer(addr)
+	srv.StreamWindowSize = 16
+	srv.HandleClientStream("upload", func(s *stream.Stream) ([]byte, error) {
+		total := 0
+		for {
+			chunk, err := s.Recv()
+			if err == io.EOF {
+				return []byte(strconv.Itoa(total)), nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			total += len(chunk)
+		}
+	})
+	srv.HandleBidiStream("echo", func(s *stream.Stream) error {
+		for {
+			msg, err := s.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err = s.Send(msg); err != nil {
+				return err
+			}
+		}
+	})
+	go func() {
+		_ = srv.ListenAndServe()
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	cli := NewClient(&client.Config{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	up, err := cli.NewClientStream(ctx, addr, "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err = up.Send(make([]byte, 10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rsp, err := up.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rsp) != "1000" {
+		t.Fatalf("upload rsp %q", rsp)
+	}
+
+	bidi, err := cli.NewBidiStream(ctx, addr, "echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		msg := []byte(strconv.Itoa(i))
+		if err = bidi.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+		got, err := bidi.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(msg) {
+			t.Fatalf("echo got %q want %q", got, msg)
+		}
+	}
+	_ = bidi.CloseSend()
+	if _, err = bidi.Recv(); err != io.EOF {
+		t.Fatalf("echo end: %v", err)
+	}
+
+	missing, err := cli.NewBidiStream(ctx, addr, "missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = missing.Recv()
+	if st, ok := err.(*errors.Status); !ok || st.Code() != errors.StatusInvalidPath.Code() {
+		t.Fatalf("missing path: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package vsock_sdk
+
+import (
+	"github.com/brodyxchen/vsock-sdk/client"
+	"github.com/brodyxchen/vsock-sdk/errors"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/server"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientTargetBalancer(t *testing.T) {
+	addrs := []models.Addr{
+		&models.HttpAddr{IP: "127.0.0.1", Port: 7090},
+		&models.HttpAddr{IP: "127.0.0.1", Port: 7091},
+		&models.HttpAddr{IP: "127.0.0.1", Port: 7092},
+	}
+	release := make(chan struct{})
+	servers := make([]*server.Server, 0, len(addrs))
+	for _, addr := range addrs {
+		name := addr.GetAddr()
+		srv := NewServer(addr)
+		srv.HandleFunc("whoami", func(req []byte) ([]byte, error) {
+			return []byte(name), nil
+		})
+		srv.HandleFunc("block", func(req []byte) ([]byte, error) {
+			<-release
+			return []byte(name), nil
+		})
+		servers = append(servers, srv)
+	}
+	for _, srv := range servers {
+		go func(srv *server.Server) {
+			_ = srv.ListenAndServe()
+		}(srv)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	cli := NewClient(&client.Config{Timeout: time.Second})
+
+	// 轮询
+	target := client.NewTarget("replicas", nil, addrs...)
+	counts := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		rsp, err := cli.Do(target, "whoami", nil)
+		if err != nil {
+			t.Fatalf("round robin %d: %v", i, err)
+		}
+		counts[string(rsp)]++
+	}
+	for _, addr := range addrs {
+		if counts[addr.GetAddr()] != 2 {
+			t.Fatalf("round robin: %v", counts)
+		}
+	}
+
+	// 不可用的地址不参与选择
+	target.Endpoint(addrs[0]).SetHealthy(false)
+	for i := 0; i < 4; i++ {
+		rsp, err := cli.Do(target, "whoami", nil)
+		if err != nil || string(rsp) == addrs[0].GetAddr() {
+			t.Fatalf("unhealthy: %q %v", rsp, err)
+		}
+	}
+	for _, ep := range target.Endpoints() {
+		ep.SetHealthy(false)
+	}
+	if _, err := cli.Do(target, "whoami", nil); err != errors.ErrNoHealthyEndpoints {
+		t.Fatalf("no healthy: %v", err)
+	}
+	if _, err := cli.Do(client.NewTarget("empty", nil), "whoami", nil); err != errors.ErrNoEndpoints {
+		t.Fatalf("empty: %v", err)
+	}
+	for _, ep := range target.Endpoints() {
+		ep.SetHealthy(true)
+	}
+	// 不选择地址的路径不能直接拨号 Target
+	if _, err := cli.DialTest(target); err != errors.ErrUnsupportedAddr {
+		t.Fatalf("dial target: %v", err)
+	}
+
+	// 两个地址各有一个阻塞中的调用, 换入空闲的地址后都选择它
+	for _, balancer := range []client.Balancer{client.LeastOutstanding{}, client.P2C{}} {
+		busy := client.NewTarget("busy", &client.RoundRobin{}, addrs[0], addrs[1])
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _ = cli.Do(busy, "block", nil)
+			}()
+		}
+		ep0, ep1 := busy.Endpoint(addrs[0]), busy.Endpoint(addrs[1])
+		deadline := time.Now().Add(time.Second)
+		for ep0.Outstanding() != 1 || ep1.Outstanding() != 1 {
+			if time.Now().After(deadline) {
+				t.Fatalf("%T: outstanding not visible", balancer)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		busy.SetEndpoints([]models.Addr{addrs[0], addrs[2]})
+		busy.Balancer = balancer
+		for i := 0; i < 4; i++ {
+			rsp, err := cli.Do(busy, "whoami", nil)
+			if err != nil || string(rsp) != addrs[2].GetAddr() {
+				t.Fatalf("%T: %q %v", balancer, rsp, err)
+			}
+		}
+		release <- struct{}{}
+		release <- struct{}{}
+		wg.Wait()
+		if ep0.Outstanding() != 0 || ep1.Outstanding() != 0 {
+			t.Fatalf("%T: outstanding not released", balancer)
+		}
+	}
+}
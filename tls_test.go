@@ -0,0 +1,129 @@
+package vsock_sdk
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/brodyxchen/vsock-sdk/client"
+	"github.com/brodyxchen/vsock-sdk/models"
+	"github.com/brodyxchen/vsock-sdk/server"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// issue 由 ca 签发证书, ca 为 nil 时自签名
+func issue(t *testing.T, cn string, ca *tls.Certificate) tls.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	parent, signer := tmpl, interface{}(key)
+	if ca == nil {
+		tmpl.IsCA = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		tmpl.BasicConstraintsValid = true
+	} else {
+		parent, signer = ca.Leaf, ca.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestClientMutualTLS(t *testing.T) {
+	ca := issue(t, "ca", nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	addr := &models.HttpAddr{IP: "127.0.0.1", Port: 7080}
+	srv := NewServer(addr)
+	srv.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{issue(t, "server", &ca)},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	// 回复调用方证书的 CN
+	srv.HandleContextFunc("whoami", func(ctx context.Context, req []byte) ([]byte, error) {
+		certs := server.PeerCertificates(ctx)
+		if len(certs) == 0 {
+			return nil, nil
+		}
+		return []byte(certs[0].Subject.CommonName), nil
+	})
+	go func() {
+		_ = srv.ListenAndServe()
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	alice := &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{issue(t, "alice", &ca)}}
+	bob := &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{issue(t, "bob", &ca)}}
+	cli := NewClient(&client.Config{Timeout: time.Second, TLSConfig: alice})
+
+	// 交替使用两个身份, 连接不能混用
+	for i := 0; i < 2; i++ {
+		rsp, err := cli.DoContext(context.Background(), addr, "whoami", nil)
+		if err != nil || string(rsp) != "alice" {
+			t.Fatalf("alice: %q %v", rsp, err)
+		}
+		rsp, err = cli.DoContext(client.WithTLSConfig(context.Background(), bob), addr, "whoami", nil)
+		if err != nil || string(rsp) != "bob" {
+			t.Fatalf("bob: %q %v", rsp, err)
+		}
+	}
+
+	// 没有客户端证书时握手失败
+	anonymous := NewClient(&client.Config{Timeout: time.Second, TLSConfig: &tls.Config{RootCAs: pool}})
+	if _, err := anonymous.Do(addr, "whoami", nil); err == nil {
+		t.Fatal("client without certificate accepted")
+	}
+}
+
+func TestClientTLSVerification(t *testing.T) {
+	ca := issue(t, "ca", nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	other := issue(t, "other", nil)
+	otherPool := x509.NewCertPool()
+	otherPool.AddCert(other.Leaf)
+
+	addr := &models.HttpAddr{IP: "127.0.0.1", Port: 7105}
+	srv := NewServer(addr)
+	srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{issue(t, "server", &ca)}}
+	srv.HandleFunc("ping", func(req []byte) ([]byte, error) {
+		return []byte("pong"), nil
+	})
+	go func() {
+		_ = srv.ListenAndServe()
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// 不校验证书的连接进入连接池
+	cli := NewClient(&client.Config{Timeout: time.Second, TLSConfig: &tls.Config{InsecureSkipVerify: true}})
+	if rsp, err := cli.Do(addr, "ping", nil); err != nil || string(rsp) != "pong" {
+		t.Fatalf("insecure: %q %v", rsp, err)
+	}
+
+	// 要求校验的调用不能复用它, 证书不被信任时握手失败
+	strict := client.WithTLSConfig(context.Background(), &tls.Config{RootCAs: otherPool})
+	if _, err := cli.DoContext(strict, addr, "ping", nil); err == nil {
+		t.Fatal("strict call reused an unverified connection")
+	}
+	trusted := client.WithTLSConfig(context.Background(), &tls.Config{RootCAs: pool})
+	if rsp, err := cli.DoContext(trusted, addr, "ping", nil); err != nil || string(rsp) != "pong" {
+		t.Fatalf("trusted: %q %v", rsp, err)
+	}
+}